@@ -6,8 +6,11 @@
 package reqtruct
 
 import (
+	"encoding/json"
 	"errors"
+	"net/textproto"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,9 +23,10 @@ var invalidPath = errors.New("invalid path")
 func newCache() *cache {
 	c := cache{
 		m:               make(map[reflect.Type]*structInfo),
-		regconv:         make(map[reflect.Type]Converter),
-		sep:             '.',
+		regconv:         make(map[reflect.Type]fieldConverter),
+		disallowed:      make(map[reflect.Type]error),
 		defaultLocation: LocationJSON,
+		tagPriority:     defaultTagPriority,
 	}
 	return &c
 }
@@ -31,24 +35,90 @@ func newCache() *cache {
 type cache struct {
 	l       sync.RWMutex
 	m       map[reflect.Type]*structInfo
-	regconv map[reflect.Type]Converter
-
-	sepLeft  rune
-	sepRight rune
-	sep      rune
+	regconv map[reflect.Type]fieldConverter
+	// disallowed are the results of checking the structs against the
+	// locations disallowed by the decoder, which are not shared by clones.
+	disallowed map[reflect.Type]error
 
 	defaultLocation int
+	tagPriority     []int
 	nameFunc        func(string, []int) string
+	keyNormalizer   func(string) string
+	prefixEmbedded  bool
+	decimalSep      rune
+	jsonFallback    bool
 }
 
 // registerConverter registers a converter function for a custom type.
 func (c *cache) registerConverter(value interface{}, converterFunc Converter) {
-	c.regconv[reflect.TypeOf(value)] = converterFunc
+	c.regconv[reflect.TypeOf(value)] = func(value string, _ reflect.StructField) (reflect.Value, error) {
+		return converterFunc(value), nil
+	}
+	c.reset()
+}
+
+// registerConverterErr registers a converter function returning errors for a custom type.
+func (c *cache) registerConverterErr(value interface{}, converterFunc ConverterErr) {
+	c.regconv[reflect.TypeOf(value)] = func(value string, _ reflect.StructField) (reflect.Value, error) {
+		return converterFunc(value)
+	}
+	c.reset()
+}
+
+// registerConverterWithField registers a field aware converter function for a custom type.
+func (c *cache) registerConverterWithField(value interface{}, converterFunc ConverterWithField) {
+	c.regconv[reflect.TypeOf(value)] = func(value string, field reflect.StructField) (reflect.Value, error) {
+		return converterFunc(value, field), nil
+	}
+	c.reset()
+}
+
+// clone returns a copy of the cache sharing the cached struct information.
+// The converters and the options are copied, so they can be changed
+// independently.
+func (c *cache) clone() *cache {
+	c.l.RLock()
+	defer c.l.RUnlock()
+	n := &cache{
+		m:               make(map[reflect.Type]*structInfo, len(c.m)),
+		regconv:         make(map[reflect.Type]fieldConverter, len(c.regconv)),
+		disallowed:      make(map[reflect.Type]error),
+		defaultLocation: c.defaultLocation,
+		tagPriority:     c.tagPriority,
+		nameFunc:        c.nameFunc,
+		keyNormalizer:   c.keyNormalizer,
+		prefixEmbedded:  c.prefixEmbedded,
+		decimalSep:      c.decimalSep,
+		jsonFallback:    c.jsonFallback,
+	}
+	for t, info := range c.m {
+		n.m[t] = info
+	}
+	for t, conv := range c.regconv {
+		n.regconv[t] = conv
+	}
+	return n
+}
+
+// reset drops the cached struct information after a change
+// in the options it depends on.
+func (c *cache) reset() {
+	c.l.Lock()
+	c.m = make(map[reflect.Type]*structInfo)
+	c.disallowed = make(map[reflect.Type]error)
+	c.l.Unlock()
+}
+
+// separators are the runes used to split paths.
+type separators struct {
+	left  rune
+	right rune
+	sep   rune
 }
 
-// splitPath splits a path according to the separators defined in cache
-func (c *cache) splitPath(path string) ([]string, error) {
-	if c.sepLeft != 0 && c.sepRight != 0 {
+// splitPath splits a path according to the separators
+func (s separators) splitPath(path string) ([]string, error) {
+	if s.left != 0 && s.right != 0 {
 		var parts []string
 		var runes []rune
 		isFirstLevel := true
@@ -57,18 +127,18 @@ func (c *cache) splitPath(path string) ([]string, error) {
 			if unicode.IsSpace(r) {
 				return nil, invalidPath
 			}
-			if r == c.sepLeft || r == c.sepRight || r == c.sep {
+			if r == s.left || r == s.right || r == s.sep {
 				if i == 0 || rune(path[i-1]) == r {
 					return nil, invalidPath
 				}
-				if i == len(path)-1 && rune(path[i]) != c.sepRight {
+				if i == len(path)-1 && rune(path[i]) != s.right {
 					return nil, invalidPath
 				}
-				if r == c.sepLeft {
-					if !isFirstLevel && c.sep != 0 && rune(path[i-1]) != c.sep {
+				if r == s.left {
+					if !isFirstLevel && s.sep != 0 && rune(path[i-1]) != s.sep {
 						return nil, invalidPath
 					}
-					if len(runes) == 0 && (i != len(path)-2 || (i == len(path)-2 && rune(path[i+1]) != c.sepRight)) {
+					if len(runes) == 0 && (i != len(path)-2 || (i == len(path)-2 && rune(path[i+1]) != s.right)) {
 						return nil, invalidPath
 					}
 					if opened {
@@ -78,7 +148,7 @@ func (c *cache) splitPath(path string) ([]string, error) {
 					runes = nil
 					isFirstLevel = false
 					opened = true
-				} else if r == c.sepRight {
+				} else if r == s.right {
 					if !opened {
 						return nil, invalidPath
 					}
@@ -87,11 +157,11 @@ func (c *cache) splitPath(path string) ([]string, error) {
 						runes = nil
 					}
 					opened = false
-				} else if r == c.sep {
-					if !opened && !isFirstLevel && rune(path[i-1]) != c.sepRight {
+				} else if r == s.sep {
+					if !opened && !isFirstLevel && rune(path[i-1]) != s.right {
 						return nil, invalidPath
 					}
-					if !opened && rune(path[i+1]) != c.sepLeft {
+					if !opened && rune(path[i+1]) != s.left {
 						return nil, invalidPath
 					}
 					if opened {
@@ -113,25 +183,80 @@ func (c *cache) splitPath(path string) ([]string, error) {
 		}
 		return parts, nil
 	} else {
-		return strings.Split(path, string(c.sep)), nil
+		return strings.Split(path, string(s.sep)), nil
+	}
+}
+
+// joinPath joins path parts according to the separators.
+// It is the inverse of splitPath.
+func (s separators) joinPath(parts []string) string {
+	if s.left != 0 && s.right != 0 {
+		var b strings.Builder
+		b.WriteString(parts[0])
+		for i, part := range parts[1:] {
+			if i > 0 && s.sep != 0 {
+				b.WriteRune(s.sep)
+			}
+			b.WriteRune(s.left)
+			b.WriteString(part)
+			b.WriteRune(s.right)
+		}
+		return b.String()
+	}
+	return strings.Join(parts, string(s.sep))
+}
+
+// parallelIndex returns the position in keys where a slice index is missing
+// after a slice of structs, or -1 if there is none.
+func (c *cache) parallelIndex(keys []string, t reflect.Type) int {
+	for i := 0; i < len(keys); i++ {
+		t = indirectType(t)
+		if t.Kind() != reflect.Struct {
+			return -1
+		}
+		field := c.get(t).get(keys[i])
+		if field == nil {
+			return -1
+		}
+		if field.isIndexed() {
+			if i+1 >= len(keys) {
+				return -1
+			}
+			if _, err := strconv.ParseInt(keys[i+1], 10, 0); err != nil {
+				return i + 1
+			}
+			i++
+			t = underlyingElem(field.typ)
+		} else {
+			t = field.typ
+		}
 	}
+	return -1
 }
 
 // parsePath returns "path parts" which contain indices to fields to be used by
 // reflect.Value.FieldByName(). Multiple parts are required for slices of
 // structs.
-func (c *cache) parsePath(p string, t reflect.Type, location int) ([]pathPart, error) {
+// If location is locationNone then the locations of the fields are not checked.
+// If flat is true then keys containing separators, like "user.name",
+// match fields with such aliases before nested fields.
+// If exact is true then the keys must match the aliases exactly
+// rather than case-insensitively.
+func (c *cache) parsePath(p string, t reflect.Type, location int, s separators, flat bool, exact bool) ([]pathPart, error) {
 	var struc *structInfo
 	var field *fieldInfo
 	var index64 int64
+	index := -1
+	mapKey := ""
 	parts := make([]pathPart, 0)
 	path := make([]string, 0)
-	keys, err := c.splitPath(p)
+	keys, err := s.splitPath(p)
 	if err != nil {
 		return nil, err
 	}
 
 	var lastDefinedLocations []int
+	var methods []string
 	for i := 0; i < len(keys); i++ {
 		if t.Kind() != reflect.Struct {
 			return nil, invalidPath
@@ -139,17 +264,25 @@ func (c *cache) parsePath(p string, t reflect.Type, location int) ([]pathPart, e
 		if struc = c.get(t); struc == nil {
 			return nil, invalidPath
 		}
-		if field = struc.get(keys[i]); field == nil {
+		if f, n := struc.flatField(keys[i:], s, flat, exact); f != nil {
+			field = f
+			i += n - 1
+		} else if field = struc.lookup(keys[i], exact); field == nil {
 			return nil, invalidPath
 		}
 		if field.locationsDefined {
 			lastDefinedLocations = field.locations
 		}
+		if len(field.methods) > 0 {
+			methods = field.methods
+		}
 		// Valid field. Append index.
 		path = append(path, field.name)
-		if field.isSliceOfStructs && !isFileHeadersPtrs(field.typ) && !isFileHeaders(field.typ) && (!field.unmarshalerInfo.IsValid || (field.unmarshalerInfo.IsValid && field.unmarshalerInfo.IsSliceElement)) {
+		if field.isIndexed() {
 			// Parse a special case: slices of structs.
 			// i+1 must be the slice index.
+			// Each level of nested slices of structs adds its own part,
+			// like orders.0.items.1.sku.
 			//
 			// Now that struct can implements TextUnmarshaler interface,
 			// we don't need to force the struct's fields to appear in the path.
@@ -162,9 +295,10 @@ func (c *cache) parsePath(p string, t reflect.Type, location int) ([]pathPart, e
 				return nil, invalidPath
 			}
 			parts = append(parts, pathPart{
-				path:  path,
-				field: field,
-				index: int(index64),
+				path:   path,
+				parent: parentPath(path),
+				field:  field,
+				index:  int(index64),
 			})
 			path = make([]string, 0)
 
@@ -180,6 +314,18 @@ func (c *cache) parsePath(p string, t reflect.Type, location int) ([]pathPart, e
 					t = t.Elem()
 				}
 			}
+		} else if i == len(keys)-2 && indirectType(field.typ).Kind() == reflect.Map {
+			// Parse an entry of a map: i+1 must be the last key.
+			i++
+			mapKey = keys[i]
+		} else if i == len(keys)-2 && isScalarList(field.typ) {
+			// Parse an indexed element of a slice or array of scalars.
+			// i+1 must be the last key and the index.
+			i++
+			if index64, err = strconv.ParseInt(keys[i], 10, 0); err != nil || index64 < 0 || index64 >= maxSliceIndex {
+				return nil, invalidPath
+			}
+			index = int(index64)
 		} else if field.typ.Kind() == reflect.Ptr {
 			t = field.typ.Elem()
 		} else {
@@ -189,48 +335,160 @@ func (c *cache) parsePath(p string, t reflect.Type, location int) ([]pathPart, e
 
 	// if there are an locations defined seen then use them
 	// this makes the field inherit the locations of its parent
-	if len(lastDefinedLocations) > 0 {
-		if !containsInt(lastDefinedLocations, location) {
-			return nil, LocationError{Key: p, AllowedLocations: lastDefinedLocations, Location: location}
+	if location != locationNone {
+		allowed := field.locations
+		if len(lastDefinedLocations) > 0 {
+			allowed = lastDefinedLocations
 		}
-	} else {
-		if !containsInt(field.locations, location) {
-			return nil, LocationError{Key: p, AllowedLocations: field.locations, Location: location}
+		if !containsInt(allowed, location) {
+			return nil, LocationError{Key: p, AllowedLocations: uniqueLocations(allowed), Location: location}
 		}
 	}
 
 	// Add the remaining.
 	parts = append(parts, pathPart{
-		path:  path,
-		field: field,
-		index: -1,
+		path:    path,
+		parent:  parentPath(path),
+		field:   field,
+		index:   index,
+		mapKey:  mapKey,
+		methods: methods,
 	})
 	return parts, nil
 }
 
+// maxSliceIndex is the max index accepted for an indexed element
+// of a slice of scalars, so a single param cannot allocate a huge slice.
+const maxSliceIndex = 16000
+
+var rawJSONType = reflect.TypeOf(json.RawMessage{})
+
+// isRawJSON reports whether t is a json.RawMessage or a pointer to one.
+func isRawJSON(t reflect.Type) bool {
+	return indirectType(t) == rawJSONType
+}
+
+// isScalarList reports whether t is a slice or an array of
+// non struct elements, which can be addressed by index in paths.
+func isScalarList(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return false
+	}
+	return underlyingElem(t).Kind() != reflect.Struct && !isFileType(underlyingElem(t))
+}
+
 // get returns a cached structInfo, creating it if necessary.
 func (c *cache) get(t reflect.Type) *structInfo {
 	c.l.RLock()
 	info := c.m[t]
 	c.l.RUnlock()
 	if info == nil {
-		info = c.create(t, "", nil)
+		info = c.create(t, nil, nil, map[reflect.Type]bool{})
 		c.l.Lock()
 		c.m[t] = info
+		c.inheritCyclic(t)
+		info = c.m[t]
 		c.l.Unlock()
 	}
 	return info
 }
 
+// disallowedError returns the result of check for the struct type t,
+// calling check only the first time. The result is dropped with the
+// cached information when the cache is reset.
+func (c *cache) disallowedError(t reflect.Type, check func() error) error {
+	c.l.RLock()
+	err, checked := c.disallowed[t]
+	c.l.RUnlock()
+	if checked {
+		return err
+	}
+	err = check()
+	c.l.Lock()
+	c.disallowed[t] = err
+	c.l.Unlock()
+	return err
+}
+
+// inheritCyclic completes the infos of the nested types of t created
+// while t was being created. Those cut short by a cycle, like the info
+// of B for a type A with a field of type B which has a field of type A,
+// miss the flags of the types they lead back to, so the flags are
+// inherited until none changes. The infos are replaced by copies rather
+// than modified, as they could be in use already.
+// It must be called with c.l locked.
+func (c *cache) inheritCyclic(t reflect.Type) {
+	copies := map[reflect.Type]*structInfo{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		info := c.m[t]
+		if info == nil || copies[t] != nil {
+			return
+		}
+		cp := *info
+		copies[t] = &cp
+		for _, f := range info.fields {
+			if ft := underlyingElem(f.typ); ft.Kind() == reflect.Struct && !isFileType(ft) {
+				walk(ft)
+			}
+		}
+	}
+	walk(t)
+	changed := map[reflect.Type]bool{}
+	for again := true; again; {
+		again = false
+		for t, info := range copies {
+			for _, f := range info.fields {
+				ft := underlyingElem(f.typ)
+				if n := copies[ft]; n != nil && info.inherit(n) {
+					changed[t] = true
+					again = true
+				}
+			}
+		}
+	}
+	for t := range changed {
+		c.m[t] = copies[t]
+	}
+}
+
 // create creates a structInfo with meta-data about a struct.
-func (c *cache) create(t reflect.Type, parentAlias string, parentLocations []int) *structInfo {
-	info := &structInfo{}
+// Visiting holds the types being created, so cyclic types, like a struct
+// with a field of its own type, do not recurse forever. The info of such
+// nested types is left to be created lazily when it is first used.
+func (c *cache) create(t reflect.Type, parentPath []string, parentLocations []int, visiting map[reflect.Type]bool) *structInfo {
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	info := &structInfo{normalize: c.keyNormalizer}
+	defaultLocation := c.defaultLocation
+	l, located := structDefaultLocation(t)
+	if located {
+		defaultLocation = l
+	}
 	var anonymousInfos []*structInfo
 	for i := 0; i < t.NumField(); i++ {
-		if f := c.createField(t.Field(i), parentAlias, parentLocations, hasFiles(t)); f != nil {
+		field := t.Field(i)
+		if f := c.createField(field, parentPath, parentLocations, hasFiles(t), defaultLocation, visiting); f == nil {
+			if field.PkgPath == "" && c.isUnsupported(field.Type) {
+				info.skipped = append(info.skipped, field.Name)
+			}
+		} else if f.isRemainder {
+			info.remainder = f
+		} else if f.isFilesMap {
+			info.filesMap = f
+		} else {
+			if located {
+				// The default location set by the struct applies
+				// to the fields of its nested structs like a tag.
+				f.locationsDefined = true
+			}
 			info.fields = append(info.fields, f)
-			if ft := indirectType(f.typ); ft.Kind() == reflect.Struct && f.isAnonymous {
-				anonymousInfos = append(anonymousInfos, c.create(ft, f.canonicalAlias, f.locations))
+			if ft := indirectType(f.typ); ft.Kind() == reflect.Struct && f.isAnonymous && !c.prefixEmbedded && !visiting[ft] {
+				anonymousInfos = append(anonymousInfos, c.create(ft, f.parentPath(), f.locations, visiting))
 			}
 		}
 	}
@@ -243,29 +501,122 @@ func (c *cache) create(t reflect.Type, parentAlias string, parentLocations []int
 				info.fields = append(info.fields, f)
 			}
 		}
+		if info.remainder == nil {
+			info.remainder = a.remainder
+		}
+		if info.filesMap == nil {
+			info.filesMap = a.filesMap
+		}
 	}
 
+	info.containsSkipped = len(info.skipped) > 0
+	info.groups = createGroups(info.fields)
 	info.containsPath = c.containsLocation(info.fields, LocationPath)
 	info.containsQuery = c.containsLocation(info.fields, LocationQuery)
 	info.containsForm = c.containsLocation(info.fields, LocationForm)
-	info.containsFile = c.containsLocation(info.fields, LocationFile)
+	info.containsFile = c.containsLocation(info.fields, LocationFile) || info.filesMap != nil
 	info.containsHeader = c.containsLocation(info.fields, LocationHeader)
 	info.containsJSON = c.containsLocation(info.fields, LocationJSON)
+	info.containsCookie = c.containsLocation(info.fields, LocationCookie)
+	info.containsRequest = len(getWithLocation(info.fields, LocationRemoteAddr, LocationMethod, LocationHost, LocationURLPath)) > 0
+	info.containsBody = len(getWithLocation(info.fields, LocationBody)) > 0
 	info.fieldsJSON = fieldsAliases(getWithLocation(info.fields, LocationJSON))
+	info.headerAliases = headerAliases(getWithLocation(info.fields, LocationHeader))
+	if info.remainder != nil {
+		for _, l := range info.remainder.locations {
+			info.setContains(l)
+		}
+	}
+	for _, f := range info.fields {
+		if f.requiredIf != nil {
+			info.containsRequiredIf = true
+		}
+		if len(f.timeFormats) > 0 {
+			info.containsTimeFormat = true
+		}
+		if f.isFromStringer {
+			info.containsFromStringer = true
+		}
+		if f.isBinaryUnmarshaler {
+			info.containsBinaryUnmarshaler = true
+		}
+		if f.flat {
+			info.containsFlat = true
+		}
+		if k := underlyingElem(f.typ).Kind(); k == reflect.Complex64 || k == reflect.Complex128 {
+			info.containsComplex = true
+		}
+		if len(f.methods) > 0 {
+			info.containsMethods = true
+		}
+		if len(f.enum) > 0 {
+			info.containsEnum = true
+		}
+		if ft := underlyingElem(f.typ); ft.Kind() == reflect.Struct && !isFileType(ft) && !visiting[ft] {
+			c.l.RLock()
+			i := c.m[ft]
+			c.l.RUnlock()
+			if i == nil {
+				i = c.create(ft, nil, nil, visiting)
+			}
+			info.inherit(i)
+		}
+	}
+	for _, f := range getWithLocation(info.fields, LocationJSON) {
+		if isRawJSON(f.typ) && f.canonicalAlias == f.alias {
+			info.containsRawJSON = true
+		}
+	}
 	return info
 }
 
 // createField creates a fieldInfo for the given field.
-func (c *cache) createField(field reflect.StructField, parentAlias string, parentLocations []int, parentContainsFiles bool) *fieldInfo {
-	alias, locations, locationsDefined := c.fieldAlias(field, parentLocations, parentContainsFiles)
+func (c *cache) createField(field reflect.StructField, parentPath []string, parentLocations []int, parentContainsFiles bool, defaultLocation int, visiting map[reflect.Type]bool) *fieldInfo {
+	if field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+		// Ignore unexported fields, except for embedded structs
+		// whose exported fields are promoted and can be set.
+		return nil
+	}
+	alias, locations, locationsDefined := c.fieldAlias(field, parentLocations, parentContainsFiles, defaultLocation)
 	if alias == "-" {
 		// Ignore this field.
 		return nil
 	}
-	canonicalAlias := alias
-	if parentAlias != "" {
-		canonicalAlias = parentAlias + "." + alias
+	if c.isUnsupported(field.Type) {
+		return nil
+	}
+	if hasTagOption(field, remainderOption) {
+		if !isRemainderType(field.Type) {
+			// Type is not supported.
+			return nil
+		}
+		if l := remainderLocations(field); len(l) > 0 {
+			locations = l
+		}
+		return &fieldInfo{
+			structField: field,
+			typ:         field.Type,
+			name:        field.Name,
+			alias:       alias,
+			locations:   locations,
+			isRemainder: true,
+		}
+	}
+	if isFileHeadersMap(field.Type) {
+		return &fieldInfo{
+			structField: field,
+			typ:         field.Type,
+			name:        field.Name,
+			alias:       alias,
+			locations:   []int{LocationFile},
+			isFilesMap:  true,
+		}
 	}
+	canonicalPath := []string{alias}
+	if len(parentPath) > 0 {
+		canonicalPath = append(parentPath[:len(parentPath):len(parentPath)], alias)
+	}
+	canonicalAlias := strings.Join(canonicalPath, ".")
 
 	// Check if the type is supported and don't cache it if not.
 	// First let's get the basic type.
@@ -295,34 +646,302 @@ func (c *cache) createField(field reflect.StructField, parentAlias string, paren
 		isFile = true
 	}
 
-	if isStruct = ft.Kind() == reflect.Struct; !isStruct && !isFile {
+	isRequestDecoder := implementsRequestDecoder(field.Type)
+
+	if isRequestDecoder {
+		// The field decodes itself from the request.
+	} else if ft.Kind() == reflect.Map && c.converter(ft) == nil {
+		if !c.isPairsMap(ft) {
+			// Type is not supported.
+			return nil
+		}
+	} else if isStruct = ft.Kind() == reflect.Struct; !isStruct && !isFile {
 		if c.converter(ft) == nil && builtinConverters[ft.Kind()] == nil {
 			// Type is not supported.
 			return nil
 		}
-	} else if !isFile {
-		i := c.create(ft, "", nil)
+	} else if !isFile && !visiting[ft] {
+		i := c.create(ft, nil, nil, visiting)
 		c.l.Lock()
 		c.m[ft] = i
 		c.l.Unlock()
 	}
 
+	var set, decimalSet setter
+	var elemConv ConverterErr
+	if !isSlice && !m.IsValid && c.converter(ft) == nil && !isRequestDecoder {
+		set = kindSetter(indirectType(field.Type))
+		if k := indirectType(field.Type).Kind(); (k == reflect.Float32 || k == reflect.Float64) && c.decimalSep != 0 && c.decimalSep != '.' {
+			decimalSet = decimalSetter(c.decimalSep, set)
+		}
+	}
+	if et := elemType(field.Type); et != nil && c.converter(et) == nil {
+		elemConv = numberConverter(et)
+	}
+
 	return &fieldInfo{
+		setter:           set,
+		decimalSetter:    decimalSet,
+		elemConverter:    elemConv,
+		structField:      field,
+		isSecret:         field.Tag.Get(secretTag) == "true",
+		explode:          hasTagOption(field, explodeOption),
+		flat:             hasTagOption(field, flatOption),
+		delim:            field.Tag.Get(delimTag),
+		hasDelim:         hasTag(field, delimTag),
+		methods:          fieldMethods(field),
+		enum:             fieldEnum(field),
+		requiredIf:       fieldRequiredIf(field),
+		group:            fieldGroupName(field),
+		timeFormats:      fieldTimeFormats(field),
+		encoding:         field.Tag.Get(encodingTag),
+		stripScheme:      fieldStripScheme(field),
 		typ:              field.Type,
 		name:             field.Name,
 		alias:            alias,
 		locations:        locations,
 		locationsDefined: locationsDefined,
 		canonicalAlias:   canonicalAlias,
+		canonicalPath:    canonicalPath,
 		unmarshalerInfo:  m,
-		isSliceOfStructs: isSlice && isStruct,
+		isSliceOfStructs: isSlice && isStruct && c.converter(ft) == nil,
 		isAnonymous:      field.Anonymous,
+		isRequestDecoder: isRequestDecoder,
+		isFromStringer:   !isSlice && implementsFromStringer(indirectType(field.Type)),
+
+		isBinaryUnmarshaler: !m.IsValid && implementsBinaryUnmarshaler(indirectType(field.Type)),
+	}
+}
+
+// isUnsupported reports whether t is a type which cannot be decoded
+// from params, like channels and funcs, or slices of them,
+// unless it has a converter or decodes itself from the request.
+func (c *cache) isUnsupported(t reflect.Type) bool {
+	ft := underlyingElem(t)
+	switch ft.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return c.converter(ft) == nil && !implementsRequestDecoder(t)
+	}
+	return false
+}
+
+// isPairsMap reports whether t is a map decoded from key=value pairs
+// in headers, or by key from other locations,
+// that is a map with string keys and convertible values.
+func (c *cache) isPairsMap(t reflect.Type) bool {
+	if t.Key().Kind() != reflect.String {
+		return false
+	}
+	return c.converter(t.Elem()) != nil || builtinConverters[t.Elem().Kind()] != nil
+}
+
+// fieldMethods returns the request methods the field is accepted for.
+func fieldMethods(field reflect.StructField) (methods []string) {
+	for _, m := range clean(strings.Split(field.Tag.Get(methodsTag), ",")) {
+		if m != "" {
+			methods = append(methods, strings.ToUpper(m))
+		}
+	}
+	return
+}
+
+// fieldEnum returns the values allowed for the field by the enum tag.
+func fieldEnum(field reflect.StructField) (enum []string) {
+	for _, v := range clean(strings.Split(field.Tag.Get(enumTag), ",")) {
+		if v != "" {
+			enum = append(enum, v)
+		}
+	}
+	return
+}
+
+// fieldGroupName returns the name of the group of the field set by
+// the group tag, like group:"auth" or group:"auth,required".
+func fieldGroupName(field reflect.StructField) string {
+	return clean(strings.Split(field.Tag.Get(groupTag), ","))[0]
+}
+
+// fieldGroup is a group of mutually exclusive fields.
+type fieldGroup struct {
+	name string
+	// required indicates whether exactly one of the fields must be sent,
+	// rather than at most one.
+	required bool
+	fields   []*fieldInfo
+}
+
+// createGroups returns the groups of the fields set by the group tag,
+// in the order of their first fields.
+func createGroups(fields []*fieldInfo) []*fieldGroup {
+	var groups []*fieldGroup
+	byName := map[string]*fieldGroup{}
+	for _, f := range fields {
+		if f.group == "" {
+			continue
+		}
+		g, ok := byName[f.group]
+		if !ok {
+			g = &fieldGroup{name: f.group}
+			byName[f.group] = g
+			groups = append(groups, g)
+		}
+		g.fields = append(g.fields, f)
+		if containsString(clean(strings.Split(f.structField.Tag.Get(groupTag), ","))[1:], requiredOption) {
+			g.required = true
+		}
 	}
+	return groups
+}
+
+// condition is the condition of a requiredif tag, like "type=business",
+// which holds when the field with the alias has the value.
+type condition struct {
+	alias string
+	value string
+}
+
+func (c condition) String() string {
+	return c.alias + "=" + c.value
+}
+
+// fieldRequiredIf returns the condition of the requiredif tag of the field.
+func fieldRequiredIf(field reflect.StructField) *condition {
+	tag := strings.TrimSpace(field.Tag.Get(requiredIfTag))
+	i := strings.Index(tag, "=")
+	if i <= 0 {
+		return nil
+	}
+	return &condition{alias: strings.TrimSpace(tag[:i]), value: strings.TrimSpace(tag[i+1:])}
+}
+
+// fieldTimeFormats returns the formats of the values of the time field
+// by location, set by tags like time_format_query:"unix". A time_format tag
+// sets the format for the locations without a tag of their own.
+func fieldTimeFormats(field reflect.StructField) map[int]string {
+	if indirectType(field.Type) != timeType {
+		return nil
+	}
+	formats := map[int]string{}
+	for _, location := range tagLocations {
+		if format := field.Tag.Get(timeFormatTag + "_" + locationTags[location]); format != "" {
+			formats[location] = format
+		} else if format := field.Tag.Get(timeFormatTag); format != "" {
+			formats[location] = format
+		}
+	}
+	if len(formats) == 0 {
+		return nil
+	}
+	return formats
+}
+
+// isRemainderType reports whether t can hold the unknown keys,
+// that is whether it is a map[string]interface{}.
+func isRemainderType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String &&
+		t.Elem().Kind() == reflect.Interface && t.Elem().NumMethod() == 0
+}
+
+// remainderLocations returns the locations of the tags
+// with the remainder option, like json:",remainder".
+func remainderLocations(field reflect.StructField) (locations []int) {
+	for _, location := range tagLocations {
+		for _, o := range clean(strings.Split(field.Tag.Get(locationTags[location]), ","))[1:] {
+			if o == remainderOption {
+				locations = append(locations, location)
+			}
+		}
+	}
+	return
+}
+
+// fieldStripScheme returns the auth scheme to be stripped from the header
+// values of the field.
+func fieldStripScheme(field reflect.StructField) string {
+	scheme, _ := tagOptionValue(field, stripOption)
+	return scheme
+}
+
+// pairsDelim returns the delimiter of the key=value pairs of the field.
+func (f *fieldInfo) pairsDelim() string {
+	if f.delim != "" {
+		return f.delim
+	}
+	return ","
+}
+
+func hasTag(field reflect.StructField, tag string) bool {
+	_, ok := field.Tag.Lookup(tag)
+	return ok
+}
+
+// check validates the struct information of t and its nested structs.
+func (c *cache) check(t reflect.Type, visited map[reflect.Type]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		for _, name := range clean(strings.Split(field.Tag.Get(fromTag), ",")) {
+			if name != "" && nameToLocation(name) == locationNone {
+				return UnknownLocationError{Type: t, Field: field.Name, Location: name}
+			}
+		}
+		switch e := field.Tag.Get(encodingTag); e {
+		case "", base64Encoding, base64URLEncoding, hexEncoding:
+		default:
+			return UnknownEncodingError{Type: t, Field: field.Name, Encoding: e}
+		}
+	}
+	info := c.get(t)
+	if len(info.fields) == 0 && info.remainder == nil {
+		return NoFieldsError{Type: t, Skipped: info.skipped}
+	}
+	for i, f := range info.fields {
+		if f.alias == "" {
+			continue
+		}
+		for _, o := range info.fields[i+1:] {
+			if !strings.EqualFold(f.alias, o.alias) {
+				continue
+			}
+			for _, l := range f.locations {
+				if containsInt(o.locations, l) {
+					return DuplicateAliasError{Type: t, Alias: f.alias, Location: l, Fields: []string{f.name, o.name}}
+				}
+			}
+		}
+	}
+	for _, f := range info.fields {
+		if c.isNested(f) {
+			if err := c.check(underlyingElem(f.typ), visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isNested reports whether the field is a struct, or a slice of structs,
+// whose fields are decoded one by one rather than as a single value.
+func (c *cache) isNested(f *fieldInfo) bool {
+	ft := underlyingElem(f.typ)
+	return ft.Kind() == reflect.Struct && !isFileType(ft) && !f.isRequestDecoder && !f.isBinaryUnmarshaler &&
+		!f.unmarshalerInfo.IsValid && c.converter(ft) == nil
 }
 
 // converter returns the converter for a type.
-func (c *cache) converter(t reflect.Type) Converter {
-	return c.regconv[t]
+func (c *cache) converter(t reflect.Type) fieldConverter {
+	if conv := c.regconv[t]; conv != nil {
+		return conv
+	}
+	if conv := builtinTypeConverters[t]; conv != nil {
+		return func(value string, _ reflect.StructField) (reflect.Value, error) {
+			return conv(value), nil
+		}
+	}
+	return nil
 }
 
 type structInfo struct {
@@ -332,15 +951,170 @@ type structInfo struct {
 	containsForm   bool
 	containsFile   bool
 	containsJSON   bool
+	containsCookie bool
+	// containsRequest indicates whether the struct has fields reading
+	// request metadata.
+	containsRequest bool
+	// containsBody indicates whether the struct has fields reading the raw body.
+	containsBody bool
+	// containsRawJSON indicates whether the struct has json.RawMessage
+	// fields receiving the raw values of their keys in the JSON body.
+	containsRawJSON bool
+	// containsMethods indicates whether the struct or its nested structs
+	// have fields accepted only for some request methods.
+	containsMethods bool
+	// containsRequiredIf indicates whether the struct, or a nested struct,
+	// has fields required depending on the values of other fields.
+	containsRequiredIf bool
+	// groups are the groups of mutually exclusive fields of the struct.
+	groups []*fieldGroup
+	// containsTimeFormat indicates whether the struct, or a nested struct,
+	// has time fields with formats set by tags.
+	containsTimeFormat bool
+	// containsFromStringer indicates whether the struct has fields
+	// implementing FromStringer.
+	containsFromStringer bool
+	// containsBinaryUnmarshaler indicates whether the struct has fields
+	// decoded with encoding.BinaryUnmarshaler.
+	containsBinaryUnmarshaler bool
+	// containsComplex indicates whether the struct has complex fields,
+	// which encoding/json cannot decode.
+	containsComplex bool
+	// containsFlat indicates whether the struct has fields with the flat option.
+	containsFlat bool
+	// containsEnum indicates whether the struct, or a nested struct,
+	// has fields with values restricted by the enum tag.
+	containsEnum bool
+	// containsSkipped indicates whether the struct, or a nested struct,
+	// has fields skipped for having unsupported types.
+	containsSkipped bool
+	// skipped are the names of the fields skipped for having unsupported types.
+	skipped []string
+
+	// remainder is the field collecting the unknown keys of its locations;
+	// nil if there is none.
+	remainder *fieldInfo
+	// filesMap is the field getting all the uploaded files
+	// by form field name; nil if there is none.
+	filesMap *fieldInfo
 
 	fieldsJSON []string
 
+	// headerAliases maps the canonical header keys, like X-Request-Id,
+	// to the aliases of the header fields they are sent for.
+	headerAliases map[string]string
+
+	// normalize is the key normalizer of the decoder applied to both
+	// aliases and keys when matching them.
+	normalize func(string) string
+
 	fields []*fieldInfo
 }
 
+// setContains marks the struct as having fields in the location.
+func (i *structInfo) setContains(location int) {
+	switch location {
+	case LocationPath:
+		i.containsPath = true
+	case LocationQuery:
+		i.containsQuery = true
+	case LocationHeader:
+		i.containsHeader = true
+	case LocationForm:
+		i.containsForm = true
+	case LocationFile:
+		i.containsFile = true
+	case LocationJSON:
+		i.containsJSON = true
+	case LocationCookie:
+		i.containsCookie = true
+	case LocationRemoteAddr, LocationMethod, LocationHost, LocationURLPath:
+		i.containsRequest = true
+	case LocationBody:
+		i.containsBody = true
+	}
+}
+
+// flatField returns the field whose alias is made of more than one
+// of the keys, like "user.name", and the number of keys it is made of.
+// Only fields with the flat option match, or all fields if flat is true.
+func (i *structInfo) flatField(keys []string, s separators, flat bool, exact bool) (*fieldInfo, int) {
+	if !flat && !i.containsFlat {
+		return nil, 0
+	}
+	for n := len(keys); n > 1; n-- {
+		if f := i.lookup(s.joinPath(keys[:n]), exact); f != nil && (flat || f.flat) {
+			return f, n
+		}
+	}
+	return nil, 0
+}
+
+// inherit sets the flags of i which hold for nested structs from
+// the info n of a nested struct, and reports whether any changed.
+func (i *structInfo) inherit(n *structInfo) bool {
+	changed := false
+	if n.containsMethods && !i.containsMethods {
+		i.containsMethods = true
+		changed = true
+	}
+	if n.containsRequiredIf && !i.containsRequiredIf {
+		i.containsRequiredIf = true
+		changed = true
+	}
+	if n.containsTimeFormat && !i.containsTimeFormat {
+		i.containsTimeFormat = true
+		changed = true
+	}
+	if n.containsEnum && !i.containsEnum {
+		i.containsEnum = true
+		changed = true
+	}
+	if n.containsSkipped && !i.containsSkipped {
+		i.containsSkipped = true
+		changed = true
+	}
+	return changed
+}
+
 func (i *structInfo) get(alias string) *fieldInfo {
+	return i.lookup(alias, false)
+}
+
+// lookup returns the field matching the key,
+// exactly if exact is true or case-insensitively otherwise.
+func (i *structInfo) lookup(key string, exact bool) *fieldInfo {
+	for _, field := range i.fields {
+		if i.matches(field.alias, key, exact) {
+			return field
+		}
+	}
+	return nil
+}
+
+// matches reports whether the key matches the alias of a field,
+// case-insensitively unless exact is true, and after normalizing both
+// if there is a normalizer.
+func (i *structInfo) matches(alias string, key string, exact bool) bool {
+	if i.normalize != nil {
+		alias, key = i.normalize(alias), i.normalize(key)
+	}
+	if exact {
+		return alias == key
+	}
+	return strings.EqualFold(alias, key)
+}
+
+// getWithLocation returns the field matching the alias exactly
+// which can be sourced from the location.
+// If location is locationNone then the locations of the field are not checked.
+func (i *structInfo) getWithLocation(alias string, location int) *fieldInfo {
 	for _, field := range i.fields {
-		if strings.EqualFold(field.alias, alias) {
+		matches := field.alias == alias
+		if i.normalize != nil {
+			matches = i.normalize(field.alias) == i.normalize(alias)
+		}
+		if matches && field.canonicalAlias == field.alias && (location == locationNone || containsInt(field.locations, location)) {
 			return field
 		}
 	}
@@ -357,6 +1131,12 @@ func containsAlias(infos []*structInfo, alias string) bool {
 }
 
 func (c *cache) containsLocation(fields []*fieldInfo, location int) bool {
+	return c.containsLocationVisiting(fields, location, map[reflect.Type]bool{})
+}
+
+// containsLocationVisiting is containsLocation skipping the visited
+// types, so it stops at cyclic types.
+func (c *cache) containsLocationVisiting(fields []*fieldInfo, location int, visited map[reflect.Type]bool) bool {
 	for i := range fields {
 		if containsInt(fields[i].locations, location) {
 			return true
@@ -369,10 +1149,14 @@ func (c *cache) containsLocation(fields []*fieldInfo, location int) bool {
 		if t.Kind() == reflect.Ptr {
 			t = t.Elem()
 		}
+		if visited[t] {
+			continue
+		}
+		visited[t] = true
 		c.l.RLock()
 		s := c.m[t]
 		c.l.RUnlock()
-		if s != nil && c.containsLocation(s.fields, location) {
+		if s != nil && c.containsLocationVisiting(s.fields, location, visited) {
 			return true
 		}
 	}
@@ -391,6 +1175,16 @@ func getWithLocation(fields []*fieldInfo, locations ...int) (others []*fieldInfo
 	return
 }
 
+// headerAliases returns the aliases of the fields by their keys
+// in the canonical header format.
+func headerAliases(fields []*fieldInfo) map[string]string {
+	aliases := map[string]string{}
+	for _, f := range fieldsAliases(fields) {
+		aliases[textproto.CanonicalMIMEHeaderKey(f)] = f
+	}
+	return aliases
+}
+
 func fieldsAliases(fields []*fieldInfo) (aliases []string) {
 	for i := range fields {
 		if fields[i].canonicalAlias == fields[i].alias {
@@ -401,6 +1195,8 @@ func fieldsAliases(fields []*fieldInfo) (aliases []string) {
 }
 
 type fieldInfo struct {
+	// structField is the field as declared in the struct.
+	structField      reflect.StructField
 	typ              reflect.Type
 	locations        []int
 	locationsDefined bool
@@ -412,7 +1208,12 @@ type fieldInfo struct {
 	// promoted from the struct.
 	// For instance, if the alias is "N" and this field is an embedded field
 	// in a struct "X", canonicalAlias will be "X.N".
+	// It identifies the field in the cache, which is shared by decoders
+	// with different separators, so params are never matched against it.
 	canonicalAlias string
+	// canonicalPath is the canonicalAlias split into the aliases
+	// of the embedded fields and of the field.
+	canonicalPath []string
 	// unmarshalerInfo contains information regarding the
 	// encoding.TextUnmarshaler implementation of the field type.
 	unmarshalerInfo unmarshaler
@@ -420,12 +1221,121 @@ type fieldInfo struct {
 	isSliceOfStructs bool
 	// isAnonymous indicates whether the field is embedded in the struct.
 	isAnonymous bool
+	// isSecret indicates whether the values of the field must be
+	// left out of errors.
+	isSecret bool
+	// explode indicates whether the slice field is always decoded from
+	// separate values, even when only one value is received, unless
+	// a converter is registered for the slice type.
+	explode bool
+	// flat indicates whether the alias of the field is matched as a whole
+	// even when it contains separators, like "user.name".
+	flat bool
+	// isRequestDecoder indicates whether the field type implements RequestDecoder.
+	isRequestDecoder bool
+	// isFromStringer indicates whether the type of the single field
+	// implements FromStringer.
+	isFromStringer bool
+	// isBinaryUnmarshaler indicates whether the type of the field implements
+	// encoding.BinaryUnmarshaler but not encoding.TextUnmarshaler.
+	isBinaryUnmarshaler bool
+	// isRemainder indicates whether the field collects the unknown keys.
+	isRemainder bool
+	// isFilesMap indicates whether the field gets all the uploaded files.
+	isFilesMap bool
+	// delim separates the values in a single value of a slice or array
+	// field, and the key=value pairs of a map field.
+	delim string
+	// hasDelim indicates whether the delimiter is set by the delim tag.
+	// An empty delimiter set by the tag means values are not split.
+	hasDelim bool
+	// methods are the request methods the field is accepted for;
+	// empty for all methods.
+	methods []string
+	// stripScheme is the auth scheme stripped from header values,
+	// like "Bearer".
+	stripScheme string
+	// enum are the values allowed for the string field;
+	// empty for any value.
+	enum []string
+	// requiredIf is the condition making the field required;
+	// nil for fields which are not required.
+	requiredIf *condition
+	// group is the name of the group of mutually exclusive fields
+	// the field belongs to; empty for no group.
+	group string
+	// timeFormats are the formats of the values of a time field
+	// by location; empty for the default RFC 3339 format.
+	timeFormats map[int]string
+	// encoding is the encoding of the values of a []byte field or
+	// a field implementing encoding.BinaryUnmarshaler,
+	// base64, base64url or hex; base64 if empty.
+	encoding string
+	// setter sets values of basic kinds to the field without going through
+	// the converters; nil for other fields.
+	setter setter
+	// decimalSetter is the setter of float fields when the decoder has
+	// a decimal separator other than the dot; nil for other fields.
+	decimalSetter setter
+	// elemConverter converts the elements of slice, array and map fields
+	// of numbers, checking they are in the range of the element type;
+	// nil for other fields.
+	elemConverter ConverterErr
+}
+
+// setterFor returns the setter for values of the field from the location.
+// JSON numbers always use the dot as the decimal separator.
+func (f *fieldInfo) setterFor(location int) setter {
+	if f.decimalSetter != nil && location != LocationJSON {
+		return f.decimalSetter
+	}
+	return f.setter
+}
+
+// errorValue returns the value to be reported in errors for the field.
+func (f *fieldInfo) errorValue(value string) string {
+	if f.isSecret {
+		return ""
+	}
+	return value
+}
+
+// isIndexed reports whether the field is a slice of structs whose
+// elements are addressed by index in paths.
+func (f *fieldInfo) isIndexed() bool {
+	return f.isSliceOfStructs && !isFileHeadersPtrs(f.typ) && !isFileHeaders(f.typ) && (!f.unmarshalerInfo.IsValid || (f.unmarshalerInfo.IsValid && f.unmarshalerInfo.IsSliceElement))
+}
+
+// parentPath returns the path prefixing the canonical aliases of the fields
+// promoted from the embedded struct field; nil if they are not prefixed.
+func (f *fieldInfo) parentPath() []string {
+	if f.canonicalAlias == "" {
+		return nil
+	}
+	return f.canonicalPath
 }
 
 type pathPart struct {
 	field *fieldInfo
 	path  []string // path to the field: walks structs using field names.
-	index int      // struct index in slices of structs.
+	index int      // struct index in slices of structs, or element index in slices of scalars for the last part.
+	// parent is the path to the parent of the field joined by dots,
+	// which identifies the struct shared by sibling fields; empty for
+	// fields of the first struct.
+	parent string
+	// mapKey is the key of the map entry for the last part; empty for the whole map.
+	mapKey string
+	// methods are the request methods the field of the last part is
+	// accepted for, inherited from its parents; empty for all methods.
+	methods []string
+}
+
+// parentPath joins the field names of path without the last one.
+func parentPath(path []string) string {
+	if len(path) < 2 {
+		return ""
+	}
+	return strings.Join(path[:len(path)-1], ".")
 }
 
 func indirectType(typ reflect.Type) reflect.Type {
@@ -443,16 +1353,74 @@ const (
 	LocationForm
 	LocationFile
 	LocationJSON
+	// The following locations read request metadata and can only be
+	// used in the from tag of fields in the top level struct.
+	LocationRemoteAddr
+	LocationMethod
+	LocationHost
+	LocationURLPath
+	// LocationBody reads the whole raw body to a string or []byte field,
+	// while the body is still decoded to the other fields.
+	LocationBody
+	// LocationCookie reads the values of the request cookies.
+	LocationCookie
 )
 
-var locationTags = map[int]string{LocationPath: "path", LocationQuery: "query", LocationHeader: "header", LocationForm: "form", LocationFile: "file", LocationJSON: "json"}
-var locationValues = map[string]int{"path": LocationPath, "query": LocationQuery, "header": LocationHeader, "form": LocationForm, "file": LocationFile, "json": LocationJSON}
+// locationPrecedence lists the locations from the highest precedence
+// to the lowest. It decides which values are kept when a param is
+// present in more than one location.
+var locationPrecedence = []int{LocationRemoteAddr, LocationMethod, LocationHost, LocationURLPath, LocationPath, LocationHeader, LocationCookie, LocationQuery, LocationForm, LocationJSON}
+
+// tagLocations lists the locations having tags in a fixed order.
+// Tags are always looked up through it rather than by ranging over
+// locationTags, so the resolved alias and options do not depend
+// on map iteration order.
+var tagLocations = []int{LocationPath, LocationHeader, LocationCookie, LocationQuery, LocationForm, LocationFile, LocationJSON}
+
+// defaultTagPriority lists the locations whose tags are looked up for
+// the alias of a field, from the highest priority to the lowest.
+var defaultTagPriority = tagLocations
+
+var locationTags = map[int]string{LocationPath: "path", LocationQuery: "query", LocationHeader: "header", LocationCookie: "cookie", LocationForm: "form", LocationFile: "file", LocationJSON: "json"}
+var locationValues = map[string]int{"path": LocationPath, "query": LocationQuery, "header": LocationHeader, "cookie": LocationCookie, "form": LocationForm, "file": LocationFile, "json": LocationJSON,
+	"remoteaddr": LocationRemoteAddr, "method": LocationMethod, "host": LocationHost, "urlpath": LocationURLPath, "body": LocationBody}
+
+// requestLocations are the locations reading request metadata.
+// They have no tags of their own.
+var requestLocations = map[int]string{LocationRemoteAddr: "remoteaddr", LocationMethod: "method", LocationHost: "host", LocationURLPath: "urlpath", LocationBody: "body"}
 
 const (
-	fromTag string = "from"
-	nameTag string = "name"
+	fromTag       string = "from"
+	nameTag       string = "name"
+	secretTag     string = "secret"
+	delimTag      string = "delim"
+	methodsTag    string = "methods"
+	enumTag       string = "enum"
+	encodingTag   string = "encoding"
+	requiredIfTag string = "requiredif"
+	groupTag      string = "group"
+	timeFormatTag string = "time_format"
+
+	base64Encoding    string = "base64"
+	base64URLEncoding string = "base64url"
+	hexEncoding       string = "hex"
+
+	explodeOption   string = "explode"
+	flatOption      string = "flat"
+	remainderOption string = "remainder"
+	requiredOption  string = "required"
+	stripOption     string = "strip"
 )
 
+func containsString(in []string, s string) bool {
+	for _, v := range in {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
 func containsInt(in []int, i int) bool {
 	for _, n := range in {
 		if i == n {
@@ -467,9 +1435,25 @@ func nameToLocation(name string) int {
 }
 
 func locationToName(location int) string {
+	if name, ok := requestLocations[location]; ok {
+		return name
+	}
 	return locationTags[location]
 }
 
+// uniqueLocations returns a sorted copy of the locations without duplicates,
+// which inheriting the locations of parents can add.
+func uniqueLocations(locations []int) []int {
+	unique := make([]int, 0, len(locations))
+	for _, l := range locations {
+		if !containsInt(unique, l) {
+			unique = append(unique, l)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
+
 func locationsToNames(locations []int) (names []string) {
 	names = make([]string, len(locations))
 	for i := range locations {
@@ -485,12 +1469,22 @@ func (c *cache) getAlias(name string, locations []int) string {
 	return name
 }
 
-func (c *cache) fieldAlias(field reflect.StructField, parentLocations []int, parentContainsFiles bool) (alias string, locations []int, locationsDefined bool) {
+func (c *cache) fieldAlias(field reflect.StructField, parentLocations []int, parentContainsFiles bool, defaultLocation int) (alias string, locations []int, locationsDefined bool) {
 
 	jsonAllowed := true
 	locationsDefined = true
+	jsonName := ""
 
-	for _, tagName := range locationTags {
+	for _, location := range c.tagPriority {
+		tagName := locationTags[location]
+		if c.jsonFallback && location == LocationJSON {
+			// The json tag only names the field.
+			if jsonName = parseTag(field.Tag.Get(tagName)); jsonName == "-" {
+				jsonAllowed = false
+				jsonName = ""
+			}
+			continue
+		}
 		if tag := parseTag(field.Tag.Get(tagName)); tag != "" && tag != "-" {
 			alias = tag
 			locations = append(locations, nameToLocation(tagName))
@@ -502,6 +1496,10 @@ func (c *cache) fieldAlias(field reflect.StructField, parentLocations []int, par
 		}
 	}
 
+	if alias == "" && c.jsonFallback && !jsonAllowed {
+		return "-", nil, false
+	}
+
 	if alias == "" {
 		if tag, lTag := field.Tag.Get(nameTag), field.Tag.Get(fromTag); tag != "-" && lTag != "" {
 			locs := clean(strings.Split(lTag, ","))
@@ -520,7 +1518,9 @@ func (c *cache) fieldAlias(field reflect.StructField, parentLocations []int, par
 			} else if len(locations) == 0 {
 				locations = parentLocations
 			}
-			if tag == "" {
+			if tag == "" && jsonName != "" {
+				tag = jsonName
+			} else if tag == "" {
 				tag = c.getAlias(field.Name, locations)
 			}
 			alias = tag
@@ -531,17 +1531,19 @@ func (c *cache) fieldAlias(field reflect.StructField, parentLocations []int, par
 				locations = []int{LocationFile}
 			} else if parentContainsFiles {
 				locations = []int{LocationForm}
-			} else if c.defaultLocation != locationNone {
-				if c.defaultLocation == LocationJSON && !jsonAllowed {
+			} else if defaultLocation != locationNone {
+				if defaultLocation == LocationJSON && !jsonAllowed {
 					return "-", nil, false
 				}
-				locations = []int{c.defaultLocation}
+				locations = []int{defaultLocation}
 				locationsDefined = false
 			} else if jsonAllowed {
 				locations = []int{LocationJSON}
 				locationsDefined = false
 			}
-			if tag == "" {
+			if tag == "" && jsonName != "" {
+				tag = jsonName
+			} else if tag == "" {
 				tag = c.getAlias(field.Name, locations)
 			}
 			alias = tag
@@ -550,7 +1552,9 @@ func (c *cache) fieldAlias(field reflect.StructField, parentLocations []int, par
 		}
 	}
 
-	if field.Anonymous {
+	if field.Anonymous && !c.prefixEmbedded && indirectType(field.Type).Kind() == reflect.Struct {
+		// The fields of embedded structs are promoted. Other embedded types
+		// are normal fields named after their types.
 		alias = ""
 	}
 
@@ -562,17 +1566,52 @@ func parseTag(tag string) string {
 	return s[0]
 }
 
+// hasTagOption reports whether the name tag or any of the location tags
+// of the field has the option.
+func hasTagOption(field reflect.StructField, option string) bool {
+	_, ok := tagOptionValue(field, option)
+	return ok
+}
+
+// tagOptionValue returns the value of an option like strip=Bearer
+// in the name tag or any of the location tags of the field,
+// and whether the option is present.
+func tagOptionValue(field reflect.StructField, option string) (string, bool) {
+	tags := []string{field.Tag.Get(nameTag)}
+	for _, location := range tagLocations {
+		tags = append(tags, field.Tag.Get(locationTags[location]))
+	}
+	for _, tag := range tags {
+		for _, o := range clean(strings.Split(tag, ","))[1:] {
+			if o == option {
+				return "", true
+			}
+			if strings.HasPrefix(o, option+"=") {
+				return strings.TrimSpace(o[len(option)+1:]), true
+			}
+		}
+	}
+	return "", false
+}
+
 func hasFiles(t reflect.Type) bool {
+	return containsFiles(t, map[reflect.Type]bool{})
+}
+
+// containsFiles reports whether t has file fields, skipping the
+// visited types to stop at cyclic types.
+func containsFiles(t reflect.Type, visited map[reflect.Type]bool) bool {
 	t = underlyingElem(t)
-	if t.Kind() != reflect.Struct {
+	if t.Kind() != reflect.Struct || visited[t] {
 		return false
 	}
+	visited[t] = true
 	for i := 0; i < t.NumField(); i++ {
 		f := underlyingElem(t.Field(i).Type)
-		if isFileType(f) {
+		if isFileType(f) || isFileHeadersMap(t.Field(i).Type) {
 			return true
 		}
-		if f.Kind() == reflect.Struct && hasFiles(f) {
+		if f.Kind() == reflect.Struct && containsFiles(f, visited) {
 			return true
 		}
 	}
@@ -589,6 +1628,18 @@ func isFileType(t reflect.Type) bool {
 	return false
 }
 
+// elemType returns the type of the elements of the slice, array or map
+// type t, or of the pointer to it, dereferencing pointer elements.
+// It returns nil for other types.
+func elemType(t reflect.Type) reflect.Type {
+	t = indirectType(t)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return indirectType(t.Elem())
+	}
+	return nil
+}
+
 func underlyingElem(t reflect.Type) reflect.Type {
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
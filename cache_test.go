@@ -0,0 +1,606 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+type embeddedUser struct {
+	Name string `query:"name"`
+}
+
+type embeddedGroup struct {
+	Name string `query:"name"`
+}
+
+func TestPrefixEmbedded(t *testing.T) {
+	type request struct {
+		embeddedUser  `query:"user"`
+		embeddedGroup `query:"group"`
+	}
+	type promoted struct {
+		embeddedUser
+		ID string `query:"id"`
+	}
+	tests := []struct {
+		name   string
+		prefix bool
+		target string
+		dst    interface{}
+		want   interface{}
+	}{
+		{
+			name:   "prefixed",
+			prefix: true,
+			target: "/?user.name=a&group.name=b",
+			dst:    &request{},
+			want:   &request{embeddedUser{Name: "a"}, embeddedGroup{Name: "b"}},
+		},
+		{
+			name:   "promoted",
+			target: "/?name=a&id=1",
+			dst:    &promoted{},
+			want:   &promoted{embeddedUser: embeddedUser{Name: "a"}, ID: "1"},
+		},
+		{
+			name:   "prefixed single",
+			prefix: true,
+			target: "/?embeddedUser.name=a&id=1",
+			dst:    &promoted{},
+			want:   &promoted{embeddedUser: embeddedUser{Name: "a"}, ID: "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.PrefixEmbedded(tt.prefix)
+			if err := d.Decode(tt.dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixEmbeddedUnknownKey(t *testing.T) {
+	type request struct {
+		embeddedUser  `query:"user"`
+		embeddedGroup `query:"group"`
+	}
+	d := NewDecoder()
+	d.PrefixEmbedded(true)
+	d.IgnoreUnknownKeys(false)
+	var dst request
+	err := d.Decode(&dst, newRequest("GET", "/?name=a", "", ""))
+	if _, ok := keyError(err, "name").(UnknownKeyError); !ok {
+		t.Errorf("got error %v, want an UnknownKeyError", err)
+	}
+}
+
+type unexportedBase struct {
+	ID     string `query:"id"`
+	secret string
+}
+
+type unexportedScalar int
+
+func TestUnexportedFields(t *testing.T) {
+	type request struct {
+		unexportedBase
+		unexportedScalar
+		Name  string `query:"name"`
+		count int
+		inner struct {
+			Value string `query:"value"`
+		}
+	}
+	d := NewDecoder()
+	d.IgnoreUnknownKeys(false)
+	var dst request
+	if err := d.Decode(&dst, newRequest("GET", "/?id=1&name=a", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	if dst.ID != "1" || dst.Name != "a" {
+		t.Errorf("got id %q and name %q, want 1 and a", dst.ID, dst.Name)
+	}
+	for _, key := range []string{"secret", "count", "inner.value", "unexportedScalar"} {
+		err := d.Decode(&dst, newRequest("GET", "/?"+key+"=1", "", ""))
+		if _, ok := keyError(err, key).(UnknownKeyError); !ok {
+			t.Errorf("got error %v for %q, want an UnknownKeyError", err, key)
+		}
+	}
+}
+
+func TestFieldAliasDeterministic(t *testing.T) {
+	type request struct {
+		Token string `query:"token" header:"X-Token" json:"token_json"`
+		Page  int    `json:"page" form:"p"`
+	}
+	tests := []struct {
+		name     string
+		field    string
+		alias    string
+		location int
+	}{
+		{name: "header over query", field: "Token", alias: "X-Token", location: LocationHeader},
+		{name: "form over json", field: "Page", alias: "p", location: LocationForm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				info := newCache().get(reflect.TypeOf(request{}))
+				var f *fieldInfo
+				for _, ff := range info.fields {
+					if ff.name == tt.field {
+						f = ff
+					}
+				}
+				if f == nil {
+					t.Fatalf("field %s not found", tt.field)
+				}
+				if f.alias != tt.alias || len(f.locations) != 1 || f.locations[0] != tt.location {
+					t.Fatalf("got alias %q in %v on rebuild %d, want %q in %s", f.alias, f.locations, i, tt.alias, locationToName(tt.location))
+				}
+			}
+		})
+	}
+}
+
+func TestCheckEncoding(t *testing.T) {
+	type valid struct {
+		Std []byte `query:"std" encoding:"base64"`
+		URL []byte `query:"url" encoding:"base64url"`
+		Hex []byte `query:"hex" encoding:"hex"`
+		Def []byte `query:"def"`
+	}
+	type unknown struct {
+		Data []byte `query:"data" encoding:"base32"`
+	}
+	type nested struct {
+		Inner unknown `query:"inner"`
+	}
+	tests := []struct {
+		name string
+		dst  interface{}
+		want error
+	}{
+		{name: "valid", dst: &valid{}},
+		{name: "unknown", dst: &unknown{}, want: UnknownEncodingError{Type: reflect.TypeOf(unknown{}), Field: "Data", Encoding: "base32"}},
+		{name: "nested", dst: &nested{}, want: UnknownEncodingError{Type: reflect.TypeOf(unknown{}), Field: "Data", Encoding: "base32"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder().Check(tt.dst); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLocationsAndFields(t *testing.T) {
+	type valid struct {
+		Name string `name:"name" from:"query, cookie"`
+	}
+	type unknownLocation struct {
+		Name string `name:"name" from:"query,body2"`
+	}
+	type empty struct{}
+	type ignored struct {
+		Name string `query:"-"`
+		name string
+	}
+	type skipped struct {
+		Done chan bool `json:"done"`
+	}
+	type nested struct {
+		Inner skipped `query:"inner"`
+	}
+	type remainder struct {
+		Rest map[string]interface{} `query:",remainder"`
+	}
+	tests := []struct {
+		name string
+		dst  interface{}
+		want error
+	}{
+		{name: "valid", dst: &valid{}},
+		{name: "unknown location", dst: &unknownLocation{}, want: UnknownLocationError{Type: reflect.TypeOf(unknownLocation{}), Field: "Name", Location: "body2"}},
+		{name: "empty", dst: &empty{}, want: NoFieldsError{Type: reflect.TypeOf(empty{})}},
+		{name: "ignored", dst: &ignored{}, want: NoFieldsError{Type: reflect.TypeOf(ignored{})}},
+		{name: "skipped", dst: &skipped{}, want: NoFieldsError{Type: reflect.TypeOf(skipped{}), Skipped: []string{"Done"}}},
+		{name: "nested", dst: &nested{}, want: NoFieldsError{Type: reflect.TypeOf(skipped{}), Skipped: []string{"Done"}}},
+		{name: "remainder only", dst: &remainder{}},
+		{name: "request decoder", dst: &selfDecoded{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder().Check(tt.dst); !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicateAlias(t *testing.T) {
+	type sameCase struct {
+		Name  string `query:"name"`
+		Other string `query:"name"`
+	}
+	type otherCase struct {
+		Name  string `query:"Name"`
+		Other string `query:"name"`
+	}
+	type otherLocations struct {
+		Name  string `query:"name"`
+		Other string `header:"name"`
+		JSON  string `json:"name"`
+	}
+	type sharedLocation struct {
+		Name  string `name:"name" from:"query,header"`
+		Other string `name:"NAME" from:"cookie,header"`
+	}
+	type inner struct {
+		ID    int `query:"id"`
+		Other int `query:"ID"`
+	}
+	type nested struct {
+		ID    int   `query:"id"`
+		Inner inner `query:"inner"`
+	}
+	type nestedSlice struct {
+		Inner []inner `query:"inner"`
+	}
+	type innerUnique struct {
+		ID int `query:"id"`
+	}
+	type nestedUnique struct {
+		ID    int           `query:"id"`
+		Inner []innerUnique `query:"inner"`
+	}
+	tests := []struct {
+		name string
+		dst  interface{}
+		want error
+	}{
+		{name: "same case", dst: &sameCase{}, want: DuplicateAliasError{Type: reflect.TypeOf(sameCase{}), Alias: "name", Location: LocationQuery, Fields: []string{"Name", "Other"}}},
+		{name: "other case", dst: &otherCase{}, want: DuplicateAliasError{Type: reflect.TypeOf(otherCase{}), Alias: "Name", Location: LocationQuery, Fields: []string{"Name", "Other"}}},
+		{name: "other locations", dst: &otherLocations{}},
+		{name: "shared location", dst: &sharedLocation{}, want: DuplicateAliasError{Type: reflect.TypeOf(sharedLocation{}), Alias: "name", Location: LocationHeader, Fields: []string{"Name", "Other"}}},
+		{name: "nested", dst: &nested{}, want: DuplicateAliasError{Type: reflect.TypeOf(inner{}), Alias: "id", Location: LocationQuery, Fields: []string{"ID", "Other"}}},
+		{name: "nested slice", dst: &nestedSlice{}, want: DuplicateAliasError{Type: reflect.TypeOf(inner{}), Alias: "id", Location: LocationQuery, Fields: []string{"ID", "Other"}}},
+		{name: "nested unique", dst: &nestedUnique{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder().Check(tt.dst); !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+type cycleA struct {
+	Name string  `query:"name" methods:"POST"`
+	B    *cycleB `query:"b"`
+}
+
+type cycleB struct {
+	Kind string  `query:"kind" enum:"x,y"`
+	A    *cycleA `query:"a"`
+}
+
+type cycleSelf struct {
+	Value    string      `query:"value"`
+	Children []cycleSelf `query:"children"`
+}
+
+func TestCyclicTypes(t *testing.T) {
+	a, b, self := reflect.TypeOf(cycleA{}), reflect.TypeOf(cycleB{}), reflect.TypeOf(cycleSelf{})
+	tests := []struct {
+		name   string
+		first  reflect.Type
+		typ    reflect.Type
+		method bool
+		enum   bool
+	}{
+		{name: "root", first: a, typ: a, method: true, enum: true},
+		{name: "nested", first: a, typ: b, method: true, enum: true},
+		{name: "nested first", first: b, typ: a, method: true, enum: true},
+		{name: "self", first: self, typ: self},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache()
+			c.get(tt.first)
+			info := c.get(tt.typ)
+			if info.containsMethods != tt.method {
+				t.Errorf("got containsMethods %v, want %v", info.containsMethods, tt.method)
+			}
+			if info.containsEnum != tt.enum {
+				t.Errorf("got containsEnum %v, want %v", info.containsEnum, tt.enum)
+			}
+		})
+	}
+}
+
+func TestDecodeCyclicTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		first   interface{}
+		dst     interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{name: "mutual nested", method: "POST", target: "/?kind=x&a.name=n&a.b.kind=y", dst: &cycleB{}, want: &cycleB{Kind: "x", A: &cycleA{Name: "n", B: &cycleB{Kind: "y"}}}},
+		{name: "mutual method", method: "GET", target: "/?kind=x&a.name=n", first: &cycleA{}, dst: &cycleB{}, want: &cycleB{Kind: "x"}},
+		{name: "mutual enum", method: "POST", target: "/?name=n&b.kind=z", dst: &cycleA{}, wantErr: "b.kind"},
+		{name: "self", method: "GET", target: "/?value=r&children.0.value=c&children.0.children.0.value=g", dst: &cycleSelf{}, want: &cycleSelf{Value: "r", Children: []cycleSelf{{Value: "c", Children: []cycleSelf{{Value: "g"}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			if tt.first != nil {
+				// The type is cached first as a nested type of another.
+				d.Check(tt.first)
+			}
+			err := d.Decode(tt.dst, newRequest(tt.method, tt.target, "", ""))
+			if tt.wantErr != "" {
+				if keyError(err, tt.wantErr) == nil {
+					t.Fatalf("got %v, want an error for %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackToJSONTag(t *testing.T) {
+	type request struct {
+		UserName string `json:"user_name"`
+		Email    string `json:"email,omitempty"`
+		Secret   string `json:"-"`
+		Page     int    `json:"page" form:"p"`
+		Raw      string
+	}
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		fallback bool
+		want     request
+		wantErr  bool
+	}{
+		{name: "query", method: "GET", target: "/?user_name=a&email=b", fallback: true, want: request{UserName: "a", Email: "b"}},
+		{name: "ignored", method: "GET", target: "/?Secret=s&secret=s", fallback: true, want: request{}},
+		{name: "location tag wins", method: "POST", target: "/", body: "p=2&page=3", fallback: true, want: request{Page: 2}},
+		{name: "field name", method: "GET", target: "/?Raw=r", fallback: true, want: request{Raw: "r"}},
+		{name: "disabled", method: "GET", target: "/?user_name=a", fallback: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.DefaultLocation(LocationQuery)
+			d.FallbackToJSONTag(tt.fallback)
+			contentType := ""
+			if tt.body != "" {
+				contentType = "application/x-www-form-urlencoded"
+			}
+			var dst request
+			err := d.Decode(&dst, newRequest(tt.method, tt.target, contentType, tt.body))
+			if tt.wantErr {
+				if _, ok := keyError(err, "user_name").(LocationError); !ok {
+					t.Fatalf("got %v, want a LocationError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsupportedFields(t *testing.T) {
+	type nested struct {
+		Done chan bool `json:"done"`
+		Name string    `json:"name"`
+	}
+	type request struct {
+		Name     string         `json:"name"`
+		Done     chan struct{}  `json:"done"`
+		Callback func()         `json:"callback"`
+		Ptr      unsafe.Pointer `json:"ptr"`
+		Funcs    []func()       `json:"funcs"`
+		Nested   nested         `json:"nested"`
+	}
+	type supported struct {
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name            string
+		dst             interface{}
+		body            string
+		wantSkipped     []string
+		containsSkipped bool
+		want            interface{}
+	}{
+		{
+			name:            "skipped",
+			dst:             &request{},
+			body:            `{"name":"a","done":1,"callback":"x","ptr":2,"funcs":[1],"nested":{"name":"b","done":true}}`,
+			wantSkipped:     []string{"Done", "Callback", "Ptr", "Funcs"},
+			containsSkipped: true,
+			want:            &request{Name: "a", Nested: nested{Name: "b"}},
+		},
+		{
+			name: "supported",
+			dst:  &supported{},
+			body: `{"name":"a"}`,
+			want: &supported{Name: "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			info := d.cache.get(reflect.TypeOf(tt.dst).Elem())
+			if !reflect.DeepEqual(info.skipped, tt.wantSkipped) {
+				t.Errorf("got skipped %q, want %q", info.skipped, tt.wantSkipped)
+			}
+			if info.containsSkipped != tt.containsSkipped {
+				t.Errorf("got containsSkipped %v, want %v", info.containsSkipped, tt.containsSkipped)
+			}
+			if err := d.Decode(tt.dst, newRequest("POST", "/", "application/json", tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+type queryDefault struct {
+	Page  int    `name:"page"`
+	Sort  string `json:"sort"`
+	Inner jsonDefault
+}
+
+func (queryDefault) DefaultLocation() int { return LocationQuery }
+
+type jsonDefault struct {
+	Name string
+}
+
+type headerDefault struct {
+	Token string `name:"X-Token"`
+}
+
+func (*headerDefault) DefaultLocation() int { return LocationHeader }
+
+type invalidDefault struct {
+	Name string
+}
+
+func (invalidDefault) DefaultLocation() int { return -1 }
+
+func TestDefaultLocator(t *testing.T) {
+	tests := []struct {
+		name   string
+		dst    interface{}
+		target string
+		header map[string]string
+		body   string
+		want   interface{}
+	}{
+		{
+			name:   "query",
+			dst:    &queryDefault{},
+			target: "/?page=2&Inner.Name=n",
+			body:   `{"sort":"j"}`,
+			want:   &queryDefault{Page: 2, Sort: "j", Inner: jsonDefault{Name: "n"}},
+		},
+		{
+			name:   "pointer receiver",
+			dst:    &headerDefault{},
+			header: map[string]string{"X-Token": "t"},
+			want:   &headerDefault{Token: "t"},
+		},
+		{
+			name:   "invalid location",
+			dst:    &invalidDefault{},
+			target: "/?Name=q",
+			body:   `{"Name":"j"}`,
+			want:   &invalidDefault{Name: "j"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			r := newRequest("POST", target, "application/json", body)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if err := NewDecoder().Decode(tt.dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocationErrorAllowedLocations(t *testing.T) {
+	type inner struct {
+		Name string
+	}
+	type request struct {
+		Inner  inner  `name:"inner" from:"header,query,header"`
+		Dup    string `name:"dup" from:"header,query,header"`
+		Sorted string `name:"sorted" from:"cookie,query"`
+		Page   int    `form:"page"`
+	}
+	tests := []struct {
+		name string
+		key  string
+		want LocationError
+		msg  string
+	}{
+		{
+			name: "duplicate",
+			key:  "dup",
+			want: LocationError{Key: "dup", AllowedLocations: []int{LocationQuery, LocationHeader}, Location: LocationForm},
+			msg:  `"dup" param sent in form instead of [query header]`,
+		},
+		{
+			name: "inherited duplicate",
+			key:  "inner.name",
+			want: LocationError{Key: "inner.name", AllowedLocations: []int{LocationQuery, LocationHeader}, Location: LocationForm},
+			msg:  `"inner.name" param sent in form instead of [query header]`,
+		},
+		{
+			name: "sorted",
+			key:  "sorted",
+			want: LocationError{Key: "sorted", AllowedLocations: []int{LocationQuery, LocationCookie}, Location: LocationForm},
+			msg:  `"sorted" param sent in form instead of [query cookie]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			var dst request
+			err := keyError(d.Decode(&dst, newRequest("POST", "/", "application/x-www-form-urlencoded", tt.key+"=a")), tt.key)
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Fatalf("got error %#v, want %#v", err, tt.want)
+			}
+			if got := err.Error(); got != tt.msg {
+				t.Errorf("got message %q, want %q", got, tt.msg)
+			}
+		})
+	}
+}
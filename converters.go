@@ -5,45 +5,297 @@
 package reqtruct
 
 import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Converter func(string) reflect.Value
 
+// ConverterWithField is a converter that also receives the field being
+// decoded, so it can read options from the field tags.
+type ConverterWithField func(value string, field reflect.StructField) reflect.Value
+
+// ConverterErr is a converter that returns why a value cannot be converted,
+// which is wrapped in the ConversionError returned by the decoder.
+type ConverterErr func(string) (reflect.Value, error)
+
+// fieldConverter is the form registered converters are kept in,
+// so all kinds of converters are called alike.
+type fieldConverter func(value string, field reflect.StructField) (reflect.Value, error)
+
+// withoutErr adapts a converter not returning errors to a ConverterErr.
+func withoutErr(conv Converter) ConverterErr {
+	return func(value string) (reflect.Value, error) {
+		return conv(value), nil
+	}
+}
+
 var (
-	invalidValue = reflect.Value{}
-	boolType     = reflect.Bool
-	float32Type  = reflect.Float32
-	float64Type  = reflect.Float64
-	intType      = reflect.Int
-	int8Type     = reflect.Int8
-	int16Type    = reflect.Int16
-	int32Type    = reflect.Int32
-	int64Type    = reflect.Int64
-	stringType   = reflect.String
-	uintType     = reflect.Uint
-	uint8Type    = reflect.Uint8
-	uint16Type   = reflect.Uint16
-	uint32Type   = reflect.Uint32
-	uint64Type   = reflect.Uint64
+	invalidValue   = reflect.Value{}
+	boolType       = reflect.Bool
+	complex64Type  = reflect.Complex64
+	complex128Type = reflect.Complex128
+	float32Type    = reflect.Float32
+	float64Type    = reflect.Float64
+	intType        = reflect.Int
+	int8Type       = reflect.Int8
+	int16Type      = reflect.Int16
+	int32Type      = reflect.Int32
+	int64Type      = reflect.Int64
+	stringType     = reflect.String
+	uintType       = reflect.Uint
+	uint8Type      = reflect.Uint8
+	uint16Type     = reflect.Uint16
+	uint32Type     = reflect.Uint32
+	uint64Type     = reflect.Uint64
 )
 
 var builtinConverters = map[reflect.Kind]Converter{
-	boolType:    convertBool,
-	float32Type: convertFloat32,
-	float64Type: convertFloat64,
-	intType:     convertInt,
-	int8Type:    convertInt8,
-	int16Type:   convertInt16,
-	int32Type:   convertInt32,
-	int64Type:   convertInt64,
-	stringType:  convertString,
-	uintType:    convertUint,
-	uint8Type:   convertUint8,
-	uint16Type:  convertUint16,
-	uint32Type:  convertUint32,
-	uint64Type:  convertUint64,
+	boolType:       convertBool,
+	complex64Type:  convertComplex64,
+	complex128Type: convertComplex128,
+	float32Type:    convertFloat32,
+	float64Type:    convertFloat64,
+	intType:        convertInt,
+	int8Type:       convertInt8,
+	int16Type:      convertInt16,
+	int32Type:      convertInt32,
+	int64Type:      convertInt64,
+	stringType:     convertString,
+	uintType:       convertUint,
+	uint8Type:      convertUint8,
+	uint16Type:     convertUint16,
+	uint32Type:     convertUint32,
+	uint64Type:     convertUint64,
+}
+
+// builtinTypeConverters are converters for concrete types.
+// They are used before the converters for kinds.
+var builtinTypeConverters = map[reflect.Type]Converter{
+	reflect.TypeOf(net.IP{}):    convertIP,
+	reflect.TypeOf(url.URL{}):   convertURL,
+	reflect.TypeOf(big.Int{}):   convertBigInt,
+	reflect.TypeOf(big.Float{}): convertBigFloat,
+	reflect.TypeOf(big.Rat{}):   convertBigRat,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Time formats of Unix timestamps for the time_format tags.
+const (
+	unixFormat      = "unix"
+	unixMilliFormat = "unixmilli"
+)
+
+// parseTime parses a time value in the format, which is either
+// a layout for time.Parse or one of the Unix timestamp formats.
+func parseTime(value string, format string) (time.Time, error) {
+	switch format {
+	case unixFormat, unixMilliFormat:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == unixMilliFormat {
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(format, value)
+}
+
+// numberError returns the error of parsing a value of the number type t,
+// which is an OverflowError if the value is out of the range of t.
+func numberError(err error, t reflect.Type) error {
+	if e, ok := err.(*strconv.NumError); ok {
+		if e.Err == strconv.ErrRange {
+			return OverflowError{Type: t}
+		}
+		return e.Err
+	}
+	return err
+}
+
+// parseComplex parses value as a complex number of bitSize bits in the
+// forms accepted by strconv.ParseComplex ("1", "2i", "1+2i", optionally
+// in parentheses), which is not available before Go 1.15.
+func parseComplex(value string, bitSize int) (complex128, error) {
+	s := value
+	if len(s) >= 2 && s[0] == '(' && s[len(s)-1] == ')' {
+		s = s[1 : len(s)-1]
+	}
+	fail := func(err error) (complex128, error) {
+		if e, ok := err.(*strconv.NumError); ok {
+			err = e.Err
+		}
+		return 0, &strconv.NumError{Func: "ParseComplex", Num: value, Err: err}
+	}
+	if !strings.HasSuffix(s, "i") {
+		re, err := strconv.ParseFloat(s, bitSize/2)
+		if err != nil {
+			return fail(err)
+		}
+		return complex(re, 0), nil
+	}
+	s = s[:len(s)-1]
+	re, im := "", s
+	for i := 1; i < len(s); i++ {
+		if (s[i] == '+' || s[i] == '-') && !strings.ContainsRune("eEpP", rune(s[i-1])) {
+			re, im = s[:i], s[i:]
+			break
+		}
+	}
+	if strings.HasPrefix(im, "+-") {
+		im = im[1:]
+	}
+	var r float64
+	if re != "" {
+		var err error
+		if r, err = strconv.ParseFloat(re, bitSize/2); err != nil {
+			return fail(err)
+		}
+	}
+	i, err := strconv.ParseFloat(im, bitSize/2)
+	if err != nil {
+		return fail(err)
+	}
+	return complex(r, i), nil
+}
+
+// numberConverter returns a converter for values of the number type t,
+// built for its bit size so values out of its range are rejected with
+// an OverflowError rather than wrapped. It returns nil for other types.
+func numberConverter(t reflect.Type) ConverterErr {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := t.Bits()
+		return func(value string) (reflect.Value, error) {
+			n, err := strconv.ParseInt(value, 10, bits)
+			if err != nil {
+				return invalidValue, numberError(err, t)
+			}
+			return reflect.ValueOf(n).Convert(t), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits := t.Bits()
+		return func(value string) (reflect.Value, error) {
+			n, err := strconv.ParseUint(value, 10, bits)
+			if err != nil {
+				return invalidValue, numberError(err, t)
+			}
+			return reflect.ValueOf(n).Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return func(value string) (reflect.Value, error) {
+			f, err := strconv.ParseFloat(value, bits)
+			if err != nil {
+				return invalidValue, numberError(err, t)
+			}
+			return reflect.ValueOf(f).Convert(t), nil
+		}
+	case reflect.Complex64, reflect.Complex128:
+		bits := t.Bits()
+		return func(value string) (reflect.Value, error) {
+			c, err := parseComplex(value, bits)
+			if err != nil {
+				return invalidValue, numberError(err, t)
+			}
+			return reflect.ValueOf(c).Convert(t), nil
+		}
+	}
+	return nil
+}
+
+// setter converts a value and sets it to v, returning why the value
+// could not be converted.
+type setter func(v reflect.Value, value string) error
+
+// kindSetter returns a setter for values of type t which sets v in place,
+// avoiding the allocations of converting to a reflect.Value first.
+// Number setters are built for the bit size of t, so values out of its
+// range return an OverflowError.
+// It returns nil for kinds without builtin converters.
+func kindSetter(t reflect.Type) setter {
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value, value string) error {
+			v.SetString(value)
+			return nil
+		}
+	case reflect.Bool:
+		return func(v reflect.Value, value string) error {
+			if value == "on" {
+				v.SetBool(true)
+				return nil
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return numberError(err, t)
+			}
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			n, err := strconv.ParseInt(value, 10, bits)
+			if err != nil {
+				return numberError(err, t)
+			}
+			v.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			n, err := strconv.ParseUint(value, 10, bits)
+			if err != nil {
+				return numberError(err, t)
+			}
+			v.SetUint(n)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			f, err := strconv.ParseFloat(value, bits)
+			if err != nil {
+				return numberError(err, t)
+			}
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.Complex64, reflect.Complex128:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			c, err := parseComplex(value, bits)
+			if err != nil {
+				return numberError(err, t)
+			}
+			v.SetComplex(c)
+			return nil
+		}
+	}
+	return nil
+}
+
+// decimalSetter returns a setter for floats using sep as the decimal
+// separator, like "3,14" for a comma. Values containing a dot, or more
+// than one separator, are rejected, as they likely have thousands separators.
+func decimalSetter(sep rune, set setter) setter {
+	return func(v reflect.Value, value string) error {
+		if strings.ContainsRune(value, '.') || strings.Count(value, string(sep)) > 1 {
+			return fmt.Errorf("thousands separators are not allowed, the decimal separator is %q", sep)
+		}
+		return set(v, strings.Replace(value, string(sep), ".", 1))
+	}
 }
 
 func convertBool(value string) reflect.Value {
@@ -55,6 +307,20 @@ func convertBool(value string) reflect.Value {
 	return invalidValue
 }
 
+func convertComplex64(value string) reflect.Value {
+	if v, err := parseComplex(value, 64); err == nil {
+		return reflect.ValueOf(complex64(v))
+	}
+	return invalidValue
+}
+
+func convertComplex128(value string) reflect.Value {
+	if v, err := parseComplex(value, 128); err == nil {
+		return reflect.ValueOf(v)
+	}
+	return invalidValue
+}
+
 func convertFloat32(value string) reflect.Value {
 	if v, err := strconv.ParseFloat(value, 32); err == nil {
 		return reflect.ValueOf(float32(v))
@@ -142,3 +408,44 @@ func convertUint64(value string) reflect.Value {
 	}
 	return invalidValue
 }
+
+func convertIP(value string) reflect.Value {
+	if v := net.ParseIP(value); v != nil {
+		return reflect.ValueOf(v)
+	}
+	return invalidValue
+}
+
+func convertURL(value string) reflect.Value {
+	if v, err := url.Parse(value); err == nil {
+		return reflect.ValueOf(*v)
+	}
+	return invalidValue
+}
+
+func convertBigInt(value string) reflect.Value {
+	if v, ok := new(big.Int).SetString(value, 10); ok {
+		return reflect.ValueOf(*v)
+	}
+	return invalidValue
+}
+
+// convertBigFloat parses the value with enough precision
+// to keep all of its digits.
+func convertBigFloat(value string) reflect.Value {
+	prec := uint(len(value)) * 4
+	if prec < 64 {
+		prec = 64
+	}
+	if v, _, err := big.ParseFloat(value, 10, prec, big.ToNearestEven); err == nil {
+		return reflect.ValueOf(*v)
+	}
+	return invalidValue
+}
+
+func convertBigRat(value string) reflect.Value {
+	if v, ok := new(big.Rat).SetString(value); ok {
+		return reflect.ValueOf(*v)
+	}
+	return invalidValue
+}
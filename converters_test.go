@@ -0,0 +1,718 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypeConverters(t *testing.T) {
+	type request struct {
+		Addr     net.IP   `query:"addr"`
+		Redirect url.URL  `query:"redirect"`
+		Next     *url.URL `query:"next"`
+		Peers    []net.IP `query:"peers"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		check  func(r request) bool
+		key    string
+	}{
+		{
+			name:   "ip",
+			target: "/?addr=10.0.0.1",
+			check:  func(r request) bool { return r.Addr.Equal(net.ParseIP("10.0.0.1")) },
+		},
+		{
+			name:   "ipv6",
+			target: "/?addr=::1",
+			check:  func(r request) bool { return r.Addr.Equal(net.IPv6loopback) },
+		},
+		{
+			name:   "ips",
+			target: "/?peers=10.0.0.1&peers=10.0.0.2",
+			check: func(r request) bool {
+				return len(r.Peers) == 2 && r.Peers[1].Equal(net.ParseIP("10.0.0.2"))
+			},
+		},
+		{
+			name:   "url",
+			target: "/?redirect=https%3A%2F%2Fx.com%2Fa%3Fb%3Dc",
+			check: func(r request) bool {
+				return r.Redirect.Scheme == "https" && r.Redirect.Host == "x.com" && r.Redirect.Path == "/a" && r.Redirect.RawQuery == "b=c"
+			},
+		},
+		{
+			name:   "url pointer",
+			target: "/?next=%2Fhome",
+			check:  func(r request) bool { return r.Next != nil && r.Next.Path == "/home" },
+		},
+		{name: "invalid ip", target: "/?addr=10.0.0", key: "addr"},
+		{name: "invalid url", target: "/?redirect=%3A", key: "redirect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.key != "" {
+				if _, ok := keyError(err, tt.key).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(dst) {
+				t.Errorf("unexpected result %+v", dst)
+			}
+		})
+	}
+}
+
+func TestNumberOverflow(t *testing.T) {
+	type request struct {
+		Int8    int8             `query:"int8"`
+		Int16   int16            `query:"int16"`
+		Int32   int32            `query:"int32"`
+		Int64   int64            `query:"int64"`
+		Uint8   uint8            `query:"uint8"`
+		Uint16  uint16           `query:"uint16"`
+		Uint32  uint32           `query:"uint32"`
+		Uint64  uint64           `query:"uint64"`
+		Float32 float32          `query:"float32"`
+		Int8s   []int8           `query:"int8s"`
+		Uint16s []*uint16        `query:"uint16s"`
+		Counts  map[string]uint8 `query:"counts"`
+	}
+	tests := []struct {
+		key      string
+		value    string
+		overflow bool
+	}{
+		{key: "int8", value: "127"},
+		{key: "int8", value: "-128"},
+		{key: "int8", value: "128", overflow: true},
+		{key: "int8", value: "-129", overflow: true},
+		{key: "int16", value: "32767"},
+		{key: "int16", value: "32768", overflow: true},
+		{key: "int32", value: "-2147483648"},
+		{key: "int32", value: "2147483648", overflow: true},
+		{key: "int64", value: "9223372036854775807"},
+		{key: "int64", value: "9223372036854775808", overflow: true},
+		{key: "uint8", value: "255"},
+		{key: "uint8", value: "256", overflow: true},
+		{key: "uint16", value: "65535"},
+		{key: "uint16", value: "65536", overflow: true},
+		{key: "uint32", value: "4294967295"},
+		{key: "uint32", value: "4294967296", overflow: true},
+		{key: "uint64", value: "18446744073709551615"},
+		{key: "uint64", value: "18446744073709551616", overflow: true},
+		{key: "float32", value: "3.4e38"},
+		{key: "float32", value: "3.5e38", overflow: true},
+		{key: "int8s", value: "1,127"},
+		{key: "int8s", value: "1,128", overflow: true},
+		{key: "uint16s", value: "65535"},
+		{key: "uint16s", value: "65536", overflow: true},
+		{key: "counts.a", value: "255"},
+		{key: "counts.a", value: "256", overflow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("GET", "/?"+tt.key+"="+tt.value, "", ""))
+			if !tt.overflow {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			e, ok := keyError(err, tt.key).(ConversionError)
+			if !ok {
+				t.Fatalf("got error %v, want a ConversionError", err)
+			}
+			if _, ok := e.Err.(OverflowError); !ok {
+				t.Errorf("got error %v, want an OverflowError", e.Err)
+			}
+		})
+	}
+}
+
+func TestNumberSyntaxError(t *testing.T) {
+	type request struct {
+		Age int8   `query:"age"`
+		IDs []uint `query:"ids"`
+	}
+	for _, key := range []string{"age", "ids"} {
+		var dst request
+		err := NewDecoder().Decode(&dst, newRequest("GET", "/?"+key+"=x", "", ""))
+		e, ok := keyError(err, key).(ConversionError)
+		if !ok || e.Err != strconv.ErrSyntax {
+			t.Errorf("got error %v for %q, want a ConversionError with a syntax error", err, key)
+		}
+	}
+}
+
+func TestBoolValues(t *testing.T) {
+	type flag bool
+	type request struct {
+		Active bool   `query:"active"`
+		Flags  []bool `query:"flags"`
+		Named  flag   `query:"named"`
+		Ptr    *bool  `query:"ptr"`
+	}
+	yes := true
+	tests := []struct {
+		name      string
+		trueVals  []string
+		falseVals []string
+		target    string
+		want      request
+		key       string
+	}{
+		{name: "default", target: "/?active=true&flags=1,f", want: request{Active: true, Flags: []bool{true, false}}},
+		{name: "default rejects yes", target: "/?active=yes", key: "active"},
+		{name: "yes no", trueVals: []string{"yes"}, falseVals: []string{"no"}, target: "/?active=YES&flags=yes,no&named=yes&ptr=yes", want: request{Active: true, Flags: []bool{true, false}, Named: true, Ptr: &yes}},
+		{name: "numbers", trueVals: []string{"1"}, falseVals: []string{"0"}, target: "/?active=1&flags=0", want: request{Active: true, Flags: []bool{false}}},
+		{name: "unknown value", trueVals: []string{"yes"}, falseVals: []string{"no"}, target: "/?active=true", key: "active"},
+		{name: "unknown element", trueVals: []string{"yes"}, falseVals: []string{"no"}, target: "/?flags=yes,1", key: "flags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			if tt.trueVals != nil {
+				d.BoolValues(tt.trueVals, tt.falseVals)
+			}
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.key != "" {
+				if _, ok := keyError(err, tt.key).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError for %q", err, tt.key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestBigNumbers(t *testing.T) {
+	type request struct {
+		Page  int       `query:"page"`
+		Int   big.Int   `name:"int" from:"query,json"`
+		Float big.Float `name:"float" from:"query,json"`
+		Rat   *big.Rat  `name:"rat" from:"query,json"`
+	}
+	tests := []struct {
+		name      string
+		target    string
+		body      string
+		wantInt   string
+		wantFloat string
+		wantRat   string
+	}{
+		{
+			name:      "query",
+			target:    "/?int=123456789012345678901234567890&float=3.14159265358979323846264338327950288&rat=1/3",
+			wantInt:   "123456789012345678901234567890",
+			wantFloat: "3.14159265358979323846264338327950288",
+			wantRat:   "1/3",
+		},
+		{
+			name:      "json",
+			body:      `{"int":123456789012345678901234567890,"float":3.14159265358979323846264338327950288,"rat":0.1}`,
+			wantInt:   "123456789012345678901234567890",
+			wantFloat: "3.14159265358979323846264338327950288",
+			wantRat:   "1/10",
+		},
+		{
+			name:      "json exponent",
+			body:      `{"int":1e3,"float":1.5e-30,"rat":2.5e-1}`,
+			wantInt:   "1000",
+			wantFloat: "1.5e-30",
+			wantRat:   "1/4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/?page=1"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			var dst request
+			if err := NewDecoder().Decode(&dst, newRequest("POST", target, "application/json", body)); err != nil {
+				t.Fatal(err)
+			}
+			if got := dst.Int.String(); got != tt.wantInt {
+				t.Errorf("got int %s, want %s", got, tt.wantInt)
+			}
+			if got := dst.Float.Text('g', -1); got != tt.wantFloat {
+				t.Errorf("got float %s, want %s", got, tt.wantFloat)
+			}
+			if dst.Rat == nil {
+				t.Fatal("got nil rat")
+			}
+			if got := dst.Rat.RatString(); got != tt.wantRat {
+				t.Errorf("got rat %s, want %s", got, tt.wantRat)
+			}
+		})
+	}
+}
+
+func TestFloatDecimalSeparator(t *testing.T) {
+	type request struct {
+		Price float64   `query:"price"`
+		Ratio *float32  `query:"ratio"`
+		Items []float64 `query:"items"`
+	}
+	ratio := float32(0.5)
+	tests := []struct {
+		name         string
+		target       string
+		want         request
+		wantOverflow bool
+		wantErr      string
+		key          string
+	}{
+		{name: "comma", target: "/?price=3,14", want: request{Price: 3.14}},
+		{name: "pointer", target: "/?ratio=0,5", want: request{Ratio: &ratio}},
+		{name: "integer", target: "/?price=42", want: request{Price: 42}},
+		{name: "slices keep commas", target: "/?items=1&items=2", want: request{Items: []float64{1, 2}}},
+		{name: "dot thousands separator", target: "/?price=1.000,5", wantErr: "thousands separators", key: "price"},
+		{name: "comma thousands separator", target: "/?price=1,000,5", wantErr: "thousands separators", key: "price"},
+		{name: "overflow", target: "/?ratio=1,0e50", wantOverflow: true, key: "ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.FloatDecimalSeparator(',')
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantErr != "" || tt.wantOverflow {
+				e, ok := keyError(err, tt.key).(ConversionError)
+				if !ok {
+					t.Fatalf("got %v, want a ConversionError for %q", err, tt.key)
+				}
+				if _, overflow := e.Err.(OverflowError); overflow != tt.wantOverflow {
+					t.Errorf("got error %v, want overflow %v", e.Err, tt.wantOverflow)
+				}
+				if tt.wantErr != "" && (e.Err == nil || !strings.Contains(e.Err.Error(), tt.wantErr)) {
+					t.Errorf("got error %v, want it to contain %q", e.Err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+type celsius float64
+
+func (c *celsius) FromString(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if f < -273.15 {
+		return errBelowAbsoluteZero
+	}
+	*c = celsius(f)
+	return nil
+}
+
+var errBelowAbsoluteZero = errors.New("below absolute zero")
+
+type fahrenheit float64
+
+func TestFromStringer(t *testing.T) {
+	type request struct {
+		Temp  celsius    `query:"temp"`
+		Ptr   *celsius   `query:"ptr"`
+		Plain fahrenheit `query:"plain"`
+	}
+	ptr := celsius(-10)
+	tests := []struct {
+		name    string
+		target  string
+		want    request
+		wantErr error
+	}{
+		{name: "valid", target: "/?temp=21.5", want: request{Temp: 21.5}},
+		{name: "pointer", target: "/?ptr=-10", want: request{Ptr: &ptr}},
+		{name: "rejected", target: "/?temp=-300", wantErr: errBelowAbsoluteZero},
+		{name: "syntax", target: "/?temp=warm", wantErr: strconv.ErrSyntax},
+		{name: "kind converter", target: "/?plain=-500", want: request{Plain: -500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantErr != nil {
+				if err := keyError(err, "temp"); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromStringerDirectJSON(t *testing.T) {
+	type request struct {
+		ID   string  `query:"id"`
+		Temp celsius `json:"temp"`
+	}
+	for _, direct := range []bool{false, true} {
+		t.Run("direct "+strconv.FormatBool(direct), func(t *testing.T) {
+			d := NewDecoder()
+			d.DirectJSON(direct)
+			err := d.Decode(&request{}, newRequest("POST", "/?id=1", "application/json", `{"temp":-300}`))
+			if err := keyError(err, "temp"); !errors.Is(err, errBelowAbsoluteZero) {
+				t.Fatalf("got %v, want %v", err, errBelowAbsoluteZero)
+			}
+		})
+	}
+}
+
+type sku string
+
+var errBadSKU = errors.New("sku must start with SKU-")
+
+func TestRegisterConverterErr(t *testing.T) {
+	type request struct {
+		SKU  sku   `query:"sku"`
+		SKUs []sku `query:"skus"`
+	}
+	tests := []struct {
+		name      string
+		target    string
+		want      request
+		wantKey   string
+		wantIndex int
+	}{
+		{name: "valid", target: "/?sku=SKU-1", want: request{SKU: "SKU-1"}},
+		{name: "slice", target: "/?skus=SKU-1&skus=SKU-2", want: request{SKUs: []sku{"SKU-1", "SKU-2"}}},
+		{name: "invalid", target: "/?sku=1", wantKey: "sku", wantIndex: -1},
+		{name: "invalid element", target: "/?skus=SKU-1&skus=2", wantKey: "skus", wantIndex: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.RegisterConverterErr(sku(""), func(s string) (reflect.Value, error) {
+				if !strings.HasPrefix(s, "SKU-") {
+					return reflect.Value{}, errBadSKU
+				}
+				return reflect.ValueOf(sku(s)), nil
+			})
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantKey != "" {
+				e, ok := keyError(err, tt.wantKey).(ConversionError)
+				if !ok || !errors.Is(e, errBadSKU) || e.Index != tt.wantIndex {
+					t.Errorf("got %#v, want a ConversionError of index %d wrapping %v", err, tt.wantIndex, errBadSKU)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterConverterWithField(t *testing.T) {
+	type request struct {
+		Day     time.Time  `query:"day" layout:"2006-01-02"`
+		Month   *time.Time `query:"month" layout:"2006-01"`
+		Default time.Time  `query:"default"`
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	month := date(2019, time.March, 1)
+	tests := []struct {
+		name    string
+		target  string
+		want    request
+		wantKey string
+	}{
+		{name: "layout", target: "/?day=2019-03-04", want: request{Day: date(2019, time.March, 4)}},
+		{name: "pointer", target: "/?month=2019-03", want: request{Month: &month}},
+		{name: "without layout", target: "/?default=2019-03-04T00:00:00Z", want: request{Default: date(2019, time.March, 4)}},
+		{name: "other layout", target: "/?day=04/03/2019", wantKey: "day"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.RegisterConverterWithField(time.Time{}, func(value string, field reflect.StructField) reflect.Value {
+				layout := field.Tag.Get("layout")
+				if layout == "" {
+					layout = time.RFC3339
+				}
+				v, err := time.Parse(layout, value)
+				if err != nil {
+					return reflect.Value{}
+				}
+				return reflect.ValueOf(v)
+			})
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantKey != "" {
+				if _, ok := keyError(err, tt.wantKey).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestComplexNumbers(t *testing.T) {
+	type request struct {
+		Z64  complex64    `query:"z64"`
+		Z128 complex128   `query:"z128"`
+		Zs   []complex128 `query:"zs"`
+		Body complex128   `json:"body"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		direct bool
+		want   request
+		key    string
+	}{
+		{name: "complex64", target: "/?z64=1%2B2i", want: request{Z64: 1 + 2i}},
+		{name: "complex128", target: "/?z128=-1.5-0.25i", want: request{Z128: -1.5 - 0.25i}},
+		{name: "parenthesized", target: "/?z128=(3%2B4i)", want: request{Z128: 3 + 4i}},
+		{name: "real only", target: "/?z128=7", want: request{Z128: 7}},
+		{name: "imaginary only", target: "/?z64=2i", want: request{Z64: 2i}},
+		{name: "slice", target: "/?zs=1i&zs=2", want: request{Zs: []complex128{1i, 2}}},
+		{name: "json string", body: `{"body":"1+1i"}`, want: request{Body: 1 + 1i}},
+		{name: "json number", body: `{"body":5}`, want: request{Body: 5}},
+		{name: "json string direct", body: `{"body":"1+2i"}`, direct: true, want: request{Body: 1 + 2i}},
+		{name: "malformed", target: "/?z128=1%2B", key: "z128"},
+		{name: "malformed complex64", target: "/?z64=i2", key: "z64"},
+		{name: "complex64 overflow", target: "/?z64=1e39", key: "z64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			d := NewDecoder()
+			d.DirectJSON(tt.direct)
+			var dst request
+			err := d.Decode(&dst, newRequest("POST", target, "application/json", body))
+			if tt.key != "" {
+				if _, ok := keyError(err, tt.key).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComplex(t *testing.T) {
+	tests := []struct {
+		value   string
+		bitSize int
+		want    complex128
+		err     error
+	}{
+		{value: "1+2i", bitSize: 128, want: 1 + 2i},
+		{value: "(3-4i)", bitSize: 128, want: 3 - 4i},
+		{value: "-2.5", bitSize: 128, want: -2.5},
+		{value: "-2i", bitSize: 128, want: -2i},
+		{value: "1e+3i", bitSize: 128, want: 1e3i},
+		{value: "1e3-1e-3i", bitSize: 128, want: 1e3 - 1e-3i},
+		{value: "0x1p-2+0x1p+2i", bitSize: 128, want: 0.25 + 4i},
+		{value: "1+-2i", bitSize: 128, want: 1 - 2i},
+		{value: "i", bitSize: 128, err: strconv.ErrSyntax},
+		{value: "1+", bitSize: 128, err: strconv.ErrSyntax},
+		{value: "1++2i", bitSize: 128, err: strconv.ErrSyntax},
+		{value: "1+2j", bitSize: 128, err: strconv.ErrSyntax},
+		{value: "()", bitSize: 128, err: strconv.ErrSyntax},
+		{value: "1e39i", bitSize: 64, err: strconv.ErrRange},
+		{value: "1e39i", bitSize: 128, want: 1e39i},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseComplex(tt.value, tt.bitSize)
+			if tt.err != nil {
+				if e, ok := err.(*strconv.NumError); !ok || e.Err != tt.err {
+					t.Errorf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindSetter(t *testing.T) {
+	tests := []struct {
+		name     string
+		typ      reflect.Type
+		sep      rune
+		value    string
+		want     interface{}
+		overflow bool
+		wantErr  bool
+	}{
+		{name: "string", typ: reflect.TypeOf(""), value: "a b", want: "a b"},
+		{name: "bool", typ: reflect.TypeOf(false), value: "true", want: true},
+		{name: "bool number", typ: reflect.TypeOf(false), value: "0", want: false},
+		{name: "bool on", typ: reflect.TypeOf(false), value: "on", want: true},
+		{name: "bool invalid", typ: reflect.TypeOf(false), value: "yes", wantErr: true},
+		{name: "int", typ: reflect.TypeOf(0), value: "-42", want: -42},
+		{name: "int8", typ: reflect.TypeOf(int8(0)), value: "-128", want: int8(-128)},
+		{name: "int8 overflow", typ: reflect.TypeOf(int8(0)), value: "128", overflow: true},
+		{name: "int invalid", typ: reflect.TypeOf(0), value: "4x", wantErr: true},
+		{name: "uint", typ: reflect.TypeOf(uint(0)), value: "42", want: uint(42)},
+		{name: "uint16", typ: reflect.TypeOf(uint16(0)), value: "65535", want: uint16(65535)},
+		{name: "uint16 overflow", typ: reflect.TypeOf(uint16(0)), value: "65536", overflow: true},
+		{name: "uint negative", typ: reflect.TypeOf(uint(0)), value: "-1", wantErr: true},
+		{name: "float64", typ: reflect.TypeOf(0.0), value: "3.25", want: 3.25},
+		{name: "float32 overflow", typ: reflect.TypeOf(float32(0)), value: "1e39", overflow: true},
+		{name: "float decimal separator", typ: reflect.TypeOf(0.0), sep: ',', value: "3,25", want: 3.25},
+		{name: "float decimal separator with dot", typ: reflect.TypeOf(0.0), sep: ',', value: "3.25", wantErr: true},
+		{name: "float decimal separator overflow", typ: reflect.TypeOf(float32(0)), sep: ',', value: "1,0e39", overflow: true},
+		{name: "named kind", typ: reflect.TypeOf(fahrenheit(0)), value: "98.5", want: fahrenheit(98.5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := kindSetter(tt.typ)
+			if set == nil {
+				t.Fatalf("no setter for %v", tt.typ)
+			}
+			if tt.sep != 0 {
+				set = decimalSetter(tt.sep, set)
+			}
+			v := reflect.New(tt.typ).Elem()
+			err := set(v, tt.value)
+			if tt.overflow {
+				if _, ok := err.(OverflowError); !ok {
+					t.Errorf("got error %v, want an OverflowError", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want an error", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.Interface(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("unsupported kinds", func(t *testing.T) {
+		for _, typ := range []reflect.Type{reflect.TypeOf(struct{}{}), reflect.TypeOf([]int{}), reflect.TypeOf(map[string]int{})} {
+			if kindSetter(typ) != nil {
+				t.Errorf("got a setter for %v", typ)
+			}
+		}
+	})
+}
+
+// BenchmarkDecodeQueryFields decodes a struct of ten query fields with
+// the setters of the fields, and with the converters they replaced.
+func BenchmarkDecodeQueryFields(b *testing.B) {
+	type request struct {
+		Name    string  `query:"name"`
+		Email   string  `query:"email"`
+		City    string  `query:"city"`
+		Country string  `query:"country"`
+		Sort    string  `query:"sort"`
+		Page    int     `query:"page"`
+		Size    int     `query:"size"`
+		Offset  int     `query:"offset"`
+		Active  bool    `query:"active"`
+		Score   float64 `query:"score"`
+	}
+	const target = "/?name=a&email=b&city=c&country=d&sort=e&page=1&size=20&offset=40&active=true&score=2.5"
+	for _, converters := range []bool{false, true} {
+		name := "setters"
+		if converters {
+			name = "converters"
+		}
+		b.Run(name, func(b *testing.B) {
+			d := NewDecoder()
+			if converters {
+				d.RegisterConverter("", convertString)
+				d.RegisterConverter(0, convertInt)
+				d.RegisterConverter(false, convertBool)
+				d.RegisterConverter(0.0, convertFloat64)
+			}
+			r := newRequest("GET", target, "", "")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var dst request
+				if err := d.Decode(&dst, r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -6,29 +6,72 @@
 package reqtruct
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // NewDecoder returns a new Decoder.
 func NewDecoder() *Decoder {
-	return &Decoder{cache: newCache(), ignoreUnknownKeys: true, maxMemory: 10 << 20}
+	return &Decoder{cache: newCache(), separators: separators{sep: '.'}, ignoreUnknownKeys: true, maxMemory: 10 << 20}
 }
 
 // Decoder decodes params from a *http.Request to a struct.
 type Decoder struct {
-	cache             *cache
-	zeroEmpty         bool
-	ignoreUnknownKeys bool
-	maxMemory         int64
-	collectErrors     bool
-	pathExtractor     func(r *http.Request) map[string]string
+	cache                    *cache
+	separators               separators
+	zeroEmpty                bool
+	ignoreUnknownKeys        bool
+	maxMemory                int64
+	maxFiles                 int
+	maxTotalSize             int64
+	maxSliceLen              int
+	maxBodySize              int64
+	readTimeout              time.Duration
+	collectErrors            bool
+	requireContentType       bool
+	requireContentTypeHeader bool
+	boolPresenceTrue         bool
+	headerListFields         bool
+	parallelArrays           bool
+	directJSON               bool
+	caseSensitiveJSON        bool
+	decompressBodies         bool
+	allowEmptyBody           bool
+	bodyCodecs               map[string]BodyCodec
+	boolConverter            Converter
+	pathParamsEncoded        bool
+	forbidMethodFields       bool
+	atomic                   bool
+	dryRun                   bool
+	lazyNil                  bool
+	suggestKeys              bool
+	emptySliceAsPresent      bool
+	emptyPointerAsPresent    bool
+	overrides                map[string]int
+	disallowed               map[int]bool
+	querySeparator           rune
+	flatKeys                 bool
+	mask                     [][]string
+	precedence               []int
+	onField                  func(key string, location int, value reflect.Value)
+	allocator                func(t reflect.Type) reflect.Value
+	logger                   func(format string, args ...interface{})
+	pathExtractor            func(r *http.Request) map[string]string
 }
 
 // ZeroEmpty controls the behaviour when the decoder encounters empty values
@@ -45,8 +88,10 @@ func (d *Decoder) ZeroEmpty(z bool) {
 
 // DefaultLocation sets the default location to look for params.
 // It is only applied if a field does not have location tags.
+// Structs implementing DefaultLocator set their own default location.
 func (d *Decoder) DefaultLocation(l int) {
 	d.cache.defaultLocation = l
+	d.cache.reset()
 }
 
 // IgnoreUnknownKeys controls the behaviour when the decoder encounters unknown
@@ -59,75 +104,1246 @@ func (d *Decoder) IgnoreUnknownKeys(i bool) {
 	d.ignoreUnknownKeys = i
 }
 
+// SuggestKeys controls whether errors for unknown keys suggest the closest
+// valid key, like "username" for "usrname". It only has an effect if
+// unknown keys are not ignored.
+func (d *Decoder) SuggestKeys(s bool) {
+	d.suggestKeys = s
+}
+
 // Max memory sets the max memory used when parsing multipart forms
 func (d *Decoder) MaxMemory(m int64) {
 	d.maxMemory = m
 }
 
-// PathExtractor defines the mechanism to extract path params from URIs.
-// It takes a function that takes a *http.Request and returns map[string]string
-func (d *Decoder) PathExtractor(p func(r *http.Request) map[string]string) {
-	d.pathExtractor = p
+// MaxFiles sets the max number of files accepted in multipart forms.
+// Zero means no limit.
+func (d *Decoder) MaxFiles(n int) {
+	d.maxFiles = n
+}
+
+// MaxTotalSize sets the max total size in bytes of files accepted in multipart forms.
+// Zero means no limit.
+func (d *Decoder) MaxTotalSize(bytes int64) {
+	d.maxTotalSize = bytes
+}
+
+// MaxBodySize sets the max size in bytes of request bodies, checked both
+// before and after decompressing them. Decode returns a BodyTooLargeError
+// for larger bodies. Zero means no limit.
+func (d *Decoder) MaxBodySize(bytes int64) {
+	d.maxBodySize = bytes
+}
+
+// ReadTimeout sets the max duration of reading request bodies, including
+// parsing forms and JSON bodies, which protects against clients sending
+// them slowly. Decode returns a TimeoutError once it is exceeded.
+// A body which a read of was pending then is closed once the read returns,
+// and the body of the request is replaced with one failing with the error.
+// Zero means no limit.
+func (d *Decoder) ReadTimeout(timeout time.Duration) {
+	d.readTimeout = timeout
+}
+
+// MaxSliceLen sets the max number of elements decoded to a slice field,
+// including slices of structs, so a param repeated many times cannot grow
+// a slice without bounds. Zero means no limit.
+func (d *Decoder) MaxSliceLen(n int) {
+	d.maxSliceLen = n
+}
+
+// sliceLenError returns a LimitExceededError if n elements exceed
+// the max slice length, and nil otherwise.
+func (d *Decoder) sliceLenError(path string, n int) error {
+	if d.maxSliceLen > 0 && n > d.maxSliceLen {
+		return LimitExceededError{Key: path, Name: "max slice length", Limit: int64(d.maxSliceLen)}
+	}
+	return nil
+}
+
+// PathExtractor defines the mechanism to extract path params from URIs.
+// It takes a function that takes a *http.Request and returns map[string]string
+//
+// Path params not declared by the struct are unknown keys like params
+// in other locations. The value of a slice field, like the one of
+// a catch-all route, is split into its path segments.
+func (d *Decoder) PathExtractor(p func(r *http.Request) map[string]string) {
+	d.pathExtractor = p
+}
+
+// QuerySeparator sets a separator of the pairs in the query string,
+// like ';' for legacy clients sending "a=1;b=2", in addition to '&'.
+// The query is then parsed from the raw query of the URL, and pairs
+// which cannot be unescaped are reported as ConversionErrors
+// of their keys. Zero only uses the default '&'.
+func (d *Decoder) QuerySeparator(sep rune) {
+	d.querySeparator = sep
+}
+
+// PathParamsEncoded controls whether path params are percent-decoded
+// before being converted. It should be true for routers returning
+// path params as they appear in the URL.
+// Path params with malformed escapes are reported as conversion errors.
+//
+// The default value is false, that is path params are used as they are.
+func (d *Decoder) PathParamsEncoded(e bool) {
+	d.pathParamsEncoded = e
+}
+
+// ForbidMethodFields controls the behaviour when the decoder encounters
+// a param for a field which is not accepted for the request method,
+// as set by the methods tag, like `methods:"POST,PUT"`.
+// If f is true then a MethodError is returned for the param.
+// If f is false then the param is ignored.
+//
+// The default value is false.
+func (d *Decoder) ForbidMethodFields(f bool) {
+	d.forbidMethodFields = f
+}
+
+// methodAllowed reports whether the param with the given key is accepted
+// for the request method, recording a MethodError if it is not and
+// such params are forbidden.
+func (d *Decoder) methodAllowed(key string, methods []string, method string, errors MultiError) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	if d.forbidMethodFields {
+		errors[key] = MethodError{Key: key, Method: method, AllowedMethods: methods}
+	}
+	return false
+}
+
+// filterMethods removes the params which are not accepted for the request method.
+func (d *Decoder) filterMethods(method string, m map[string][]string, fs map[string][]*multipart.FileHeader, ps map[string][]pathPart, errors MultiError) {
+	for k := range m {
+		if parts := ps[k]; parts != nil && !d.methodAllowed(k, parts[len(parts)-1].methods, method, errors) {
+			delete(m, k)
+		}
+	}
+	for k := range fs {
+		if parts := ps[k]; parts != nil && !d.methodAllowed(k, parts[len(parts)-1].methods, method, errors) {
+			delete(fs, k)
+		}
+	}
+}
+
+// NameFunc allows settings a special function for getting fields aliases
+func (d *Decoder) NameFunc(n func(field string, locations []int) string) {
+	d.cache.nameFunc = n
+	d.cache.reset()
+}
+
+// KeyNormalizer sets a function applied to both the keys of params and
+// the aliases of fields before matching them, so keys differing only in
+// style, like user-name, user_name and userName, can match a single field.
+// Unlike NameFunc, it does not change the aliases.
+func (d *Decoder) KeyNormalizer(n func(key string) string) {
+	d.cache.keyNormalizer = n
+	d.cache.reset()
+}
+
+// FloatDecimalSeparator sets the decimal separator of float fields,
+// so a form input like "3,14" can be decoded with a comma separator.
+// Values with thousands separators, like "1.000,5" or "1,000,5", are
+// then rejected with an error saying so, as they are not supported.
+//
+// It only applies to single float fields, so it does not conflict with
+// splitting slice values on commas. The default separator is the dot.
+func (d *Decoder) FloatDecimalSeparator(sep rune) {
+	d.cache.decimalSep = sep
+	d.cache.reset()
+}
+
+// FallbackToJSONTag controls whether the json tag only names fields,
+// so structs already tagged for JSON can be decoded from other locations
+// without tagging every field again.
+// If f is true then the json tag is not used for the location of a field,
+// which comes from the other location tags, the from tag, its parent or
+// the default location, and its name is used as the alias when there is
+// no other. A field with json:"-" is ignored unless it has a location tag.
+func (d *Decoder) FallbackToJSONTag(f bool) {
+	d.cache.jsonFallback = f
+	d.cache.reset()
+}
+
+// PrefixEmbedded controls how fields of embedded structs are matched.
+// If p is true then they are not promoted, and their aliases must be
+// prefixed by the alias of the embedded struct, like "Base.Name".
+//
+// The default value is false, that is fields of embedded structs are
+// matched as if they were declared in the parent struct.
+//
+// Embedded types which are not structs, like a named int, are always
+// decoded as normal fields aliased by their type names unless tagged.
+func (d *Decoder) PrefixEmbedded(p bool) {
+	d.cache.prefixEmbedded = p
+	d.cache.reset()
+}
+
+// TagPriority sets the order in which the location tags of a field are
+// looked up for its alias, from the highest priority to the lowest.
+// The first location tag found decides the alias and the location of the field.
+// Tags of locations missing from the priority are ignored.
+//
+// The default priority is path, header, cookie, query, form, file and then json.
+func (d *Decoder) TagPriority(locations []int) {
+	priority := make([]int, 0, len(locations))
+	for _, l := range locations {
+		if _, ok := locationTags[l]; ok && !containsInt(priority, l) {
+			priority = append(priority, l)
+		}
+	}
+	d.cache.tagPriority = priority
+	d.cache.reset()
+}
+
+// FlatKeys controls whether keys containing separators, like "user.name",
+// are matched against fields with such aliases before being parsed
+// as paths to nested fields. It makes it possible to decode APIs using
+// dotted names for flat params. A single field can be matched this way
+// with the flat option, like `query:"user.name,flat"`.
+//
+// The default value is false.
+func (d *Decoder) FlatKeys(f bool) {
+	d.flatKeys = f
+}
+
+// LocationPrecedence sets the order in which the locations of a param
+// present in more than one of them are preferred, from the highest
+// precedence to the lowest. Locations missing from it follow in their
+// default order, which is path, header, cookie, query, form then json.
+//
+// For instance, LocationPrecedence([]int{LocationJSON}) lets a body
+// override the query params instead of the other way around.
+func (d *Decoder) LocationPrecedence(locations []int) {
+	precedence := make([]int, 0, len(locationPrecedence))
+	for _, l := range locations {
+		if containsInt(locationPrecedence, l) && !containsInt(precedence, l) {
+			precedence = append(precedence, l)
+		}
+	}
+	for _, l := range locationPrecedence {
+		if !containsInt(precedence, l) {
+			precedence = append(precedence, l)
+		}
+	}
+	d.precedence = precedence
+}
+
+// Separator defines runes to be used as separators.
+// If given '[', ']', '.' for example, then paths should be like a[b].[0].[c]
+// This is provided to make it possible to accept serialized objects from jQuery for example.
+// Both left and right can be set to 0 if only changing the separator
+func (d *Decoder) Separator(left rune, right rune, sep rune) {
+	d.separators = separators{left: left, right: right, sep: sep}
+}
+
+// WithSeparator returns a copy of the decoder using the given separators.
+// Like Clone, the copy starts with the struct information already cached
+// by the decoder, so it is cheap to create one per route, and its options
+// can be changed without affecting the decoder.
+// The arguments are the same as in Separator.
+func (d *Decoder) WithSeparator(left rune, right rune, sep rune) *Decoder {
+	c := *d
+	c.cache = d.cache.clone()
+	c.separators = separators{left: left, right: right, sep: sep}
+	return &c
+}
+
+// Clone returns a copy of the decoder which can be customized without
+// affecting the decoder, like registering converters or changing the
+// default location for a group of handlers.
+// The copy starts with the struct information already cached by the
+// decoder, which is only rebuilt if an option it depends on is changed.
+//
+// Changing the options of a decoder is not safe while it is used
+// concurrently, so Clone is the safe way to derive a tweaked decoder
+// from one already in use.
+func (d *Decoder) Clone() *Decoder {
+	c := *d
+	c.cache = d.cache.clone()
+	return &c
+}
+
+// RegisterConverter registers a converter function for a custom type.
+func (d *Decoder) RegisterConverter(value interface{}, converterFunc Converter) {
+	d.cache.registerConverter(value, converterFunc)
+}
+
+// RegisterConverterErr registers a converter function for a custom type
+// which returns why values cannot be converted. The error is wrapped
+// in the ConversionError returned by Decode.
+func (d *Decoder) RegisterConverterErr(value interface{}, converterFunc ConverterErr) {
+	d.cache.registerConverterErr(value, converterFunc)
+}
+
+// RegisterConverterWithField registers a converter function for a custom type
+// which receives the field being decoded.
+// It makes it possible for a single converter to read options from the field tags.
+func (d *Decoder) RegisterConverterWithField(value interface{}, converterFunc ConverterWithField) {
+	d.cache.registerConverterWithField(value, converterFunc)
+}
+
+// CollectErrors specifies whether to return on the first error or accumulate errors.
+func (d *Decoder) CollectErrors(c bool) {
+	d.collectErrors = c
+}
+
+// RequireContentType controls whether request bodies are decoded as JSON
+// only when the Content-Type is explicitly "application/json".
+// If r is true and the Content-Type is anything else, Decode returns
+// a ContentTypeError instead of falling back to JSON.
+func (d *Decoder) RequireContentType(r bool) {
+	d.requireContentType = r
+}
+
+// RequireContentTypeHeader controls whether requests with a body must
+// have a Content-Type header when the struct has fields read from the body.
+// If r is true and the header is missing, Decode returns a ContentTypeError
+// with the expected Content-Type instead of decoding the body as JSON.
+func (d *Decoder) RequireContentTypeHeader(r bool) {
+	d.requireContentTypeHeader = r
+}
+
+// EmptySliceAsPresent controls the behaviour when the decoder encounters
+// a single empty value for a slice field, like "?tags=".
+// If e is true then the field is set to an empty non-nil slice, so it can be
+// told apart from a missing param, which leaves the field nil.
+// If e is false then the empty value is handled according to ZeroEmpty.
+func (d *Decoder) EmptySliceAsPresent(e bool) {
+	d.emptySliceAsPresent = e
+}
+
+// EmptyPointerAsPresent controls the behaviour when the decoder encounters
+// an empty value for a nil pointer field, like "?name=" for a *string.
+// If e is true then the field is set to a pointer to the zero value, so it
+// can be told apart from a missing param, which leaves the field nil.
+// This is useful for PATCH requests.
+// If e is false then the field is left nil.
+// JSON empty strings are values rather than empty params,
+// so they are always set.
+func (d *Decoder) EmptyPointerAsPresent(e bool) {
+	d.emptyPointerAsPresent = e
+}
+
+// BoolPresenceTrue controls the behaviour when the decoder encounters
+// an empty value for a bool field.
+// If b is true then a key present without a value, like "?verbose",
+// sets the bool field to true.
+// If b is false then empty values are handled according to ZeroEmpty.
+func (d *Decoder) BoolPresenceTrue(b bool) {
+	d.boolPresenceTrue = b
+}
+
+// BoolValues sets the values accepted for bool fields, replacing the
+// values accepted by strconv.ParseBool. Values are matched case-insensitively,
+// and values in neither set are reported as conversion errors.
+func (d *Decoder) BoolValues(trueValues []string, falseValues []string) {
+	trueValues = append([]string{}, trueValues...)
+	falseValues = append([]string{}, falseValues...)
+	d.boolConverter = func(value string) reflect.Value {
+		for _, v := range trueValues {
+			if strings.EqualFold(value, v) {
+				return reflect.ValueOf(true)
+			}
+		}
+		for _, v := range falseValues {
+			if strings.EqualFold(value, v) {
+				return reflect.ValueOf(false)
+			}
+		}
+		return invalidValue
+	}
+}
+
+// kindConverter returns the builtin converter for the kind,
+// taking the bool values set on the decoder into account.
+func (d *Decoder) kindConverter(k reflect.Kind) Converter {
+	if k == reflect.Bool && d.boolConverter != nil {
+		return d.boolConverter
+	}
+	return builtinConverters[k]
+}
+
+// HeaderListFields controls whether header values are treated as comma
+// separated lists as defined in RFC 7230.
+// If h is true then slice fields sourced from headers get each trimmed
+// element of the list, and other fields get the first element.
+func (d *Decoder) HeaderListFields(h bool) {
+	d.headerListFields = h
+}
+
+// ParallelArrays controls whether slices of structs can be sent as parallel
+// arrays without indices.
+// If p is true then users.name=a&users.name=b&users.age=1&users.age=2
+// is decoded the same as users.0.name=a&users.0.age=1&users.1.name=b&users.1.age=2.
+// All the parallel arrays of a slice must have the same length.
+func (d *Decoder) ParallelArrays(p bool) {
+	d.parallelArrays = p
+}
+
+// DirectJSON controls how JSON bodies are decoded when the struct has
+// fields in other locations as well.
+// If j is true then the JSON fields are decoded directly using encoding/json,
+// the same as structs having only JSON fields, which keeps the types of the
+// JSON values intact. The other locations are decoded afterwards.
+// It is ignored when the fields or the options of the decoder need more
+// than encoding/json does, as Decode does for structs having only JSON fields.
+// If j is false then the JSON body is flattened and converted like the other
+// locations.
+func (d *Decoder) DirectJSON(j bool) {
+	d.directJSON = j
+}
+
+// CaseSensitiveJSON controls how the keys of JSON bodies are matched
+// to the aliases of the fields, at all levels of nesting.
+// If c is true then they must match exactly, like "userName" does not match
+// a field with the alias "username", while the keys of the other locations
+// are still matched case-insensitively. JSON bodies are then never decoded
+// directly using encoding/json, which matches keys case-insensitively,
+// and DirectJSON has no effect.
+// If c is false then they are matched case-insensitively like the other
+// locations.
+//
+// The default value is false.
+func (d *Decoder) CaseSensitiveJSON(c bool) {
+	d.caseSensitiveJSON = c
+}
+
+// DecodeCompressedBodies controls whether request bodies are decompressed
+// according to the Content-Encoding header before being decoded.
+// The gzip and deflate encodings are supported.
+func (d *Decoder) DecodeCompressedBodies(c bool) {
+	d.decompressBodies = c
+}
+
+// AllowEmptyBody controls the behaviour when a request expected to have
+// a form or JSON body has a missing or empty body.
+// If a is true then the body is treated as having no values and the body
+// fields keep their values.
+// If a is false then the body is parsed as usual, which returns an error
+// for most requests.
+func (d *Decoder) AllowEmptyBody(a bool) {
+	d.allowEmptyBody = a
+}
+
+// OverrideLocation changes the location of the param with the given alias
+// without changing the struct tags, so a struct can be shared by routes
+// which get the param from different locations.
+// The override takes precedence over the declared locations, that is
+// the param is only accepted from the given location. Nested fields
+// of the param inherit the location.
+//
+// It is not safe to call concurrently with Decode.
+func (d *Decoder) OverrideLocation(alias string, location int) {
+	overrides := make(map[string]int, len(d.overrides)+1)
+	for k, v := range d.overrides {
+		if !strings.EqualFold(k, alias) {
+			overrides[k] = v
+		}
+	}
+	overrides[alias] = location
+	d.overrides = overrides
+	d.cache.reset()
+}
+
+// DisallowLocation stops the decoder from reading params from the location,
+// like LocationJSON for endpoints which must never interpret a body as JSON,
+// even if a struct accidentally has JSON fields. Decode returns
+// a DisallowedLocationError if a field can only be decoded from
+// disallowed locations.
+//
+// It is not safe to call concurrently with Decode.
+func (d *Decoder) DisallowLocation(location int) {
+	disallowed := make(map[int]bool, len(d.disallowed)+1)
+	for l := range d.disallowed {
+		disallowed[l] = true
+	}
+	disallowed[location] = true
+	d.disallowed = disallowed
+	d.cache.reset()
+}
+
+// checkDisallowed checks that the fields of the struct type t, and of its
+// nested structs, can be decoded from at least one allowed location.
+// Parent are the locations defined by the parents of the fields.
+func (d *Decoder) checkDisallowed(t reflect.Type, path []string, parent []int, visited map[reflect.Type]bool) error {
+	visited[t] = true
+	defer delete(visited, t)
+
+	for _, f := range d.cache.get(t).fields {
+		if f.isAnonymous && f.alias == "" {
+			continue
+		}
+		p := append(append([]string{}, path...), f.alias)
+		locations := parent
+		if f.locationsDefined || len(parent) == 0 {
+			locations = f.locations
+		}
+		if l, ok := d.override(f.alias); ok && len(path) == 0 {
+			locations = []int{l}
+		}
+		ft := underlyingElem(f.typ)
+		if d.cache.isNested(f) {
+			if !visited[ft] {
+				if f.isIndexed() {
+					p = append(p, "0")
+				}
+				if err := d.checkDisallowed(ft, p, locations, visited); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		allowed := false
+		for _, l := range locations {
+			if !d.disallowed[l] {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return DisallowedLocationError{Key: d.separators.joinPath(p), Locations: locations}
+		}
+	}
+	return nil
+}
+
+// RegisterBodyCodec registers a codec for request bodies of the given
+// media type, like "application/yaml". The values decoded by the codec
+// are decoded to the JSON fields, the same as a JSON body.
+// Registering a codec for "application/json" replaces the builtin one.
+//
+// It is not safe to call concurrently with Decode.
+func (d *Decoder) RegisterBodyCodec(contentType string, codec BodyCodec) {
+	codecs := make(map[string]BodyCodec, len(d.bodyCodecs)+1)
+	for k, v := range d.bodyCodecs {
+		codecs[k] = v
+	}
+	codecs[strings.ToLower(contentType)] = codec
+	d.bodyCodecs = codecs
+}
+
+// bodyCodec returns the codec for the body of the request,
+// and whether it is a registered codec rather than the builtin JSON one.
+func (d *Decoder) bodyCodec(r *http.Request) (BodyCodec, bool) {
+	if len(d.bodyCodecs) > 0 {
+		if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			if codec, ok := d.bodyCodecs[mt]; ok {
+				return codec, true
+			}
+		}
+	}
+	return jsonCodec, false
+}
+
+// OnField sets a function which is called for every field set by the decoder.
+// It receives the key of the param as sent in the request, the location it
+// was decoded from, and the value of the field after being set.
+// It is useful for instrumentation, like counting the use of deprecated params.
+// It is not called by DryRun, which sets no fields.
+func (d *Decoder) OnField(f func(key string, location int, value reflect.Value)) {
+	d.onField = f
+}
+
+// SetLogger sets a function called with diagnostic messages while decoding,
+// like which keys are skipped as unknown and how bodies are decoded.
+// It is meant for debugging; log.Printf can be used.
+func (d *Decoder) SetLogger(l func(format string, args ...interface{})) {
+	d.logger = l
+}
+
+func (d *Decoder) logf(format string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger(format, args...)
+	}
+}
+
+// SetAllocator sets a function allocating the values nil pointers are set to
+// while decoding, like pointers to nested structs and the elements of slices
+// of pointers, so they can be taken from a pool instead.
+// It receives the type of the value and must return a pointer to a zero value
+// of the type, like reflect.New does. The decoder assumes the value is not
+// used elsewhere, and it stays referenced by the struct decoded to until the
+// caller releases it, usually after putting the struct back in its pool.
+// Values allocated for LazyNil pointers are dropped if nothing is decoded
+// to them.
+//
+// A nil allocator, the default, uses reflect.New, which is also used when
+// the allocator returns an invalid value. Decoding panics if the allocator
+// returns a value of another type.
+func (d *Decoder) SetAllocator(a func(t reflect.Type) reflect.Value) {
+	d.allocator = a
+}
+
+// alloc returns a pointer to a new zero value of type t,
+// using the allocator of the decoder if set.
+func (d *Decoder) alloc(t reflect.Type) reflect.Value {
+	if d.allocator != nil {
+		if p := d.allocator(t); p.IsValid() {
+			if p.Type() != reflect.PtrTo(t) {
+				panic(fmt.Sprintf("reqtruct: allocator returned a %s for %s", p.Type(), t))
+			}
+			return p
+		}
+	}
+	return reflect.New(t)
+}
+
+// Atomic controls whether the struct is left unmodified when decoding fails.
+// If a is true then the request is decoded to a copy of the struct, which
+// is only assigned to the struct if there are no errors. Pointers in the
+// struct are kept, and the values they point to are updated instead.
+// If a is false then the struct is decoded in place, and on errors it is
+// left with the fields decoded before the errors.
+//
+// The default value is false.
+func (d *Decoder) Atomic(a bool) {
+	d.atomic = a
+}
+
+// LazyNil controls the allocation of nil pointers to nested structs.
+// If l is true then they are only allocated if a non-zero value is decoded
+// to one of their fields, so a param sent with an empty value leaves them nil.
+// If l is false then they are allocated whenever one of their params is present.
+//
+// The default value is false.
+func (d *Decoder) LazyNil(l bool) {
+	d.lazyNil = l
+}
+
+// Decode decodes a *http.Request to a struct.
+//
+// The first parameter must be a pointer to a struct.
+// The second parameter is a pointer to http.Request.
+//
+// When a param is present in more than one of the locations allowed for
+// its field, only the values from the location with the highest precedence
+// are used. The precedence is path, header, cookie, query, form then json,
+// unless set by LocationPrecedence.
+// Slice fields get the values from all the locations, in the same order.
+func (d *Decoder) Decode(dst interface{}, r *http.Request) error {
+	if d.atomic {
+		return d.decodeAtomic(dst, r, nil)
+	}
+	return d.decodeRequest(dst, r, nil)
+}
+
+// MustDecode is like Decode but panics if the request cannot be decoded.
+// The panic message lists all the errors, which makes it useful in tests.
+func (d *Decoder) MustDecode(dst interface{}, r *http.Request) {
+	if err := d.Decode(dst, r); err != nil {
+		if e, ok := err.(MultiError); ok {
+			panic("reqtruct: " + e.FullError())
+		}
+		panic("reqtruct: " + err.Error())
+	}
+}
+
+// DecodeWithPathParams decodes a *http.Request to a struct using the given
+// path params instead of the ones returned by the path extractor.
+//
+// It is useful when the router already provides the path params.
+// If params is nil then the path extractor is used.
+func (d *Decoder) DecodeWithPathParams(dst interface{}, r *http.Request, params map[string]string) error {
+	if d.atomic {
+		return d.decodeAtomic(dst, r, params)
+	}
+	return d.decodeRequest(dst, r, params)
+}
+
+// DecodeMasked decodes a *http.Request to a struct like Decode, but only
+// applies the params in the mask, like a field mask of a PATCH request.
+// Params outside the mask are ignored even if present, and never reported
+// as unknown keys.
+//
+// The mask has paths to params, like "name" or "address.city".
+// A path covers the params nested in it, so "address" covers "address.city".
+// JSON bodies are not decoded directly, even if set by DirectJSON.
+func (d *Decoder) DecodeMasked(dst interface{}, r *http.Request, mask []string) error {
+	c := *d
+	// Objects are flattened so the mask can reach into them.
+	c.directJSON = false
+	c.mask = make([][]string, 0, len(mask))
+	for _, p := range mask {
+		if keys, err := d.separators.splitPath(p); err == nil {
+			c.mask = append(c.mask, keys)
+		}
+	}
+	if c.atomic {
+		return c.decodeAtomic(dst, r, nil)
+	}
+	return c.decodeRequest(dst, r, nil)
+}
+
+// masked reports whether the param with the key is outside the mask
+// of the decoder and must be ignored.
+// If nested is true then the params nested in the key are yet to be
+// checked, so keys leading to paths in the mask are not ignored.
+func (d *Decoder) masked(key string, nested bool) bool {
+	if d.mask == nil {
+		return false
+	}
+	keys, err := d.separators.splitPath(key)
+	if err != nil {
+		return true
+	}
+mask:
+	for _, m := range d.mask {
+		n := len(m)
+		if n > len(keys) && !nested {
+			continue
+		} else if n > len(keys) {
+			n = len(keys)
+		}
+		for i := 0; i < n; i++ {
+			if !strings.EqualFold(m[i], keys[i]) {
+				continue mask
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// DecodeMultipart decodes an already parsed multipart form to a struct,
+// without a *http.Request. It is useful when the form was parsed
+// by another layer, or in tests.
+//
+// The values of the form are decoded as if they were sent in the given
+// location, usually LocationForm, and its files as sent in LocationFile.
+func (d *Decoder) DecodeMultipart(dst interface{}, form *multipart.Form, location int) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("interface must be a pointer to struct")
+	}
+	if form == nil {
+		return errors.New("multipart form must not be nil")
+	}
+	v = v.Elem()
+	t := v.Type()
+	errors := MultiError{}
+	lens := map[reflect.Value]map[int]int{}
+	ps := map[string][]pathPart{}
+	if len(d.disallowed) > 0 {
+		if err := d.cache.disallowedError(t, func() error {
+			return d.checkDisallowed(t, nil, nil, map[reflect.Type]bool{})
+		}); err != nil {
+			return err
+		}
+	}
+	files := form.File
+	if d.disallowed[LocationFile] {
+		files = nil
+	}
+	if err := d.checkLimits(files); err != nil {
+		return err
+	}
+	info := d.cache.get(t)
+	if info.filesMap != nil && files != nil {
+		setFilesMap(v, info.filesMap, files)
+	}
+	d.checkFiles(files, t, ps, errors)
+	if !d.collectErrors && len(errors) > 0 {
+		return errors
+	}
+	m := map[string][]string{}
+	from := map[string]keySource{}
+	var rest map[string]interface{}
+	if info.remainder != nil {
+		rest = map[string]interface{}{}
+	}
+	if !d.disallowed[location] {
+		d.merge(m, form.Value, t, location, ps, from, rest, errors)
+	}
+	if !d.collectErrors && len(errors) > 0 {
+		return errors
+	}
+	d.decodeMaps(t, v, m, files, ps, from, lens, map[walkKey]reflect.Value{}, errors)
+	if len(rest) > 0 {
+		setRemainder(v, info.remainder, rest)
+	}
+	if info.containsRequiredIf && (d.collectErrors || len(errors) == 0) {
+		d.checkRequiredIf(info, v, ps, errors)
+	}
+	if len(info.groups) > 0 && (d.collectErrors || len(errors) == 0) {
+		d.checkGroups(info, ps, errors)
+	}
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// decodeAtomic decodes the request to a copy of dst,
+// and only sets dst to the copy if there are no errors.
+func (d *Decoder) decodeAtomic(dst interface{}, r *http.Request, pathParams map[string]string) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("interface must be a pointer to struct")
+	}
+	copies := map[ptrKey]pointerCopy{}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(copyValue(v.Elem(), copies))
+	if err := d.decodeRequest(c.Interface(), r, pathParams); err != nil {
+		return err
+	}
+	originals := make(map[ptrKey]reflect.Value, len(copies))
+	for _, p := range copies {
+		originals[keyOf(p.copy)] = p.original
+	}
+	v.Elem().Set(restoreValue(c.Elem(), originals, map[ptrKey]reflect.Value{}))
+	return nil
+}
+
+// ptrKey identifies a pointer by its type and address.
+type ptrKey struct {
+	typ  reflect.Type
+	addr uintptr
+}
+
+func keyOf(p reflect.Value) ptrKey {
+	return ptrKey{typ: p.Type(), addr: p.Pointer()}
+}
+
+// pointerCopy is a pointer and its copy made by copyValue.
+type pointerCopy struct {
+	original reflect.Value
+	copy     reflect.Value
+}
+
+// copyValue returns a deep copy of v, so that decoding to the copy does not
+// modify v through shared pointers, slices or maps.
+// Unexported fields are copied shallowly since they are never decoded.
+// Each pointer is copied once and recorded in copies, so pointers shared
+// in v are shared in the copy and cycles are copied as cycles.
+func copyValue(v reflect.Value, copies map[ptrKey]pointerCopy) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		if p, ok := copies[keyOf(v)]; ok {
+			return p.copy
+		}
+		p := reflect.New(v.Type().Elem())
+		copies[keyOf(v)] = pointerCopy{original: v, copy: p}
+		p.Elem().Set(copyValue(v.Elem(), copies))
+		return p
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i), copies))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copies))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copies))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), copyValue(iter.Value(), copies))
+		}
+		return c
+	}
+	return v
+}
+
+// restoreValue returns the decoded copy v with the copied pointers
+// replaced by the original ones, which are set to what their copies point
+// to. This keeps the pointers of the struct, and the ones shared with it,
+// pointing to the decoded values. originals maps the copies to the original
+// pointers, and restored records the pointers already walked.
+func restoreValue(v reflect.Value, originals map[ptrKey]reflect.Value, restored map[ptrKey]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		k := keyOf(v)
+		if p, ok := restored[k]; ok {
+			return p
+		}
+		p, ok := originals[k]
+		if !ok {
+			p = v
+		}
+		restored[k] = p
+		p.Elem().Set(restoreValue(v.Elem(), originals, restored))
+		return p
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			if f := c.Field(i); f.CanSet() {
+				f.Set(restoreValue(f, originals, restored))
+			}
+		}
+		return c
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			v.Index(i).Set(restoreValue(v.Index(i), originals, restored))
+		}
+		return v
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.Len(); i++ {
+			c.Index(i).Set(restoreValue(c.Index(i), originals, restored))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			v.SetMapIndex(iter.Key(), restoreValue(iter.Value(), originals, restored))
+		}
+		return v
+	}
+	return v
+}
+
+// Check validates the struct tags of dst without a request.
+// It returns a DuplicateAliasError if two fields of the struct, or of
+// its nested structs, have the same alias in the same location,
+// an UnknownLocationError if the from tag of a field has an unknown
+// location, an UnknownEncodingError if the encoding tag of a field has
+// an unknown encoding, and a NoFieldsError if a struct has no fields to decode,
+// like when all of them are ignored or have unsupported types.
+//
+// It is meant to be called in tests or at startup to catch mistakes early.
+func (d *Decoder) Check(dst interface{}) error {
+	t := reflect.TypeOf(dst)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return errors.New("interface must be a pointer to struct")
+	}
+	if _, ok := dst.(RequestDecoder); ok {
+		return nil
+	}
+	return d.cache.check(t.Elem(), map[reflect.Type]bool{})
+}
+
+// decodeRequestDecoders delegates the decoding of the fields implementing
+// RequestDecoder to them, walking into nested structs.
+func (d *Decoder) decodeRequestDecoders(info *structInfo, v reflect.Value, r *http.Request, path []string, errors MultiError) {
+	for _, f := range info.fields {
+		nested := !f.isRequestDecoder && f.typ.Kind() == reflect.Struct && !f.isAnonymous
+		if !f.isRequestDecoder && !nested {
+			continue
+		}
+		// Embedded structs are only allocated for the fields decoding
+		// themselves, as nested structs might not have any.
+		fv, ok := settableField(v, f.name, f.isRequestDecoder)
+		if !ok {
+			continue
+		}
+		if nested {
+			d.decodeRequestDecoders(d.cache.get(f.typ), fv, r, append(path[:len(path):len(path)], f.alias), errors)
+			continue
+		}
+		if fv.Kind() == reflect.Ptr && fv.IsNil() {
+			fv.Set(d.alloc(fv.Type().Elem()))
+		}
+		rd, ok := fv.Interface().(RequestDecoder)
+		if !ok {
+			rd = fv.Addr().Interface().(RequestDecoder)
+		}
+		if err := rd.DecodeRequest(r); err != nil {
+			key := d.separators.joinPath(append(path[:len(path):len(path)], f.canonicalPath...))
+			errors[key] = DecodeRequestError{Key: key, Err: err}
+			if !d.collectErrors {
+				return
+			}
+		}
+	}
+}
+
+// settableField returns the field of the struct v by name and whether
+// it can be set. The nil embedded structs it is promoted through are
+// allocated if alloc is true, and otherwise the field cannot be set.
+func settableField(v reflect.Value, name string, alloc bool) (reflect.Value, bool) {
+	sf, ok := v.Type().FieldByName(name)
+	if !ok {
+		return invalidValue, false
+	}
+	for _, i := range sf.Index[:len(sf.Index)-1] {
+		v = v.Field(i)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return invalidValue, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+	}
+	v = v.Field(sf.Index[len(sf.Index)-1])
+	return v, v.CanSet()
+}
+
+// override returns the overridden location of the alias.
+func (d *Decoder) override(alias string) (int, bool) {
+	for k, l := range d.overrides {
+		if strings.EqualFold(k, alias) {
+			return l, true
+		}
+	}
+	return locationNone, false
+}
+
+// parsePath parses the path checking it against the overridden locations
+// before the declared ones.
+// Keys from JSON bodies are matched exactly if set by CaseSensitiveJSON.
+func (d *Decoder) parsePath(p string, t reflect.Type, location int) ([]pathPart, error) {
+	exact := d.caseSensitiveJSON && location == LocationJSON
+	if len(d.overrides) > 0 {
+		if keys, err := d.separators.splitPath(p); err == nil {
+			if l, ok := d.override(keys[0]); ok {
+				if l != location {
+					return nil, LocationError{Key: p, AllowedLocations: []int{l}, Location: location}
+				}
+				return d.cache.parsePath(p, t, locationNone, d.separators, d.flatKeys, exact)
+			}
+		}
+	}
+	return d.cache.parsePath(p, t, location, d.separators, d.flatKeys, exact)
 }
 
-// NameFunc allows settings a special function for getting fields aliases
-func (d *Decoder) NameFunc(n func(field string, locations []int) string) {
-	d.cache.nameFunc = n
+// withOverrides returns a copy of info which also contains
+// the overridden locations.
+func (d *Decoder) withOverrides(info *structInfo) *structInfo {
+	if len(d.overrides) == 0 && len(d.disallowed) == 0 {
+		return info
+	}
+	i := *info
+	i.fieldsJSON = nil
+	for _, alias := range info.fieldsJSON {
+		if _, ok := d.override(alias); !ok {
+			i.fieldsJSON = append(i.fieldsJSON, alias)
+		}
+	}
+	for alias, l := range d.overrides {
+		f := info.get(alias)
+		if f == nil {
+			continue
+		}
+		i.setContains(l)
+		if l == LocationJSON {
+			i.fieldsJSON = append(i.fieldsJSON, f.alias)
+		}
+	}
+	for l := range d.disallowed {
+		switch l {
+		case LocationPath:
+			i.containsPath = false
+		case LocationQuery:
+			i.containsQuery = false
+		case LocationHeader:
+			i.containsHeader = false
+		case LocationCookie:
+			i.containsCookie = false
+		case LocationForm:
+			i.containsForm = false
+		case LocationFile:
+			i.containsFile = false
+		case LocationJSON:
+			i.containsJSON = false
+			i.fieldsJSON = nil
+		case LocationBody:
+			i.containsBody = false
+		}
+	}
+	return &i
 }
 
-// Separator defines runes to be used as separators.
-// If given '[', ']', '.' for example, then paths should be like a[b].[0].[c]
-// This is provided to make it possible to accept serialized objects from jQuery for example.
-// Both left and right can be set to 0 if only changing the separator
-func (d *Decoder) Separator(left rune, right rune, sep rune) {
-	d.cache.sepLeft = left
-	d.cache.sepRight = right
-	d.cache.sep = sep
+// DryRun checks whether a *http.Request can be decoded to a struct
+// without modifying the struct.
+//
+// It returns the same errors Decode would return. Uploaded files are not
+// opened, and the body of the request is restored afterwards, so the
+// request can still be decoded, unless reading it timed out.
+func (d *Decoder) DryRun(dst interface{}, r *http.Request) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("interface must be a pointer to struct")
+	}
+	c := *d
+	c.dryRun = true
+	// Nothing is set, so no field is reported to OnField.
+	c.onField = nil
+	if body := r.Body; body != nil && body != http.NoBody {
+		// The body read while decoding is kept to be read again,
+		// and the body is not closed, like by decompressing it.
+		read := &bytes.Buffer{}
+		tee := ioutil.NopCloser(io.TeeReader(body, read))
+		r.Body = tee
+		defer func() {
+			// The body is not restored if a read of it timed out
+			// and was abandoned, as it is still being read.
+			if r.Body == tee {
+				r.Body = peekedBody{Reader: io.MultiReader(read, body), Closer: body}
+			}
+		}()
+	}
+	return c.Decode(reflect.New(v.Elem().Type()).Interface(), r)
 }
 
-// RegisterConverter registers a converter function for a custom type.
-func (d *Decoder) RegisterConverter(value interface{}, converterFunc Converter) {
-	d.cache.registerConverter(value, converterFunc)
+// RequestDecoder is implemented by types that decode themselves
+// from a request.
+//
+// If the struct passed to Decode implements it then the decoding is
+// delegated to it entirely. Struct fields implementing it are decoded by it
+// after the other fields are decoded.
+type RequestDecoder interface {
+	DecodeRequest(r *http.Request) error
 }
 
-// CollectErrors specifies whether to return on the first error or accumulate errors.
-func (d *Decoder) CollectErrors(c bool) {
-	d.collectErrors = c
+var requestDecoderType = reflect.TypeOf((*RequestDecoder)(nil)).Elem()
+
+func implementsRequestDecoder(t reflect.Type) bool {
+	return t.Implements(requestDecoderType) || reflect.PtrTo(t).Implements(requestDecoderType)
 }
 
-// Decode decodes a *http.Request to a struct.
-//
-// The first parameter must be a pointer to a struct.
-// The second parameter is a pointer to http.Request.
-func (d *Decoder) Decode(dst interface{}, r *http.Request) error {
+func (d *Decoder) decodeRequest(dst interface{}, r *http.Request, pathParams map[string]string) (err error) {
+	// The body is replaced to limit, time and decompress it,
+	// which must not change the request of the caller.
+	body := r.Body
+	defer func() { r.Body = body }()
+	// The deadline wraps the body of the caller first, as the body
+	// under it is read by goroutines, and the readers wrapping it
+	// are not safe for concurrent use.
+	if d.readTimeout > 0 && r.Body != nil && r.Body != http.NoBody {
+		b := d.timeBody(r)
+		defer func() {
+			if b.stop() {
+				body = expiredBody{timeout: d.readTimeout}
+			}
+			if b.expired {
+				err = TimeoutError{Timeout: d.readTimeout}
+			}
+		}()
+	}
+	var limited []*limitedBody
+	if d.maxBodySize > 0 && r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > d.maxBodySize {
+			return BodyTooLargeError{Limit: d.maxBodySize}
+		}
+		limited = append(limited, d.limitBody(r))
+		defer func() {
+			// The errors of reading a body over the limit
+			// are reported as such whatever they were wrapped in.
+			for _, b := range limited {
+				if b.remaining < 0 {
+					err = BodyTooLargeError{Limit: d.maxBodySize}
+				}
+			}
+		}()
+	}
+	if rd, ok := dst.(RequestDecoder); ok {
+		if err := rd.DecodeRequest(r); err != nil {
+			return DecodeRequestError{Err: err}
+		}
+		return nil
+	}
 	v := reflect.ValueOf(dst)
 	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
 		return errors.New("interface must be a pointer to struct")
 	}
 	v = v.Elem()
 	t := v.Type()
-	var err error
 	errors := MultiError{}
 	lens := map[reflect.Value]map[int]int{}
 	ps := map[string][]pathPart{}
-	info := d.cache.get(t)
+	if len(d.disallowed) > 0 {
+		if err = d.cache.disallowedError(t, func() error {
+			return d.checkDisallowed(t, nil, nil, map[reflect.Type]bool{})
+		}); err != nil {
+			return err
+		}
+	}
+	info := d.withOverrides(d.cache.get(t))
+	for _, name := range info.skipped {
+		d.logf("reqtruct: skipping field %s of %v with an unsupported type", name, t)
+	}
+	if info.containsBody {
+		if err = d.readBody(info, v, r, errors); err != nil {
+			return err
+		}
+		if !d.collectErrors && len(errors) > 0 {
+			return errors
+		}
+	}
 	var fs map[string][]*multipart.FileHeader
-	if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
-		if !info.containsPath && !info.containsQuery && !info.containsHeader && !info.containsFile && !info.containsForm && info.containsJSON {
-			if err = json.NewDecoder(r.Body).Decode(dst); err != nil {
+	if (r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH") && !d.skipBody(r) {
+		if d.requireContentTypeHeader && r.Header.Get("Content-Type") == "" && (info.containsJSON || info.containsForm || info.containsFile) && !isEmptyBody(r) {
+			return ContentTypeError{ContentType: bodyContentType(info)}
+		}
+		if d.decompressBodies && r.Body != nil {
+			if err = decompressBody(r); err != nil {
+				return err
+			}
+			defer r.Body.Close()
+			if len(limited) > 0 {
+				limited = append(limited, d.limitBody(r))
+			}
+		}
+		if _, custom := d.bodyCodec(r); !custom && d.decodesJSONDirectly(info) {
+			if d.requireContentType && !isJSON(r) {
+				return ContentTypeError{RequestContentType: r.Header.Get("Content-Type"), ContentType: "application/json"}
+			}
+			d.logf("reqtruct: decoding json body to %v with encoding/json", t)
+			body, err := jsonObject(r.Body)
+			if err != nil {
+				return err
+			}
+			if err = json.NewDecoder(body).Decode(dst); err != nil {
 				return ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
 			}
 			return nil
+		} else if custom {
+			d.logf("reqtruct: not decoding json body of %v with encoding/json because of a body codec", t)
+		} else if info.containsJSON {
+			d.logf("reqtruct: not decoding json body of %v with encoding/json because of fields in other locations or with options", t)
 		}
 		if info.containsFile {
-			if !isMultipartForm(r) {
-				return ContentTypeError{RequestContentType: r.Header.Get("Content-Type"), ContentType: "multipart/form-data"}
-			}
 			err = r.ParseMultipartForm(d.maxMemory)
 			if err != nil {
-				return ParsingError{Err: fmt.Errorf("cannot parse multipart form"), WrappedErr: err}
+				return multipartError(r, err)
 			}
 			fs = r.MultipartForm.File
+			if err = d.checkLimits(fs); err != nil {
+				return err
+			}
+			if info.filesMap != nil {
+				setFilesMap(v, info.filesMap, fs)
+			}
 			d.checkFiles(fs, t, ps, errors)
 			if !d.collectErrors && len(errors) > 0 {
 				return errors
@@ -135,24 +1351,603 @@ func (d *Decoder) Decode(dst interface{}, r *http.Request) error {
 		}
 	}
 
-	m, err := d.extractMap(info, t, r, ps, errors)
+	from := map[string]keySource{}
+	var rest map[string]interface{}
+	if info.remainder != nil {
+		rest = map[string]interface{}{}
+	}
+	m, err := d.extractMap(info, t, v, r, pathParams, ps, from, rest, errors)
 	if err != nil {
 		return err
 	}
 	if !d.collectErrors && len(errors) > 0 {
 		return errors
 	}
-	d.decodeMaps(t, v, m, fs, ps, lens, errors)
+	if info.containsMethods {
+		d.filterMethods(r.Method, m, fs, ps, errors)
+		if !d.collectErrors && len(errors) > 0 {
+			return errors
+		}
+	}
+	d.decodeMaps(t, v, m, fs, ps, from, lens, map[walkKey]reflect.Value{}, errors)
+	if !d.collectErrors && len(errors) > 0 {
+		return errors
+	}
+	if len(rest) > 0 {
+		setRemainder(v, info.remainder, rest)
+	}
+	if info.containsRequiredIf {
+		d.checkRequiredIf(info, v, ps, errors)
+		if !d.collectErrors && len(errors) > 0 {
+			return errors
+		}
+	}
+	if len(info.groups) > 0 {
+		d.checkGroups(info, ps, errors)
+		if !d.collectErrors && len(errors) > 0 {
+			return errors
+		}
+	}
+	d.decodeRequestDecoders(info, v, r, nil, errors)
 	if len(errors) > 0 {
 		return errors
 	}
 	return nil
 }
 
+// setFilesMap sets the files map field of v to a copy of fs.
+func setFilesMap(v reflect.Value, field *fieldInfo, fs map[string][]*multipart.FileHeader) {
+	m := reflect.MakeMapWithSize(field.typ, len(fs))
+	for k, files := range fs {
+		m.SetMapIndex(reflect.ValueOf(k).Convert(field.typ.Key()), reflect.ValueOf(files).Convert(field.typ.Elem()))
+	}
+	if fv, ok := settableField(v, field.name, true); ok {
+		fv.Set(m)
+	}
+}
+
+// setRemainder sets the remainder field of v to the unknown keys in rest.
+func setRemainder(v reflect.Value, field *fieldInfo, rest map[string]interface{}) {
+	if fv, ok := settableField(v, field.name, true); ok {
+		fv.Set(reflect.ValueOf(rest).Convert(field.typ))
+	}
+}
+
+// decodesJSONDirectly reports whether JSON bodies can be decoded to the
+// struct with encoding/json, which is when all its fields are JSON fields
+// and neither they nor the decoder need more than encoding/json does.
+func (d *Decoder) decodesJSONDirectly(info *structInfo) bool {
+	return info.containsJSON && onlyJSONFields(info) && !fieldsNeedDecoding(info) && !d.handlesFields()
+}
+
+// onlyJSONFields reports whether the struct has no fields sourced from
+// locations other than JSON bodies.
+func onlyJSONFields(info *structInfo) bool {
+	return !info.containsPath && !info.containsQuery && !info.containsHeader && !info.containsCookie &&
+		!info.containsFile && !info.containsForm && !info.containsRequest && !info.containsBody
+}
+
+// fieldsNeedDecoding reports whether the fields of the struct have tags
+// or types which encoding/json does not know about.
+func fieldsNeedDecoding(info *structInfo) bool {
+	// Unknown keys are kept and methods and conditions checked per field.
+	if info.remainder != nil || info.containsMethods || info.containsRequiredIf || info.containsEnum || len(info.groups) > 0 {
+		return true
+	}
+	// Values are converted differently than by encoding/json.
+	return info.containsTimeFormat || info.containsFromStringer || info.containsBinaryUnmarshaler ||
+		info.containsSkipped || info.containsComplex
+}
+
+// handlesFields reports whether the decoder has options applied to each
+// field, which encoding/json would bypass. New options that act on fields
+// or their keys must be added here.
+func (d *Decoder) handlesFields() bool {
+	// Fields are masked, allocated or reported one by one.
+	if d.mask != nil || d.allocator != nil || d.onField != nil {
+		return true
+	}
+	// Keys are matched and values limited differently than by encoding/json.
+	return d.maxSliceLen != 0 || d.cache.keyNormalizer != nil || d.caseSensitiveJSON
+}
+
+// sentFields returns the paths of the fields which params were sent for,
+// as their field names joined by dots, with the indices of the elements
+// of slices of structs, like "Items.0.Name". The paths of their parents
+// are included, so the names of the fields of the top level struct are too.
+func sentFields(ps map[string][]pathPart) map[string]bool {
+	sent := map[string]bool{}
+	for _, parts := range ps {
+		var names []string
+		for i, p := range parts {
+			for _, name := range p.path {
+				names = append(names, name)
+				sent[strings.Join(names, ".")] = true
+			}
+			if i < len(parts)-1 {
+				names = append(names, strconv.Itoa(p.index))
+			}
+		}
+	}
+	return sent
+}
+
+// checkRequiredIf checks the fields of the struct with a requiredif tag
+// against the decoded values of the fields in their conditions, walking
+// into nested structs, whose conditions refer to their own fields.
+// A field is missing if no param was sent for it and it has the zero value.
+func (d *Decoder) checkRequiredIf(info *structInfo, v reflect.Value, ps map[string][]pathPart, errors MultiError) {
+	d.checkNestedRequiredIf(info, v, nil, nil, sentFields(ps), errors)
+}
+
+// checkNestedRequiredIf checks the requiredif tags of the struct v at the
+// given path of field names, whose keys are prefixed by the aliases in keys.
+// It reports whether to continue checking.
+func (d *Decoder) checkNestedRequiredIf(info *structInfo, v reflect.Value, path []string, keys []string, sent map[string]bool, errors MultiError) bool {
+	for _, f := range info.fields {
+		fv, ok := settableField(v, f.name, false)
+		if !ok {
+			continue
+		}
+		fpath := append(path[:len(path):len(path)], f.name)
+		fkeys := append(keys[:len(keys):len(keys)], f.alias)
+		if f.requiredIf != nil && !sent[strings.Join(fpath, ".")] && fv.IsZero() && d.conditionMet(info, v, f.requiredIf) {
+			key := d.separators.joinPath(fkeys)
+			errors[key] = MissingKeyError{Key: key, Condition: f.requiredIf.String()}
+			if !d.collectErrors {
+				return false
+			}
+		}
+		ft := underlyingElem(f.typ)
+		if f.isAnonymous || ft.Kind() != reflect.Struct || isFileType(ft) || d.cache.converter(ft) != nil {
+			continue
+		}
+		ni := d.cache.get(ft)
+		if !ni.containsRequiredIf {
+			continue
+		}
+		fv = reflect.Indirect(fv)
+		switch fv.Kind() {
+		case reflect.Struct:
+			if !d.checkNestedRequiredIf(ni, fv, fpath, fkeys, sent, errors) {
+				return false
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < fv.Len(); i++ {
+				index := strconv.Itoa(i)
+				if !d.checkNestedRequiredIf(ni, reflect.Indirect(fv.Index(i)), append(fpath, index), append(fkeys, index), sent, errors) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// conditionMet reports whether the field of the struct v in the condition
+// has its value, converted to the type of the field like a param would be.
+func (d *Decoder) conditionMet(info *structInfo, v reflect.Value, c *condition) bool {
+	f := info.get(c.alias)
+	if f == nil {
+		return false
+	}
+	fv, ok := settableField(v, f.name, false)
+	if !ok {
+		return false
+	}
+	fv = reflect.Indirect(fv)
+	if !fv.IsValid() {
+		return false
+	}
+	want, ok := d.conditionValue(f, fv.Type(), c.value)
+	return ok && reflect.DeepEqual(fv.Interface(), want.Interface())
+}
+
+// conditionValue converts the value of a condition to the type t
+// of its field.
+func (d *Decoder) conditionValue(f *fieldInfo, t reflect.Type, value string) (reflect.Value, bool) {
+	if conv := d.cache.converter(t); conv != nil {
+		v, err := conv(value, f.structField)
+		if err != nil || !v.IsValid() {
+			return invalidValue, false
+		}
+		return v.Convert(t), true
+	}
+	p := reflect.New(t)
+	if u, ok := p.Interface().(encoding.TextUnmarshaler); ok {
+		return p.Elem(), u.UnmarshalText([]byte(value)) == nil
+	}
+	if conv := builtinConverters[t.Kind()]; conv != nil {
+		if v := conv(value); v.IsValid() {
+			return v.Convert(t), true
+		}
+	}
+	return invalidValue, false
+}
+
+// checkGroups checks that at most one of the fields of each group of
+// mutually exclusive fields was sent, and exactly one for required groups.
+// The errors are keyed by the names of the groups.
+// Only the fields of the top level struct are checked.
+func (d *Decoder) checkGroups(info *structInfo, ps map[string][]pathPart, errors MultiError) {
+	sent := sentFields(ps)
+	for _, g := range info.groups {
+		keys := make([]string, 0, len(g.fields))
+		var present []string
+		for _, f := range g.fields {
+			keys = append(keys, f.alias)
+			if sent[f.name] {
+				present = append(present, f.alias)
+			}
+		}
+		if len(present) > 1 || (len(present) == 0 && g.required) {
+			errors[g.name] = ExclusiveGroupError{Group: g.name, Keys: keys, PresentKeys: present}
+			if !d.collectErrors {
+				return
+			}
+		}
+	}
+}
+
 func isMultipartForm(r *http.Request) bool {
 	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
 }
 
+// checkLimits checks the uploaded files against the limits of the decoder.
+func (d *Decoder) checkLimits(fs map[string][]*multipart.FileHeader) error {
+	var count int
+	var size int64
+	for _, files := range fs {
+		count += len(files)
+		for _, f := range files {
+			size += f.Size
+		}
+	}
+	if d.maxFiles > 0 && count > d.maxFiles {
+		return LimitExceededError{Name: "max files", Limit: int64(d.maxFiles)}
+	}
+	if d.maxTotalSize > 0 && size > d.maxTotalSize {
+		return LimitExceededError{Name: "max total size", Limit: d.maxTotalSize}
+	}
+	return nil
+}
+
+// readBody sets the fields reading the raw body, and replaces the body
+// of the request with a copy so it can still be decoded to the other fields.
+func (d *Decoder) readBody(info *structInfo, v reflect.Value, r *http.Request, errors MultiError) error {
+	var b []byte
+	if r.Body != nil {
+		var err error
+		if b, err = ioutil.ReadAll(r.Body); err != nil {
+			return ParsingError{Err: fmt.Errorf("cannot read body"), WrappedErr: err}
+		}
+		r.Body = peekedBody{Reader: bytes.NewReader(b), Closer: r.Body}
+	}
+	for _, f := range info.fields {
+		if f.alias == "" || f.canonicalAlias != f.alias {
+			continue
+		}
+		locations := f.locations
+		if l, ok := d.override(f.alias); ok {
+			locations = []int{l}
+		}
+		if !containsInt(locations, LocationBody) || d.masked(f.alias, false) {
+			continue
+		}
+		fv, ok := settableField(v, f.name, true)
+		if !ok {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			fv.Set(d.alloc(fv.Type().Elem()))
+			fv = fv.Elem()
+		}
+		switch {
+		case fv.Kind() == reflect.String:
+			fv.SetString(string(b))
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8:
+			fv.SetBytes(append([]byte{}, b...))
+		default:
+			errors[f.alias] = ConversionError{Key: f.alias, Type: fv.Type(), Index: -1}
+			if !d.collectErrors {
+				return nil
+			}
+			continue
+		}
+		if d.onField != nil {
+			d.onField(f.alias, LocationBody, fv)
+		}
+	}
+	return nil
+}
+
+// skipBody reports whether the body of the request is empty
+// and empty bodies are allowed.
+func (d *Decoder) skipBody(r *http.Request) bool {
+	return d.allowEmptyBody && isEmptyBody(r)
+}
+
+// isEmptyBody reports whether the request has no body.
+// The body is peeked if needed, and replaced by http.NoBody if it is empty.
+func isEmptyBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > 0 {
+		return false
+	}
+	br := bufio.NewReader(r.Body)
+	if _, err := br.Peek(1); err == io.EOF {
+		r.Body.Close()
+		r.Body = http.NoBody
+		return true
+	}
+	r.Body = peekedBody{Reader: br, Closer: r.Body}
+	return false
+}
+
+// peekedBody reads a body after some of it was peeked.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// limitBody replaces the body of the request with a reader failing
+// after the max body size.
+func (d *Decoder) limitBody(r *http.Request) *limitedBody {
+	b := &limitedBody{ReadCloser: r.Body, remaining: d.maxBodySize}
+	r.Body = b
+	return b
+}
+
+// limitedBody reads a body up to a limit. Remaining is negative
+// once the body is found to be over the limit.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.remaining -= int64(n); b.remaining < 0 {
+		return n - 1, errBodyTooLarge
+	}
+	return n, err
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// timeBody replaces the body of the request with a reader failing
+// once the read timeout is exceeded. The returned body must be stopped
+// when done with it.
+func (d *Decoder) timeBody(r *http.Request) *deadlineBody {
+	b := newDeadlineBody(r.Body, d.readTimeout)
+	r.Body = b
+	return b
+}
+
+// deadlineBody reads a body until a deadline. Each read of the body is
+// done by a goroutine of its own, so a read blocked past the deadline is
+// abandoned to the goroutine while the read of the caller returns.
+// Expired is set once a read returned because of the deadline.
+type deadlineBody struct {
+	io.ReadCloser
+	timer     *time.Timer
+	buf       []byte
+	expired   bool
+	abandoned chan struct{}
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newDeadlineBody(body io.ReadCloser, timeout time.Duration) *deadlineBody {
+	return &deadlineBody{
+		ReadCloser: body,
+		timer:      time.NewTimer(timeout),
+	}
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	if b.expired {
+		return 0, errReadTimeout
+	}
+	select {
+	case <-b.timer.C:
+		b.expired = true
+		return 0, errReadTimeout
+	default:
+	}
+	if cap(b.buf) < len(p) {
+		b.buf = make([]byte, len(p))
+	}
+	buf := b.buf[:len(p)]
+	done := make(chan readResult)
+	abandoned := make(chan struct{})
+	go func() {
+		n, err := b.ReadCloser.Read(buf)
+		select {
+		case done <- readResult{n: n, err: err}:
+		case <-abandoned:
+			// Nobody else can read the body once a read of it
+			// was abandoned, as the bytes read are lost.
+			b.ReadCloser.Close()
+		}
+	}()
+	select {
+	case res := <-done:
+		return copy(p, buf[:res.n]), res.err
+	case <-b.timer.C:
+		b.expired = true
+		b.abandoned = abandoned
+		close(abandoned)
+		return 0, errReadTimeout
+	}
+}
+
+// Close closes the body, unless a read of it was abandoned,
+// in which case the body is closed once that read returns.
+func (b *deadlineBody) Close() error {
+	if b.abandoned != nil {
+		return nil
+	}
+	return b.ReadCloser.Close()
+}
+
+// stop stops the deadline. It reports whether a read of the body was
+// abandoned, in which case the body must not be read again.
+func (b *deadlineBody) stop() bool {
+	b.timer.Stop()
+	return b.abandoned != nil
+}
+
+// expiredBody replaces a body which a read of was abandoned
+// because of the read timeout.
+type expiredBody struct {
+	timeout time.Duration
+}
+
+func (b expiredBody) Read(p []byte) (int, error) {
+	return 0, TimeoutError{Timeout: b.timeout}
+}
+
+func (b expiredBody) Close() error {
+	return nil
+}
+
+var errReadTimeout = errors.New("request body read timeout")
+
+// decompressBody replaces the body of the request with a reader
+// decompressing it according to the Content-Encoding header.
+func decompressBody(r *http.Request) error {
+	var rc io.ReadCloser
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return ParsingError{Err: fmt.Errorf("cannot decompress gzip body"), WrappedErr: err}
+		}
+		rc = zr
+	case "deflate":
+		rc = flate.NewReader(r.Body)
+	default:
+		return nil
+	}
+	r.Body = decompressedBody{ReadCloser: rc, body: r.Body}
+	return nil
+}
+
+// decompressedBody closes both the decompressing reader and the original body.
+type decompressedBody struct {
+	io.ReadCloser
+	body io.ReadCloser
+}
+
+func (b decompressedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
+
+// BodyCodec decodes a request body to a map of values.
+// Nested objects must be decoded to map[string]interface{}
+// and arrays to []interface{}.
+type BodyCodec func(body io.Reader) (map[string]interface{}, error)
+
+// jsonCodec is the builtin codec for JSON bodies.
+func jsonCodec(body io.Reader) (map[string]interface{}, error) {
+	body, err := jsonObject(body)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
+	}
+	return m, nil
+}
+
+// jsonObject returns a reader with the same content as the body
+// after checking that the body is a JSON object.
+// Invalid JSON is left for the JSON decoder to report.
+func jsonObject(body io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(body)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return br, nil
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		br.UnreadByte()
+		if got := jsonKind(b); got != "" && got != "object" {
+			return nil, JSONShapeError{Expected: "object", Got: got}
+		}
+		return br, nil
+	}
+}
+
+// jsonKind returns the kind of the JSON value starting with b.
+func jsonKind(b byte) string {
+	switch {
+	case b == '{':
+		return "object"
+	case b == '[':
+		return "array"
+	case b == '"':
+		return "string"
+	case b == 't' || b == 'f':
+		return "boolean"
+	case b == 'n':
+		return "null"
+	case b == '-' || (b >= '0' && b <= '9'):
+		return "number"
+	}
+	return ""
+}
+
+// multipartError returns a typed error for an error returned by ParseMultipartForm.
+// Bodies ending before the closing boundary are malformed.
+func multipartError(r *http.Request, err error) error {
+	switch {
+	case errors.Is(err, http.ErrNotMultipart):
+		return NotMultipartError{RequestContentType: r.Header.Get("Content-Type")}
+	case errors.Is(err, http.ErrMissingBoundary), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return MalformedMultipartError{Err: err}
+	}
+	return ParsingError{Err: fmt.Errorf("cannot parse multipart form"), WrappedErr: err}
+}
+
+// bodyContentType returns the Content-Type of the bodies
+// expected by the struct.
+func bodyContentType(info *structInfo) string {
+	if info.containsFile {
+		return "multipart/form-data"
+	}
+	if info.containsForm {
+		return "application/x-www-form-urlencoded"
+	}
+	return "application/json"
+}
+
+// isJSON reports whether the media type of the request is "application/json",
+// which is compared case-insensitively and regardless of its parameters.
+func isJSON(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
+
 func isURLEncodedForm(r *http.Request) bool {
 	return r.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
 }
@@ -191,6 +1986,57 @@ func isTextUnmarshaler(v reflect.Value) unmarshaler {
 	return m
 }
 
+// FromStringer is implemented by named scalar types which decode
+// and validate themselves from string values, like a Celsius type
+// rejecting values below absolute zero.
+//
+// It is used for single fields, after registered converters and
+// encoding.TextUnmarshaler, and instead of the converters for kinds.
+type FromStringer interface {
+	FromString(value string) error
+}
+
+var fromStringerType = reflect.TypeOf((*FromStringer)(nil)).Elem()
+
+// DefaultLocator is implemented by structs setting the location of their
+// fields without location tags, instead of the default location of the decoder.
+// Like a location tag, it also applies to the fields of its nested structs
+// which set no location of their own.
+// It is called on the zero value of the struct, so it must return a constant.
+//
+//	func (CreateUserRequest) DefaultLocation() int { return reqtruct.LocationForm }
+type DefaultLocator interface {
+	DefaultLocation() int
+}
+
+var defaultLocatorType = reflect.TypeOf((*DefaultLocator)(nil)).Elem()
+
+// structDefaultLocation returns the default location
+// of the struct type t if it implements DefaultLocator.
+func structDefaultLocation(t reflect.Type) (int, bool) {
+	if !reflect.PtrTo(t).Implements(defaultLocatorType) {
+		return locationNone, false
+	}
+	l := reflect.New(t).Interface().(DefaultLocator).DefaultLocation()
+	return l, locationToName(l) != ""
+}
+
+func implementsFromStringer(t reflect.Type) bool {
+	return t.Implements(fromStringerType) || reflect.PtrTo(t).Implements(fromStringerType)
+}
+
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+func implementsBinaryUnmarshaler(t reflect.Type) bool {
+	return t.Implements(binaryUnmarshalerType) || reflect.PtrTo(t).Implements(binaryUnmarshalerType)
+}
+
+// elemUnmarshaler returns the encoding.TextUnmarshaler information
+// of the elements of the slice or array type t.
+func elemUnmarshaler(t reflect.Type) unmarshaler {
+	return isTextUnmarshaler(reflect.Zero(reflect.SliceOf(t.Elem())))
+}
+
 type unmarshaler struct {
 	Unmarshaler       encoding.TextUnmarshaler
 	IsValid           bool
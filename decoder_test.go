@@ -0,0 +1,2518 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newRequest returns a request with the body and its Content-Type,
+// which is not set if empty.
+func newRequest(method string, target string, contentType string, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+// newMultipartBody returns a multipart form body with the values and files,
+// given by field name and file name to content, and its Content-Type.
+func newMultipartBody(values map[string][]string, files map[string]map[string]string) (string, string) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	for k, vs := range values {
+		for _, v := range vs {
+			w.WriteField(k, v)
+		}
+	}
+	for k, fs := range files {
+		for name, content := range fs {
+			fw, _ := w.CreateFormFile(k, name)
+			io.WriteString(fw, content)
+		}
+	}
+	w.Close()
+	return b.String(), w.FormDataContentType()
+}
+
+// keyError returns the error of the key in the MultiError err,
+// or err itself if it is not a MultiError.
+func keyError(err error, key string) error {
+	if e, ok := err.(MultiError); ok {
+		return e[key]
+	}
+	return err
+}
+
+func TestParallelArrays(t *testing.T) {
+	type user struct {
+		Name string `form:"name"`
+		Age  int    `form:"age"`
+	}
+	type request struct {
+		Users []user `form:"users"`
+	}
+	tests := []struct {
+		name string
+		body string
+		want []user
+		key  string
+	}{
+		{
+			name: "parallel",
+			body: "users.name=a&users.name=b&users.age=1&users.age=2",
+			want: []user{{Name: "a", Age: 1}, {Name: "b", Age: 2}},
+		},
+		{
+			name: "indexed",
+			body: "users.0.name=a&users.1.name=b&users.0.age=1&users.1.age=2",
+			want: []user{{Name: "a", Age: 1}, {Name: "b", Age: 2}},
+		},
+		{
+			name: "single",
+			body: "users.name=a",
+			want: []user{{Name: "a"}},
+		},
+		{
+			name: "mismatched lengths",
+			body: "users.name=a&users.name=b&users.age=1",
+			key:  "users.name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.ParallelArrays(true)
+			var dst request
+			err := d.Decode(&dst, newRequest("POST", "/", "application/x-www-form-urlencoded", tt.body))
+			if tt.key != "" {
+				e, ok := keyError(err, tt.key).(ParallelArraysLengthError)
+				if !ok {
+					t.Fatalf("got error %v, want a ParallelArraysLengthError for %q", err, tt.key)
+				}
+				if e.Length != 2 || e.Expected != 1 {
+					t.Errorf("got length %d and expected %d, want 2 and 1", e.Length, e.Expected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst.Users, tt.want) {
+				t.Errorf("got %+v, want %+v", dst.Users, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocationPrecedence(t *testing.T) {
+	type request struct {
+		Token string   `name:"token" from:"header,query"`
+		Tags  []string `name:"tags" from:"header,query"`
+		Name  string   `name:"name" from:"query,json"`
+		IDs   []int    `name:"ids" from:"query,json"`
+	}
+	tests := []struct {
+		name       string
+		precedence []int
+		target     string
+		header     map[string]string
+		body       string
+		want       request
+	}{
+		{
+			name:   "header over query",
+			target: "/?token=q&tags=q",
+			header: map[string]string{"Token": "h", "Tags": "h"},
+			want:   request{Token: "h", Tags: []string{"h", "q"}},
+		},
+		{
+			name:   "query only",
+			target: "/?token=q",
+			want:   request{Token: "q"},
+		},
+		{
+			name:   "header only",
+			target: "/",
+			header: map[string]string{"Token": "h"},
+			want:   request{Token: "h"},
+		},
+		{
+			name:       "query over header",
+			precedence: []int{LocationQuery},
+			target:     "/?token=q&tags=q",
+			header:     map[string]string{"Token": "h", "Tags": "h"},
+			want:       request{Token: "q", Tags: []string{"q", "h"}},
+		},
+		{
+			name:   "query over json",
+			target: "/?name=q&ids=1",
+			body:   `{"name":"j","ids":[2]}`,
+			want:   request{Name: "q", IDs: []int{1, 2}},
+		},
+		{
+			name:       "json over query",
+			precedence: []int{LocationJSON},
+			target:     "/?name=q&ids=1",
+			body:       `{"name":"j","ids":[2]}`,
+			want:       request{Name: "j", IDs: []int{2, 1}},
+		},
+		{
+			name:       "unknown and repeated locations",
+			precedence: []int{-1, LocationJSON, LocationJSON, LocationHeader},
+			target:     "/?name=q&token=q",
+			header:     map[string]string{"Token": "h"},
+			body:       `{"name":"j"}`,
+			want:       request{Token: "h", Name: "j"},
+		},
+		{
+			name:       "missing locations in default order",
+			precedence: []int{LocationJSON},
+			target:     "/?token=q",
+			header:     map[string]string{"Token": "h"},
+			want:       request{Token: "h"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			if tt.precedence != nil {
+				d.LocationPrecedence(tt.precedence)
+			}
+			body := tt.body
+			if body == "" {
+				body = "{}"
+			}
+			r := newRequest("POST", tt.target, "application/json", body)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			var dst request
+			if err := d.Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipartErrors(t *testing.T) {
+	type request struct {
+		Name string                `form:"name"`
+		File *multipart.FileHeader `file:"file"`
+	}
+	body, contentType := newMultipartBody(map[string][]string{"name": {"a"}}, map[string]map[string]string{"file": {"a.txt": "abc"}})
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		check       func(err error) bool
+	}{
+		{
+			name:        "valid",
+			contentType: contentType,
+			body:        body,
+			check:       func(err error) bool { return err == nil },
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+			check: func(err error) bool {
+				e, ok := err.(NotMultipartError)
+				return ok && e.RequestContentType == "application/json"
+			},
+		},
+		{
+			name:  "missing content type",
+			body:  body,
+			check: func(err error) bool { _, ok := err.(NotMultipartError); return ok },
+		},
+		{
+			name:        "missing boundary",
+			contentType: "multipart/form-data",
+			body:        body,
+			check: func(err error) bool {
+				_, ok := err.(MalformedMultipartError)
+				return ok && errors.Is(err, http.ErrMissingBoundary)
+			},
+		},
+		{
+			name:        "truncated",
+			contentType: contentType,
+			body:        body[:len(body)/2],
+			check:       func(err error) bool { _, ok := err.(MalformedMultipartError); return ok },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("POST", "/", tt.contentType, tt.body))
+			if !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	type jsonRequest struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	type fileRequest struct {
+		Name string         `form:"name"`
+		File multipart.File `file:"file"`
+	}
+	body, contentType := newMultipartBody(map[string][]string{"name": {"a"}}, map[string]map[string]string{"file": {"a.txt": "abc"}})
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		dst         func() interface{}
+		valid       bool
+	}{
+		{name: "json", contentType: "application/json", body: `{"name":"a","age":1}`, dst: func() interface{} { return &jsonRequest{} }, valid: true},
+		{name: "invalid json", contentType: "application/json", body: `{"name":"a","age":"x"}`, dst: func() interface{} { return &jsonRequest{} }},
+		{name: "multipart", contentType: contentType, body: body, dst: func() interface{} { return &fileRequest{} }, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			r := newRequest("POST", "/", tt.contentType, tt.body)
+			dst := tt.dst()
+			dryErr := d.DryRun(dst, r)
+			if (dryErr == nil) != tt.valid {
+				t.Fatalf("got error %v from the dry run", dryErr)
+			}
+			if !reflect.DeepEqual(dst, tt.dst()) {
+				t.Errorf("dry run modified the struct: %+v", dst)
+			}
+			err := d.Decode(dst, r)
+			if (err == nil) != (dryErr == nil) {
+				t.Fatalf("got error %v from Decode after the dry run, want %v", err, dryErr)
+			}
+			if f, ok := dst.(*fileRequest); ok && tt.valid {
+				defer f.File.Close()
+				if b, _ := ioutil.ReadAll(f.File); string(b) != "abc" {
+					t.Errorf("got file %q, want abc", b)
+				}
+			}
+		})
+	}
+}
+
+func TestDryRunBody(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+	}
+	d := NewDecoder()
+	r := newRequest("POST", "/", "application/json", `{"name":"a"}`)
+	if err := d.DryRun(&request{}, r); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"name":"a"}` {
+		t.Errorf("got body %q after the dry run", b)
+	}
+}
+
+func TestDecodeCompressedBodies(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	const body = `{"name":"a","age":1}`
+	compress := func(encoding string) string {
+		var b bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&b)
+		case "deflate":
+			w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+		default:
+			return body
+		}
+		io.WriteString(w, body)
+		w.Close()
+		return b.String()
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		invalid  bool
+	}{
+		{name: "gzip", encoding: "gzip", body: compress("gzip")},
+		{name: "x-gzip", encoding: "x-gzip", body: compress("gzip")},
+		{name: "deflate", encoding: "deflate", body: compress("deflate")},
+		{name: "identity", body: compress("")},
+		{name: "invalid gzip", encoding: "gzip", body: body, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.DecodeCompressedBodies(true)
+			r := newRequest("POST", "/", "application/json", tt.body)
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			var dst request
+			err := d.Decode(&dst, r)
+			if tt.invalid {
+				if _, ok := err.(ParsingError); !ok {
+					t.Errorf("got error %v, want a ParsingError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst != (request{Name: "a", Age: 1}) {
+				t.Errorf("got %+v", dst)
+			}
+		})
+	}
+}
+
+func TestOnField(t *testing.T) {
+	type inner struct {
+		Value string `query:"value"`
+	}
+	type request struct {
+		Name  string   `query:"name"`
+		Age   *int     `query:"age"`
+		Inner *inner   `query:"inner"`
+		Tags  []string `query:"tags"`
+	}
+	tests := []struct {
+		name      string
+		zeroEmpty bool
+		lazyNil   bool
+		dryRun    bool
+		target    string
+		want      []string
+	}{
+		{name: "set", target: "/?name=a&tags=x", want: []string{"name", "tags"}},
+		{name: "empty", target: "/?name=", want: nil},
+		{name: "empty zeroed", zeroEmpty: true, target: "/?name=", want: []string{"name"}},
+		{name: "empty pointer", target: "/?age=", want: nil},
+		{name: "lazy kept", lazyNil: true, target: "/?inner.value=a", want: []string{"inner.value"}},
+		{name: "lazy dropped", lazyNil: true, target: "/?inner.value=", want: nil},
+		{name: "not lazy", target: "/?inner.value=a", want: []string{"inner.value"}},
+		{name: "dry run", dryRun: true, target: "/?name=a&tags=x", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.ZeroEmpty(tt.zeroEmpty)
+			d.LazyNil(tt.lazyNil)
+			var got []string
+			var dst request
+			d.OnField(func(key string, location int, value reflect.Value) {
+				if location != LocationQuery {
+					t.Errorf("got location %d for %q", location, key)
+				}
+				if key == "inner.value" && (dst.Inner == nil || value.Addr().Interface() != &dst.Inner.Value) {
+					t.Errorf("got value not in the struct for %q", key)
+				}
+				got = append(got, key)
+			})
+			decode := d.Decode
+			if tt.dryRun {
+				decode = d.DryRun
+			}
+			if err := decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+	t.Run("json only", func(t *testing.T) {
+		type request struct {
+			Name string `json:"name"`
+			Age  int    `json:"age"`
+		}
+		d := NewDecoder()
+		var got []string
+		d.OnField(func(key string, location int, value reflect.Value) {
+			if location != LocationJSON {
+				t.Errorf("got location %d for %q", location, key)
+			}
+			got = append(got, key)
+		})
+		var dst request
+		if err := d.Decode(&dst, newRequest("POST", "/", "application/json", `{"name":"a","age":1}`)); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if want := []string{"age", "name"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if dst.Name != "a" || dst.Age != 1 {
+			t.Errorf("got %+v", dst)
+		}
+	})
+}
+
+func TestDecodesJSONDirectly(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+	}
+	// Options applied to each field, which must not be bypassed by
+	// decoding with encoding/json.
+	perField := map[string]func(d *Decoder){
+		"mask":              func(d *Decoder) { d.mask = [][]string{{"name"}} },
+		"allocator":         func(d *Decoder) { d.SetAllocator(func(t reflect.Type) reflect.Value { return reflect.New(t) }) },
+		"onField":           func(d *Decoder) { d.OnField(func(string, int, reflect.Value) {}) },
+		"maxSliceLen":       func(d *Decoder) { d.MaxSliceLen(1) },
+		"caseSensitiveJSON": func(d *Decoder) { d.CaseSensitiveJSON(true) },
+		"cache":             func(d *Decoder) { d.KeyNormalizer(strings.ToLower) },
+	}
+	// Options which do not apply to JSON bodies decoded with encoding/json.
+	other := map[string]bool{
+		"separators": true, "zeroEmpty": true, "ignoreUnknownKeys": true, "maxMemory": true,
+		"maxFiles": true, "maxTotalSize": true, "maxBodySize": true, "readTimeout": true,
+		"collectErrors": true, "requireContentType": true, "requireContentTypeHeader": true,
+		"boolPresenceTrue": true, "headerListFields": true, "parallelArrays": true, "directJSON": true,
+		"decompressBodies": true, "allowEmptyBody": true, "bodyCodecs": true, "boolConverter": true,
+		"pathParamsEncoded": true, "forbidMethodFields": true, "atomic": true, "dryRun": true,
+		"lazyNil": true, "suggestKeys": true, "emptySliceAsPresent": true, "emptyPointerAsPresent": true,
+		"overrides": true, "disallowed": true, "querySeparator": true, "flatKeys": true,
+		"precedence": true, "logger": true, "pathExtractor": true,
+	}
+	typ := reflect.TypeOf(Decoder{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Name
+		if _, ok := perField[name]; !ok && !other[name] {
+			t.Errorf("option %q is not known to decodesJSONDirectly, add it to handlesFields if it applies to each field", name)
+		}
+	}
+	d := NewDecoder()
+	if !d.decodesJSONDirectly(d.cache.get(reflect.TypeOf(request{}))) {
+		t.Fatal("got json only struct not decoded directly")
+	}
+	for name, set := range perField {
+		t.Run(name, func(t *testing.T) {
+			d := NewDecoder()
+			set(d)
+			if d.decodesJSONDirectly(d.cache.get(reflect.TypeOf(request{}))) {
+				t.Errorf("got json decoded directly with %q set", name)
+			}
+		})
+	}
+}
+
+func TestOnFieldDryRun(t *testing.T) {
+	type request struct {
+		File multipart.File `file:"file"`
+	}
+	body, contentType := newMultipartBody(nil, map[string]map[string]string{"file": {"a.txt": "abc"}})
+	d := NewDecoder()
+	d.OnField(func(key string, location int, value reflect.Value) {
+		t.Errorf("got unexpected call for %q", key)
+	})
+	if err := d.DryRun(&request{}, newRequest("POST", "/", contentType, body)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var errSelfDecoded = errors.New("missing token")
+
+type selfDecoded struct {
+	Token string `query:"token"`
+}
+
+func (s *selfDecoded) DecodeRequest(r *http.Request) error {
+	s.Token = r.Header.Get("Authorization")
+	if s.Token == "" {
+		return errSelfDecoded
+	}
+	return nil
+}
+
+func TestRequestDecoder(t *testing.T) {
+	type nested struct {
+		Auth selfDecoded `query:"auth"`
+	}
+	type request struct {
+		ID     string       `query:"id"`
+		Auth   selfDecoded  `query:"auth"`
+		Ptr    *selfDecoded `query:"ptr"`
+		Nested nested       `query:"nested"`
+	}
+	tests := []struct {
+		name   string
+		header string
+		dst    interface{}
+		want   interface{}
+		key    string
+	}{
+		{
+			name:   "top level",
+			header: "a",
+			dst:    &selfDecoded{},
+			want:   &selfDecoded{Token: "a"},
+		},
+		{
+			name:   "fields",
+			header: "a",
+			dst:    &request{},
+			want:   &request{ID: "1", Auth: selfDecoded{Token: "a"}, Ptr: &selfDecoded{Token: "a"}, Nested: nested{Auth: selfDecoded{Token: "a"}}},
+		},
+		{
+			name: "top level error",
+			dst:  &selfDecoded{},
+			key:  "",
+		},
+		{
+			name: "field error",
+			dst:  &request{},
+			key:  "auth",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest("GET", "/?id=1&token=q&auth.token=q", "", "")
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			err := NewDecoder().Decode(tt.dst, r)
+			if tt.want == nil {
+				if tt.key != "" {
+					err = keyError(err, tt.key)
+				}
+				e, ok := err.(DecodeRequestError)
+				if !ok || e.Key != tt.key || !errors.Is(err, errSelfDecoded) {
+					t.Errorf("got error %v, want a DecodeRequestError for %q", err, tt.key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestDecoderErrorKeys(t *testing.T) {
+	type nested struct {
+		Auth selfDecoded `query:"auth"`
+		Flat selfDecoded `query:"x.auth,flat"`
+	}
+	type request struct {
+		Nested nested `query:"nested"`
+	}
+	tests := []struct {
+		name       string
+		separators []rune
+		want       []string
+	}{
+		{name: "dots", want: []string{"nested.auth", "nested.x.auth"}},
+		{name: "brackets", separators: []rune{'[', ']', 0}, want: []string{"nested[auth]", "nested[x.auth]"}},
+		{name: "brackets and dots", separators: []rune{'[', ']', '.'}, want: []string{"nested[auth]", "nested[x.auth]"}},
+		{name: "slashes", separators: []rune{0, 0, '/'}, want: []string{"nested/auth", "nested/x.auth"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.CollectErrors(true)
+			if tt.separators != nil {
+				d.Separator(tt.separators[0], tt.separators[1], tt.separators[2])
+			}
+			err := d.Decode(&request{}, newRequest("GET", "/", "", ""))
+			errs, ok := err.(MultiError)
+			if !ok {
+				t.Fatalf("got %v, want a MultiError", err)
+			}
+			var keys []string
+			for k := range errs {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("got %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
+
+type cloneID string
+
+func TestClone(t *testing.T) {
+	type request struct {
+		ID    cloneID `query:"id"`
+		Page  int
+		Inner struct {
+			Name string `query:"name"`
+		} `query:"inner"`
+	}
+	d := NewDecoder()
+	d.DefaultLocation(LocationQuery)
+	if err := d.Decode(&request{}, newRequest("GET", "/", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		derive func() *Decoder
+		target string
+		want   cloneID
+		page   int
+		inner  string
+	}{
+		{
+			name: "clone",
+			derive: func() *Decoder {
+				c := d.Clone()
+				c.RegisterConverter(cloneID(""), func(s string) reflect.Value { return reflect.ValueOf(cloneID("c" + s)) })
+				c.DefaultLocation(LocationHeader)
+				return c
+			},
+			target: "/?id=1&inner.name=a",
+			want:   "c1",
+			inner:  "a",
+		},
+		{
+			name: "with separator",
+			derive: func() *Decoder {
+				c := d.WithSeparator('[', ']', 0)
+				c.RegisterConverter(cloneID(""), func(s string) reflect.Value { return reflect.ValueOf(cloneID("s" + s)) })
+				return c
+			},
+			target: "/?id=1&Page=2&inner[name]=a",
+			want:   "s1",
+			page:   2,
+			inner:  "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.derive()
+			var dst request
+			if err := c.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if dst.ID != tt.want || dst.Page != tt.page || dst.Inner.Name != tt.inner {
+				t.Errorf("got %+v from the copy", dst)
+			}
+			var orig request
+			if err := d.Decode(&orig, newRequest("GET", "/?id=1&Page=2&inner.name=a", "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if orig.ID != "1" || orig.Page != 2 || orig.Inner.Name != "a" {
+				t.Errorf("got %+v from the original decoder", orig)
+			}
+		})
+	}
+}
+
+func TestCloneConcurrent(t *testing.T) {
+	type request struct {
+		ID  cloneID `query:"id"`
+		Age int     `query:"age"`
+	}
+	d := NewDecoder()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var dst request
+				if err := d.Decode(&dst, newRequest("GET", "/?id=1&age=2", "", "")); err != nil || dst.ID != "1" {
+					t.Errorf("got %+v and error %v from the decoder", dst, err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c := d.Clone()
+				c.RegisterConverter(cloneID(""), func(s string) reflect.Value { return reflect.ValueOf(cloneID("c" + s)) })
+				var dst request
+				if err := c.Decode(&dst, newRequest("GET", "/?id=1&age=2", "", "")); err != nil || dst.ID != "c1" {
+					t.Errorf("got %+v and error %v from the clone", dst, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type EmbeddedDecoded struct {
+	Auth selfDecoded `query:"auth"`
+}
+
+type EmbeddedParams struct {
+	Name string `query:"name"`
+}
+
+func TestRequestDecoderEmbeddedPointers(t *testing.T) {
+	type request struct {
+		*EmbeddedDecoded
+		*EmbeddedParams
+		ID string `query:"id"`
+	}
+	r := newRequest("GET", "/?id=1", "", "")
+	r.Header.Set("Authorization", "a")
+	var dst request
+	if err := NewDecoder().Decode(&dst, r); err != nil {
+		t.Fatal(err)
+	}
+	want := request{EmbeddedDecoded: &EmbeddedDecoded{Auth: selfDecoded{Token: "a"}}, ID: "1"}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+}
+
+type EmbeddedBody struct {
+	Raw []byte `name:"raw" from:"body"`
+}
+
+func TestBodyLocation(t *testing.T) {
+	type embeddedRequest struct {
+		*EmbeddedBody
+		Name string `json:"name"`
+	}
+	type jsonRequest struct {
+		Raw  []byte `name:"raw" from:"body"`
+		Name string `json:"name"`
+	}
+	type formRequest struct {
+		Raw  string `name:"raw" from:"body"`
+		Name string `form:"name"`
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		dst         interface{}
+		want        interface{}
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+			dst:         &jsonRequest{},
+			want:        &jsonRequest{Raw: []byte(`{"name":"a"}`), Name: "a"},
+		},
+		{
+			name:        "form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=a",
+			dst:         &formRequest{},
+			want:        &formRequest{Raw: "name=a", Name: "a"},
+		},
+		{
+			name:        "empty",
+			contentType: "application/x-www-form-urlencoded",
+			dst:         &formRequest{},
+			want:        &formRequest{},
+		},
+		{
+			name:        "embedded pointer",
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+			dst:         &embeddedRequest{},
+			want:        &embeddedRequest{EmbeddedBody: &EmbeddedBody{Raw: []byte(`{"name":"a"}`)}, Name: "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder().Decode(tt.dst, newRequest("POST", "/", tt.contentType, tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+type atomicNode struct {
+	Name string      `query:"name"`
+	Next *atomicNode `query:"-"`
+}
+
+func TestAtomic(t *testing.T) {
+	type inner struct {
+		City string `query:"city"`
+	}
+	type request struct {
+		Age   int         `query:"age"`
+		Tags  []string    `query:"tags"`
+		Inner *inner      `query:"inner"`
+		Node  *atomicNode `query:"node"`
+		Alias *inner      `query:"-"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		valid  bool
+		age    int
+		city   string
+	}{
+		{name: "valid", target: "/?age=2&tags=b&inner.city=b&node.name=b", valid: true, age: 2, city: "b"},
+		{name: "invalid", target: "/?age=x&tags=b&inner.city=b&node.name=b", age: 1, city: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &inner{City: "a"}
+			node := &atomicNode{Name: "a"}
+			node.Next = node
+			dst := request{Age: 1, Tags: []string{"a"}, Inner: in, Node: node, Alias: in}
+			d := NewDecoder()
+			d.Atomic(true)
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if (err == nil) != tt.valid {
+				t.Fatalf("got error %v", err)
+			}
+			if dst.Age != tt.age || dst.Inner.City != tt.city {
+				t.Errorf("got age %d and city %q, want %d and %q", dst.Age, dst.Inner.City, tt.age, tt.city)
+			}
+			if dst.Inner != in || dst.Alias != in || dst.Node != node || node.Next != node {
+				t.Errorf("pointers of the struct were replaced")
+			}
+			if tt.valid && (node.Name != "b" || !reflect.DeepEqual(dst.Tags, []string{"b"})) {
+				t.Errorf("got node %q and tags %q", node.Name, dst.Tags)
+			}
+			if !tt.valid && (node.Name != "a" || !reflect.DeepEqual(dst.Tags, []string{"a"})) {
+				t.Errorf("struct was modified: node %q and tags %q", node.Name, dst.Tags)
+			}
+		})
+	}
+}
+
+func TestTagPriority(t *testing.T) {
+	type request struct {
+		Token string `header:"X-Token" query:"token" json:"tok"`
+	}
+	tests := []struct {
+		name     string
+		priority []int
+		target   string
+		header   string
+		body     string
+		want     string
+	}{
+		{name: "default", target: "/?token=q", header: "h", body: `{"tok":"j"}`, want: "h"},
+		{name: "query first", priority: []int{LocationQuery, LocationHeader}, target: "/?token=q", header: "h", body: `{"tok":"j"}`, want: "q"},
+		{name: "json only", priority: []int{LocationJSON}, target: "/?token=q", header: "h", body: `{"tok":"j"}`, want: "j"},
+		{name: "missing location ignored", priority: []int{LocationQuery}, header: "h", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			// The struct is cached with the default priority first,
+			// so a stale alias would be used if the cache was kept.
+			if err := d.Check(&request{}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.priority != nil {
+				d.TagPriority(tt.priority)
+				if n := len(d.cache.m); n != 0 {
+					t.Fatalf("got %d cached structs, want the cache reset", n)
+				}
+			}
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			r := newRequest("POST", target, "application/json", body)
+			if tt.header != "" {
+				r.Header.Set("X-Token", tt.header)
+			}
+			var dst request
+			if err := d.Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if dst.Token != tt.want {
+				t.Errorf("got %q, want %q", dst.Token, tt.want)
+			}
+		})
+	}
+}
+
+var errBadPair = errors.New("pair without =")
+
+// pairsCodec decodes bodies of key=value lines.
+func pairsCodec(body io.Reader) (map[string]interface{}, error) {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, errBadPair
+		}
+		m[line[:i]] = line[i+1:]
+	}
+	return m, nil
+}
+
+func TestRegisterBodyCodec(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		require     bool
+		want        request
+		check       func(err error) bool
+	}{
+		{name: "registered", contentType: "text/x-pairs", body: "name=a\nage=3", want: request{Name: "a", Age: 3}},
+		{name: "parameters and case", contentType: "Text/X-Pairs; charset=utf-8", body: "name=a", want: request{Name: "a"}},
+		{name: "json still decoded", contentType: "application/json", body: `{"name":"a"}`, want: request{Name: "a"}},
+		{name: "unregistered as json", contentType: "text/x-other", body: `{"name":"a"}`, want: request{Name: "a"}},
+		{
+			name:        "unregistered required",
+			contentType: "text/x-other",
+			body:        `{"name":"a"}`,
+			require:     true,
+			check: func(err error) bool {
+				return reflect.DeepEqual(err, ContentTypeError{RequestContentType: "text/x-other", ContentType: "application/json"})
+			},
+		},
+		{name: "registered required", contentType: "text/x-pairs", body: "name=a", require: true, want: request{Name: "a"}},
+		{name: "codec error", contentType: "text/x-pairs", body: "name", check: func(err error) bool { return err == errBadPair }},
+		{
+			name:        "conversion error",
+			contentType: "text/x-pairs",
+			body:        "age=x",
+			check: func(err error) bool {
+				_, ok := keyError(err, "age").(ConversionError)
+				return ok
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.RegisterBodyCodec("text/x-pairs", pairsCodec)
+			d.RequireContentType(tt.require)
+			var dst request
+			err := d.Decode(&dst, newRequest("POST", "/", tt.contentType, tt.body))
+			if tt.check != nil {
+				if !tt.check(err) {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst != tt.want {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethodFields(t *testing.T) {
+	type address struct {
+		City string `query:"city"`
+		Zip  string `query:"zip" methods:"PUT"`
+	}
+	type request struct {
+		Name    string  `query:"name" methods:"post"`
+		Page    int     `query:"page"`
+		Address address `query:"address" methods:"POST,PATCH"`
+		Note    string  `json:"note" methods:"POST"`
+	}
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		forbid  bool
+		want    request
+		wantErr error
+	}{
+		{name: "allowed", method: "POST", target: "/?name=a&page=2&address.city=x", body: `{"note":"n"}`, want: request{Name: "a", Page: 2, Address: address{City: "x"}, Note: "n"}},
+		{name: "ignored", method: "GET", target: "/?name=a&page=2", want: request{Page: 2}},
+		{name: "inherited ignored", method: "GET", target: "/?address.city=x", want: request{}},
+		{name: "inherited allowed", method: "PATCH", target: "/?address.city=x", want: request{Address: address{City: "x"}}},
+		{name: "nested override", method: "PUT", target: "/?address.zip=1&address.city=x", want: request{Address: address{Zip: "1"}}},
+		{name: "json ignored", method: "PUT", body: `{"note":"n"}`, want: request{}},
+		{name: "forbidden", method: "GET", target: "/?name=a", forbid: true, wantErr: MethodError{Key: "name", Method: "GET", AllowedMethods: []string{"POST"}}},
+		{name: "forbidden inherited", method: "GET", target: "/?address.city=x", forbid: true, wantErr: MethodError{Key: "address.city", Method: "GET", AllowedMethods: []string{"POST", "PATCH"}}},
+		{name: "forbidden json", method: "PUT", body: `{"note":"n"}`, forbid: true, wantErr: MethodError{Key: "note", Method: "PUT", AllowedMethods: []string{"POST"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.ForbidMethodFields(tt.forbid)
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			var dst request
+			err := d.Decode(&dst, newRequest(tt.method, target, "application/json", body))
+			if tt.wantErr != nil {
+				if err := keyError(err, tt.wantErr.(MethodError).Key); !reflect.DeepEqual(err, tt.wantErr) {
+					t.Errorf("got %#v, want %#v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst != tt.want {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestKeys(t *testing.T) {
+	type address struct {
+		City string `query:"city"`
+	}
+	type request struct {
+		Username string  `query:"username"`
+		Address  address `query:"address"`
+		Token    string  `header:"token"`
+	}
+	tests := []struct {
+		name     string
+		key      string
+		disabled bool
+		want     string
+	}{
+		{name: "typo", key: "usrname", want: "username"},
+		{name: "case", key: "UserNme", want: "username"},
+		{name: "nested", key: "address.cty", want: "address.city"},
+		{name: "nested parent", key: "adress.city", want: "address.city"},
+		{name: "no close match", key: "zzz"},
+		{name: "too far", key: "user", want: ""},
+		{name: "other location", key: "tokn"},
+		{name: "disabled", key: "usrname", disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.IgnoreUnknownKeys(false)
+			d.SuggestKeys(!tt.disabled)
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", "/?"+tt.key+"=a", "", ""))
+			want := UnknownKeyError{Key: tt.key, Suggestion: tt.want}
+			err = keyError(err, tt.key)
+			if !reflect.DeepEqual(err, want) {
+				t.Fatalf("got %#v, want %#v", err, want)
+			}
+			msg := fmt.Sprintf("invalid param %q", tt.key)
+			if tt.want != "" {
+				msg += fmt.Sprintf(", did you mean %q?", tt.want)
+			}
+			if got := err.Error(); got != msg {
+				t.Errorf("got message %q, want %q", got, msg)
+			}
+		})
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	type request struct {
+		Page int    `query:"page"`
+		Name string `json:"name"`
+	}
+	type body struct {
+		Name string `json:"name"`
+	}
+	var buf bytes.Buffer
+	d := NewDecoder()
+	d.SetLogger(log.New(&buf, "", 0).Printf)
+	if err := d.Decode(&request{}, newRequest("POST", "/?page=1&other=x", "application/json", `{"name":"a","extra":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Decode(&body{}, newRequest("POST", "/", "application/json", `{"name":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`reqtruct: skipping unknown key "other" in query`,
+		`reqtruct: skipping unknown key "extra" in json`,
+		"reqtruct: not decoding json body of reqtruct.request with encoding/json because of fields in other locations or with options",
+		"reqtruct: decoding json body to reqtruct.body with encoding/json",
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("got log %q, want it to contain %q", buf.String(), want)
+		}
+	}
+
+	buf.Reset()
+	d.SetLogger(nil)
+	if err := d.Decode(&request{}, newRequest("POST", "/?other=x", "application/json", `{"extra":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got log %q after removing the logger", buf.String())
+	}
+}
+
+func TestMustDecode(t *testing.T) {
+	type request struct {
+		Page int `query:"page"`
+		Size int `query:"size"`
+	}
+	tests := []struct {
+		name      string
+		target    string
+		collect   bool
+		want      request
+		wantPanic []string
+	}{
+		{name: "valid", target: "/?page=2&size=10", want: request{Page: 2, Size: 10}},
+		{name: "invalid", target: "/?page=a", wantPanic: []string{"reqtruct: ", `"page"`}},
+		{name: "all errors", target: "/?page=a&size=b", collect: true, wantPanic: []string{"2 errors:", `"page"`, `"size"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.CollectErrors(tt.collect)
+			var dst request
+			defer func() {
+				r := recover()
+				if len(tt.wantPanic) == 0 {
+					if r != nil {
+						t.Fatalf("unexpected panic: %v", r)
+					}
+					if !reflect.DeepEqual(dst, tt.want) {
+						t.Errorf("got %+v, want %+v", dst, tt.want)
+					}
+					return
+				}
+				msg, ok := r.(string)
+				if !ok {
+					t.Fatalf("got panic %v, want a message", r)
+				}
+				for _, s := range tt.wantPanic {
+					if !strings.Contains(msg, s) {
+						t.Errorf("got panic %q, want it to contain %q", msg, s)
+					}
+				}
+			}()
+			d.MustDecode(&dst, newRequest("GET", tt.target, "", ""))
+		})
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	type query struct {
+		UserName string `query:"user_name"`
+	}
+	type body struct {
+		UserName string `json:"user_name"`
+	}
+	normalize := func(k string) string {
+		return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(k))
+	}
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		dst    interface{}
+		want   interface{}
+	}{
+		{name: "query snake", target: "/?user_name=a", dst: &query{}, want: &query{UserName: "a"}},
+		{name: "query kebab", target: "/?user-name=a", dst: &query{}, want: &query{UserName: "a"}},
+		{name: "query camel", target: "/?userName=a", dst: &query{}, want: &query{UserName: "a"}},
+		{name: "json snake", body: `{"user_name":"a"}`, dst: &body{}, want: &body{UserName: "a"}},
+		{name: "json kebab", body: `{"user-name":"a"}`, dst: &body{}, want: &body{UserName: "a"}},
+		{name: "json camel", body: `{"userName":"a"}`, dst: &body{}, want: &body{UserName: "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.KeyNormalizer(normalize)
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			if err := d.Decode(tt.dst, newRequest("POST", target, "application/json", tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+// newMultipartForm returns the parsed multipart form with the values and files.
+func newMultipartForm(t *testing.T, values map[string][]string, files map[string]map[string]string) *multipart.Form {
+	body, contentType := newMultipartBody(values, files)
+	r := newRequest("POST", "/", contentType, body)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	return r.MultipartForm
+}
+
+func TestDecodeMultipart(t *testing.T) {
+	type request struct {
+		Name   string                  `form:"name"`
+		Tags   []string                `form:"tags"`
+		Avatar *multipart.FileHeader   `file:"avatar"`
+		Docs   []*multipart.FileHeader `file:"docs"`
+	}
+	tests := []struct {
+		name      string
+		values    map[string][]string
+		files     map[string]map[string]string
+		nilForm   bool
+		location  int
+		wantName  string
+		wantTags  []string
+		wantFiles []string
+		wantErr   bool
+	}{
+		{name: "values", values: map[string][]string{"name": {"a"}, "tags": {"x", "y"}}, location: LocationForm, wantName: "a", wantTags: []string{"x", "y"}},
+		{name: "files", files: map[string]map[string]string{"avatar": {"a.png": "a"}, "docs": {"b.txt": "b"}}, location: LocationForm, wantFiles: []string{"a.png", "b.txt"}},
+		{name: "values and files", values: map[string][]string{"name": {"a"}}, files: map[string]map[string]string{"avatar": {"a.png": "a"}}, location: LocationForm, wantName: "a", wantFiles: []string{"a.png"}},
+		{name: "wrong location", values: map[string][]string{"name": {"a"}}, location: LocationQuery, wantErr: true},
+		{name: "nil form", nilForm: true, location: LocationForm, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form *multipart.Form
+			if !tt.nilForm {
+				form = newMultipartForm(t, tt.values, tt.files)
+			}
+			var dst request
+			err := NewDecoder().DecodeMultipart(&dst, form, tt.location)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst.Name != tt.wantName || !reflect.DeepEqual(dst.Tags, tt.wantTags) {
+				t.Errorf("got %q %q, want %q %q", dst.Name, dst.Tags, tt.wantName, tt.wantTags)
+			}
+			var got []string
+			if dst.Avatar != nil {
+				got = append(got, dst.Avatar.Filename)
+			}
+			for _, f := range dst.Docs {
+				got = append(got, f.Filename)
+			}
+			if !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("got files %q, want %q", got, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestMultipartLimits(t *testing.T) {
+	type request struct {
+		Docs []*multipart.FileHeader `file:"docs"`
+	}
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxSize  int64
+		files    map[string]string
+		wantErr  error
+	}{
+		{name: "files at limit", maxFiles: 2, files: map[string]string{"a.txt": "a", "b.txt": "b"}},
+		{name: "files over limit", maxFiles: 2, files: map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"}, wantErr: LimitExceededError{Name: "max files", Limit: 2}},
+		{name: "size at limit", maxSize: 4, files: map[string]string{"a.txt": "ab", "b.txt": "cd"}},
+		{name: "size over limit", maxSize: 4, files: map[string]string{"a.txt": "ab", "b.txt": "cde"}, wantErr: LimitExceededError{Name: "max total size", Limit: 4}},
+		{name: "no limits", files: map[string]string{"a.txt": "ab", "b.txt": "cde"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.MaxFiles(tt.maxFiles)
+			d.MaxTotalSize(tt.maxSize)
+			files := map[string]map[string]string{"docs": tt.files}
+			body, contentType := newMultipartBody(nil, files)
+			decoded := map[string]error{}
+			var dst request
+			decoded["Decode"] = d.Decode(&dst, newRequest("POST", "/", contentType, body))
+			decoded["DecodeMultipart"] = d.DecodeMultipart(&request{}, newMultipartForm(t, nil, files), LocationForm)
+			for name, err := range decoded {
+				if tt.wantErr != nil {
+					if !reflect.DeepEqual(err, tt.wantErr) {
+						t.Errorf("%s: got error %#v, want %#v", name, err, tt.wantErr)
+					}
+				} else if err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+			if tt.wantErr == nil && len(dst.Docs) != len(tt.files) {
+				t.Errorf("got %d files, want %d", len(dst.Docs), len(tt.files))
+			}
+		})
+	}
+}
+
+type EmbeddedFiles struct {
+	Files map[string][]*multipart.FileHeader `file:"files"`
+}
+
+func TestDecodeFilesMapEmbeddedPointer(t *testing.T) {
+	type request struct {
+		Name string `form:"name"`
+		*EmbeddedFiles
+	}
+	body, contentType := newMultipartBody(map[string][]string{"name": {"a"}}, map[string]map[string]string{"docs": {"b.txt": "b"}})
+	var dst request
+	if err := NewDecoder().Decode(&dst, newRequest("POST", "/", contentType, body)); err != nil {
+		t.Fatal(err)
+	}
+	if dst.EmbeddedFiles == nil || len(dst.Files["docs"]) != 1 || dst.Files["docs"][0].Filename != "b.txt" {
+		t.Errorf("got %+v, want the docs files", dst.EmbeddedFiles)
+	}
+}
+
+func TestDecodeFilesMap(t *testing.T) {
+	type request struct {
+		Name   string                             `form:"name"`
+		Avatar *multipart.FileHeader              `file:"avatar"`
+		Files  map[string][]*multipart.FileHeader `file:"files"`
+	}
+	tests := []struct {
+		name      string
+		multipart bool
+		files     map[string]map[string]string
+		want      map[string][]string
+	}{
+		{name: "decode", files: map[string]map[string]string{"avatar": {"a.png": "a"}, "docs": {"b.txt": "b"}}, want: map[string][]string{"avatar": {"a.png"}, "docs": {"b.txt"}}},
+		{name: "decode multipart", multipart: true, files: map[string]map[string]string{"avatar": {"a.png": "a"}, "docs": {"b.txt": "b"}}, want: map[string][]string{"avatar": {"a.png"}, "docs": {"b.txt"}}},
+		{name: "decode multipart without files", multipart: true, want: map[string][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.IgnoreUnknownKeys(false)
+			values := map[string][]string{"name": {"a"}}
+			var dst request
+			var err error
+			if tt.multipart {
+				err = d.DecodeMultipart(&dst, newMultipartForm(t, values, tt.files), LocationForm)
+			} else {
+				body, contentType := newMultipartBody(values, tt.files)
+				err = d.Decode(&dst, newRequest("POST", "/", contentType, body))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst.Name != "a" {
+				t.Errorf("got name %q, want %q", dst.Name, "a")
+			}
+			got := map[string][]string{}
+			for k, files := range dst.Files {
+				for _, f := range files {
+					got[k] = append(got[k], f.Filename)
+				}
+			}
+			if dst.Files == nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got files %v, want %v", got, tt.want)
+			}
+			if _, ok := tt.files["avatar"]; ok && (dst.Avatar == nil || dst.Avatar.Filename != "a.png") {
+				t.Errorf("got avatar %+v, want a.png", dst.Avatar)
+			}
+		})
+	}
+}
+
+func TestRequiredIf(t *testing.T) {
+	type address struct {
+		Country string `query:"country"`
+		State   string `query:"state" requiredif:"country=US"`
+	}
+	type request struct {
+		Type      string    `query:"type"`
+		VAT       string    `query:"vat" requiredif:"type=business"`
+		Rate      float64   `query:"rate"`
+		Reason    string    `query:"reason" requiredif:"rate=1.50"`
+		Active    *bool     `query:"active"`
+		Note      string    `query:"note" requiredif:"active=1"`
+		Address   address   `query:"address"`
+		Addresses []address `query:"addresses"`
+	}
+	tests := []struct {
+		name    string
+		target  string
+		wantErr []string
+	}{
+		{name: "condition met and missing", target: "/?type=business", wantErr: []string{"vat"}},
+		{name: "condition met and present", target: "/?type=business&vat=123"},
+		{name: "condition not met", target: "/?type=personal"},
+		{name: "typed float", target: "/?rate=1.5", wantErr: []string{"reason"}},
+		{name: "typed float not met", target: "/?rate=1.25"},
+		{name: "typed bool", target: "/?active=true", wantErr: []string{"note"}},
+		{name: "nil pointer", target: "/"},
+		{name: "nested", target: "/?address.country=US", wantErr: []string{"address.state"}},
+		{name: "nested present", target: "/?address.country=US&address.state=CA"},
+		{name: "slice", target: "/?addresses.0.country=US&addresses.0.state=CA&addresses.1.country=US", wantErr: []string{"addresses.1.state"}},
+		{name: "all", target: "/?type=business&address.country=US&addresses.0.country=US", wantErr: []string{"vat", "address.state", "addresses.0.state"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.CollectErrors(true)
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			e, ok := err.(MultiError)
+			if !ok || len(e) != len(tt.wantErr) {
+				t.Fatalf("got %v, want errors for %q", err, tt.wantErr)
+			}
+			for _, key := range tt.wantErr {
+				if _, ok := e[key].(MissingKeyError); !ok {
+					t.Errorf("got %v for %q, want a MissingKeyError", e[key], key)
+				}
+			}
+		})
+	}
+	type jsonRequest struct {
+		ID   string `query:"id"`
+		Type string `json:"type"`
+		VAT  string `json:"vat" requiredif:"type=business"`
+	}
+	for _, direct := range []bool{false, true} {
+		t.Run(fmt.Sprintf("json sent empty direct %v", direct), func(t *testing.T) {
+			d := NewDecoder()
+			d.DirectJSON(direct)
+			if err := d.Decode(&jsonRequest{}, newRequest("POST", "/?id=1", "application/json", `{"type":"business","vat":""}`)); err != nil {
+				t.Fatal(err)
+			}
+			err := d.Decode(&jsonRequest{}, newRequest("POST", "/?id=1", "application/json", `{"type":"business"}`))
+			if _, ok := keyError(err, "vat").(MissingKeyError); !ok {
+				t.Errorf("got %v, want a MissingKeyError", err)
+			}
+		})
+	}
+}
+
+func TestExclusiveGroups(t *testing.T) {
+	type request struct {
+		Token    string `query:"token" group:"auth,required"`
+		APIKey   string `header:"X-Api-Key" group:"auth"`
+		Session  string `cookie:"session" group:"auth"`
+		ByName   string `query:"by_name" group:"sort"`
+		ByDate   string `query:"by_date" group:"sort"`
+		Page     int    `query:"page"`
+		PageSize int    `query:"page_size"`
+	}
+	tests := []struct {
+		name    string
+		target  string
+		header  string
+		cookie  string
+		collect bool
+		want    MultiError
+	}{
+		{name: "one", target: "/?token=a"},
+		{name: "one from another location", header: "k"},
+		{name: "optional group unset", target: "/?token=a&page=1"},
+		{name: "optional group set", target: "/?token=a&by_name=1"},
+		{
+			name:   "none of a required group",
+			target: "/?page=1",
+			want:   MultiError{"auth": ExclusiveGroupError{Group: "auth", Keys: []string{"token", "X-Api-Key", "session"}}},
+		},
+		{
+			name:   "two",
+			target: "/?token=a",
+			header: "k",
+			want:   MultiError{"auth": ExclusiveGroupError{Group: "auth", Keys: []string{"token", "X-Api-Key", "session"}, PresentKeys: []string{"token", "X-Api-Key"}}},
+		},
+		{
+			name:   "three",
+			target: "/?token=a",
+			header: "k",
+			cookie: "s",
+			want:   MultiError{"auth": ExclusiveGroupError{Group: "auth", Keys: []string{"token", "X-Api-Key", "session"}, PresentKeys: []string{"token", "X-Api-Key", "session"}}},
+		},
+		{
+			name:   "first group only",
+			target: "/?by_name=1&by_date=2",
+			want:   MultiError{"auth": ExclusiveGroupError{Group: "auth", Keys: []string{"token", "X-Api-Key", "session"}}},
+		},
+		{
+			name:    "all groups",
+			target:  "/?by_name=1&by_date=2",
+			collect: true,
+			want: MultiError{
+				"auth": ExclusiveGroupError{Group: "auth", Keys: []string{"token", "X-Api-Key", "session"}},
+				"sort": ExclusiveGroupError{Group: "sort", Keys: []string{"by_name", "by_date"}, PresentKeys: []string{"by_name", "by_date"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.CollectErrors(tt.collect)
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := newRequest("GET", target, "", "")
+			if tt.header != "" {
+				r.Header.Set("X-Api-Key", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			var dst request
+			err := d.Decode(&dst, r)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+	t.Run("direct json", func(t *testing.T) {
+		type jsonRequest struct {
+			ID    string `query:"id"`
+			Token string `json:"token" group:"auth"`
+			Key   string `json:"key" group:"auth"`
+		}
+		d := NewDecoder()
+		d.DirectJSON(true)
+		err := d.Decode(&jsonRequest{}, newRequest("POST", "/?id=1", "application/json", `{"token":"a","key":"k"}`))
+		want := MultiError{"auth": ExclusiveGroupError{Group: "auth", Keys: []string{"token", "key"}, PresentKeys: []string{"token", "key"}}}
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("got error %v, want %v", err, want)
+		}
+	})
+}
+
+func TestMaxSliceLen(t *testing.T) {
+	type jsonItem struct {
+		Name string `json:"name"`
+	}
+	type queryItem struct {
+		Name string `query:"name"`
+	}
+	type jsonOnly struct {
+		Tags  []string   `json:"tags"`
+		Items []jsonItem `json:"items"`
+	}
+	type mixed struct {
+		Page  int         `query:"page"`
+		Tags  []string    `name:"tags" from:"query,json"`
+		Items []queryItem `query:"items"`
+		IDs   []int       `query:"ids"`
+		Codes []string    `query:"codes" delim:"|"`
+	}
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		direct  bool
+		dst     interface{}
+		wantErr string
+	}{
+		{name: "json within limit", body: `{"tags":["a","b"]}`, dst: &jsonOnly{}},
+		{name: "json", body: `{"tags":["a","b","c","d"]}`, dst: &jsonOnly{}, wantErr: "tags"},
+		{name: "json structs", body: `{"items":[{"name":"a"},{"name":"b"},{"name":"c"}]}`, dst: &jsonOnly{}, wantErr: "items.2.name"},
+		{name: "direct json", target: "/?page=1", body: `{"tags":["a","b","c"]}`, direct: true, dst: &mixed{}, wantErr: "tags"},
+		{name: "query", target: "/?tags=a&tags=b&tags=c", dst: &mixed{}, wantErr: "tags"},
+		{name: "query within limit", target: "/?tags=a&tags=b", dst: &mixed{}},
+		{name: "query structs", target: "/?items.0.name=a&items.1.name=b&items.2.name=c", dst: &mixed{}, wantErr: "items.2.name"},
+		{name: "split value within limit", target: "/?ids=1,2", dst: &mixed{}},
+		{name: "split value", target: "/?ids=1,2,3", dst: &mixed{}, wantErr: "ids"},
+		{name: "split value before converting", target: "/?ids=1,2,x", dst: &mixed{}, wantErr: "ids"},
+		{name: "split and repeated values", target: "/?ids=1&ids=2,3", dst: &mixed{}, wantErr: "ids"},
+		{name: "delimited value", target: "/?codes=a|b|c", dst: &mixed{}, wantErr: "codes"},
+		{name: "delimited and repeated values", target: "/?codes=a|b&codes=c", dst: &mixed{}, wantErr: "codes"},
+		{name: "quoted delimiters", target: `/?codes="a|b"|c`, dst: &mixed{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.MaxSliceLen(2)
+			d.DirectJSON(tt.direct)
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			err := d.Decode(tt.dst, newRequest("POST", target, "application/json", body))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if _, ok := keyError(err, tt.wantErr).(LimitExceededError); !ok {
+				t.Errorf("got %v, want a LimitExceededError for %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+		Page int    `query:"page"`
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	io.WriteString(w, `{"name":"`+strings.Repeat("a", 100)+`"}`)
+	w.Close()
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		encoding   string
+		limit      int64
+		unknownLen bool
+		timeout    time.Duration
+		wantErr    bool
+	}{
+		{name: "within limit", body: `{"name":"a"}`, limit: 12},
+		{name: "just over", body: `{"name":"ab"}`, limit: 12, wantErr: true},
+		{name: "unknown length", body: `{"name":"ab"}`, limit: 12, unknownLen: true, wantErr: true},
+		{name: "map path", target: "/?page=1", body: `{"name":"ab"}`, limit: 12, unknownLen: true, wantErr: true},
+		{name: "decompressed", body: gz.String(), encoding: "gzip", limit: 50, wantErr: true},
+		{name: "with timeout", body: `{"name":"a"}`, limit: 12, timeout: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.MaxBodySize(tt.limit)
+			d.ReadTimeout(tt.timeout)
+			d.DecodeCompressedBodies(tt.encoding != "")
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := newRequest("POST", target, "application/json", tt.body)
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			if tt.unknownLen {
+				r.ContentLength = -1
+			}
+			body := r.Body
+			var dst request
+			err := d.Decode(&dst, r)
+			if r.Body != body {
+				t.Error("the body of the request was replaced")
+			}
+			if tt.wantErr {
+				if _, ok := err.(BodyTooLargeError); !ok {
+					t.Errorf("got %v, want a BodyTooLargeError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDisallowLocation(t *testing.T) {
+	type filter struct {
+		Tag string `json:"tag"`
+	}
+	type request struct {
+		Name   string `query:"name"`
+		Note   string `name:"note" from:"query,json"`
+		Filter filter `json:"filter"`
+	}
+	type jsonOnly struct {
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name       string
+		dst        interface{}
+		disallowed []int
+		overrides  map[string]int
+		target     string
+		want       interface{}
+		wantErr    error
+	}{
+		{name: "allowed", dst: &request{}, disallowed: []int{LocationForm}, target: "/?name=a", want: &request{Name: "a"}},
+		{name: "only disallowed", dst: &jsonOnly{}, disallowed: []int{LocationJSON}, wantErr: DisallowedLocationError{Key: "name", Locations: []int{LocationJSON}}},
+		{name: "nested", dst: &request{}, disallowed: []int{LocationJSON}, wantErr: DisallowedLocationError{Key: "filter.tag", Locations: []int{LocationJSON}}},
+		{name: "overridden", dst: &jsonOnly{}, disallowed: []int{LocationJSON}, overrides: map[string]int{"name": LocationQuery}, target: "/?name=a", want: &jsonOnly{Name: "a"}},
+		{name: "overridden to disallowed", dst: &jsonOnly{}, disallowed: []int{LocationQuery}, overrides: map[string]int{"name": LocationQuery}, wantErr: DisallowedLocationError{Key: "name", Locations: []int{LocationQuery}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			for _, l := range tt.disallowed {
+				d.DisallowLocation(l)
+			}
+			for alias, l := range tt.overrides {
+				d.OverrideLocation(alias, l)
+			}
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			err := d.Decode(tt.dst, newRequest("POST", target, "application/json", "{}"))
+			if tt.wantErr != nil {
+				if !reflect.DeepEqual(err, tt.wantErr) {
+					t.Fatalf("got %#v, want %#v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+	t.Run("checked again after changes", func(t *testing.T) {
+		type request struct {
+			Name string `json:"name"`
+		}
+		d := NewDecoder()
+		if err := d.Decode(&request{}, newRequest("POST", "/", "application/json", "{}")); err != nil {
+			t.Fatal(err)
+		}
+		d.DisallowLocation(LocationJSON)
+		if err := d.Decode(&request{}, newRequest("POST", "/", "application/json", "{}")); err == nil {
+			t.Fatal("got no error after disallowing the location")
+		}
+		d.OverrideLocation("name", LocationQuery)
+		var dst request
+		if err := d.Decode(&dst, newRequest("POST", "/?name=a", "application/json", "{}")); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Name != "a" {
+			t.Errorf("got %q, want %q", dst.Name, "a")
+		}
+	})
+}
+
+func TestDisallowLocationClone(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+		Page int    `query:"page"`
+	}
+	d := NewDecoder()
+	d.DisallowLocation(LocationJSON)
+	// The clone shares the cached struct information, which is yet to be
+	// checked against the disallowed locations.
+	if err := d.Check(&request{}); err != nil {
+		t.Fatal(err)
+	}
+	c := d.Clone()
+	want := DisallowedLocationError{Key: "name", Locations: []int{LocationJSON}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var dst request
+				if err := d.Decode(&dst, newRequest("POST", "/?page=1", "application/json", `{"name":"a"}`)); !reflect.DeepEqual(err, want) {
+					t.Errorf("got error %v from the decoder, want %v", err, want)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var dst request
+				if err := c.Decode(&dst, newRequest("POST", "/?page=1", "application/json", `{"name":"a"}`)); !reflect.DeepEqual(err, want) {
+					t.Errorf("got error %v from the clone, want %v", err, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestQuerySeparator(t *testing.T) {
+	type request struct {
+		A    int      `query:"a"`
+		B    int      `query:"b"`
+		Tags []string `query:"tags"`
+		Pin  string   `query:"pin" secret:"true"`
+	}
+	tests := []struct {
+		name    string
+		sep     rune
+		target  string
+		want    request
+		wantKey string
+		wantErr ConversionError
+	}{
+		{name: "default", target: "/?a=1&b=2", want: request{A: 1, B: 2}},
+		{name: "semicolon", sep: ';', target: "/?a=1;b=2", want: request{A: 1, B: 2}},
+		{name: "mixed", sep: ';', target: "/?a=1;b=2&tags=x;tags=y", want: request{A: 1, B: 2, Tags: []string{"x", "y"}}},
+		{name: "escaped", sep: ';', target: "/?tags=x%3By;tags=a+b", want: request{Tags: []string{"x;y", "a b"}}},
+		{name: "empty pairs", sep: ';', target: "/?;a=1;;&b=2&", want: request{A: 1, B: 2}},
+		{name: "bad value", sep: ';', target: "/?a=1;b=%zz", wantKey: "b", wantErr: ConversionError{Key: "b", Type: reflect.TypeOf(0), Index: -1, Value: "%zz"}},
+		{name: "bad secret", sep: ';', target: "/?pin=%zz", wantKey: "pin", wantErr: ConversionError{Key: "pin", Type: reflect.TypeOf(""), Index: -1}},
+		{name: "bad key", sep: ';', target: "/?a%zz=1", wantKey: "a%zz", wantErr: ConversionError{Key: "a%zz", Index: -1, Value: "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.QuerySeparator(tt.sep)
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantKey != "" {
+				e, ok := keyError(err, tt.wantKey).(ConversionError)
+				if !ok {
+					t.Fatalf("got %v, want a conversion error", err)
+				}
+				if e.Err == nil {
+					t.Error("got no unescaping error")
+				}
+				e.Err = nil
+				if !reflect.DeepEqual(e, tt.wantErr) {
+					t.Errorf("got %#v, want %#v", e, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+// slowBody returns one byte of its body per read, after a delay.
+type slowBody struct {
+	body  string
+	delay time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if len(b.body) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(b.delay)
+	n := copy(p[:1], b.body)
+	b.body = b.body[n:]
+	return n, nil
+}
+
+func (b *slowBody) Close() error {
+	return nil
+}
+
+func TestReadTimeout(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name    string
+		stream  bool
+		body    string
+		delay   time.Duration
+		timeout time.Duration
+		wantErr bool
+	}{
+		{name: "in time", body: `{"name":"a"}`, timeout: time.Second},
+		{name: "whole body", body: `{"name":"abcdefghijklmnop"}`, delay: 5 * time.Millisecond, timeout: 50 * time.Millisecond, wantErr: true},
+		{name: "blocked read", body: `{"name":"a"}`, delay: time.Second, timeout: 20 * time.Millisecond, wantErr: true},
+		{name: "stream in time", stream: true, body: `[{"name":"a"}]`, timeout: time.Second},
+		{name: "stream whole body", stream: true, body: `[{"name":"abcdefghijklmnop"}]`, delay: 5 * time.Millisecond, timeout: 50 * time.Millisecond, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.ReadTimeout(tt.timeout)
+			r := newRequest("POST", "/", "application/json", "")
+			body := &slowBody{body: tt.body, delay: tt.delay}
+			r.Body = body
+			var err error
+			if tt.stream {
+				err = d.DecodeStream(r, request{}, func(interface{}) error { return nil })
+			} else {
+				err = d.Decode(&request{}, r)
+			}
+			if tt.wantErr {
+				if e, ok := err.(TimeoutError); !ok || e.Timeout != tt.timeout {
+					t.Errorf("got %v, want a TimeoutError", err)
+				}
+				if _, ok := r.Body.(expiredBody); !ok {
+					t.Errorf("got body %T, want an expired body", r.Body)
+				}
+				return
+			}
+			if r.Body != body {
+				t.Error("the body of the request was replaced")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// blockingBody is a body which reads block until released.
+type blockingBody struct {
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	<-b.release
+	return copy(p, "x"), nil
+}
+
+func (b *blockingBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestReadTimeoutAbandonedRead(t *testing.T) {
+	type request struct {
+		Name string `json:"name"`
+	}
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry run %v", dryRun), func(t *testing.T) {
+			d := NewDecoder()
+			d.ReadTimeout(10 * time.Millisecond)
+			body := &blockingBody{release: make(chan struct{}), closed: make(chan struct{})}
+			r := newRequest("POST", "/", "application/json", "")
+			r.Body = body
+			var err error
+			if dryRun {
+				err = d.DryRun(&request{}, r)
+			} else {
+				err = d.Decode(&request{}, r)
+			}
+			if _, ok := err.(TimeoutError); !ok {
+				t.Fatalf("got %v, want a TimeoutError", err)
+			}
+			// The abandoned read returns while the body is read again.
+			close(body.release)
+			if _, err := ioutil.ReadAll(r.Body); err == nil {
+				t.Error("got the body read after the timeout")
+			}
+			if !dryRun {
+				select {
+				case <-body.closed:
+				case <-time.After(time.Second):
+					t.Error("the body was not closed after the abandoned read")
+				}
+			}
+		})
+	}
+}
+
+func TestRequireContentType(t *testing.T) {
+	type jsonRequest struct {
+		Name string `json:"name"`
+	}
+	type mixedRequest struct {
+		Page int    `query:"page"`
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		off         bool
+		ok          bool
+	}{
+		{name: "json", contentType: "application/json", ok: true},
+		{name: "parameters", contentType: "application/json; charset=utf-8", ok: true},
+		{name: "case", contentType: "Application/JSON;charset=UTF-8", ok: true},
+		{name: "other", contentType: "text/plain"},
+		{name: "prefix", contentType: "application/jsonp"},
+		{name: "malformed", contentType: "application/json; charset"},
+		{name: "off", contentType: "text/plain", off: true, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.RequireContentType(!tt.off)
+			want := error(ContentTypeError{RequestContentType: tt.contentType, ContentType: "application/json"})
+			if tt.ok {
+				want = nil
+			}
+			for _, dst := range []interface{}{&jsonRequest{}, &mixedRequest{}} {
+				err := d.Decode(dst, newRequest("POST", "/", tt.contentType, `{"name":"a"}`))
+				if !reflect.DeepEqual(err, want) {
+					t.Errorf("%T: got error %v, want %v", dst, err, want)
+				}
+			}
+			err := d.DecodeStream(newRequest("POST", "/", tt.contentType, `[{"name":"a"}]`), jsonRequest{}, func(interface{}) error { return nil })
+			if !reflect.DeepEqual(err, want) {
+				t.Errorf("stream: got error %v, want %v", err, want)
+			}
+		})
+	}
+}
+
+func TestRequireContentTypeHeader(t *testing.T) {
+	type jsonRequest struct {
+		Name string `json:"name"`
+	}
+	type formRequest struct {
+		Name string `form:"name"`
+	}
+	type fileRequest struct {
+		Avatar *multipart.FileHeader `file:"avatar"`
+	}
+	type queryRequest struct {
+		Page int `query:"page"`
+	}
+	tests := []struct {
+		name        string
+		method      string
+		dst         interface{}
+		contentType string
+		body        string
+		off         bool
+		allowEmpty  bool
+		want        error
+	}{
+		{name: "json", dst: &jsonRequest{}, body: `{"name":"a"}`, want: ContentTypeError{ContentType: "application/json"}},
+		{name: "form", dst: &formRequest{}, body: "name=a", want: ContentTypeError{ContentType: "application/x-www-form-urlencoded"}},
+		{name: "file", dst: &fileRequest{}, body: "--x--", want: ContentTypeError{ContentType: "multipart/form-data"}},
+		{name: "put", method: "PUT", dst: &jsonRequest{}, body: `{"name":"a"}`, want: ContentTypeError{ContentType: "application/json"}},
+		{name: "patch", method: "PATCH", dst: &jsonRequest{}, body: `{"name":"a"}`, want: ContentTypeError{ContentType: "application/json"}},
+		{name: "with header", dst: &jsonRequest{}, contentType: "application/json", body: `{"name":"a"}`},
+		{name: "empty body", dst: &jsonRequest{}, allowEmpty: true},
+		{name: "no body fields", dst: &queryRequest{}, body: `{"name":"a"}`},
+		{name: "get", method: "GET", dst: &jsonRequest{}, body: `{"name":"a"}`},
+		{name: "off", dst: &jsonRequest{}, body: `{"name":"a"}`, off: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.RequireContentTypeHeader(!tt.off)
+			d.AllowEmptyBody(tt.allowEmpty)
+			method := tt.method
+			if method == "" {
+				method = "POST"
+			}
+			err := d.Decode(tt.dst, newRequest(method, "/", tt.contentType, tt.body))
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMasked(t *testing.T) {
+	type address struct {
+		City   string `json:"city"`
+		Street string `json:"street"`
+	}
+	type request struct {
+		Name    string   `json:"name"`
+		Email   string   `json:"email"`
+		Address address  `json:"address"`
+		Tags    []string `query:"tags"`
+		Page    int      `query:"page"`
+	}
+	original := request{Name: "n", Email: "e", Address: address{City: "c", Street: "s"}, Tags: []string{"t"}, Page: 1}
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		mask    []string
+		unknown bool
+		want    request
+		wantErr error
+	}{
+		{
+			name: "json fields",
+			body: `{"name":"a","email":"b"}`,
+			mask: []string{"name"},
+			want: request{Name: "a", Email: "e", Address: address{City: "c", Street: "s"}, Tags: []string{"t"}, Page: 1},
+		},
+		{
+			name: "nested path",
+			body: `{"address":{"city":"x","street":"y"}}`,
+			mask: []string{"address.city"},
+			want: request{Name: "n", Email: "e", Address: address{City: "x", Street: "s"}, Tags: []string{"t"}, Page: 1},
+		},
+		{
+			name: "parent covers nested",
+			body: `{"name":"a","address":{"city":"x","street":"y"}}`,
+			mask: []string{"address"},
+			want: request{Name: "n", Email: "e", Address: address{City: "x", Street: "y"}, Tags: []string{"t"}, Page: 1},
+		},
+		{
+			name:   "query",
+			target: "/?tags=a&tags=b&page=2",
+			mask:   []string{"tags"},
+			want:   request{Name: "n", Email: "e", Address: address{City: "c", Street: "s"}, Tags: []string{"a", "b"}, Page: 1},
+		},
+		{
+			name:   "case insensitive",
+			target: "/?PAGE=2",
+			mask:   []string{"page"},
+			want:   request{Name: "n", Email: "e", Address: address{City: "c", Street: "s"}, Tags: []string{"t"}, Page: 2},
+		},
+		{
+			name: "empty mask",
+			body: `{"name":"a"}`,
+			mask: []string{},
+			want: original,
+		},
+		{
+			name:    "unknown keys outside the mask",
+			target:  "/?other=1",
+			body:    `{"name":"a","extra":1}`,
+			mask:    []string{"name"},
+			unknown: true,
+			want:    request{Name: "a", Email: "e", Address: address{City: "c", Street: "s"}, Tags: []string{"t"}, Page: 1},
+		},
+		{
+			name:    "unknown keys in the mask",
+			body:    `{"extra":1}`,
+			mask:    []string{"extra"},
+			unknown: true,
+			wantErr: UnknownKeyError{Key: "extra"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.IgnoreUnknownKeys(!tt.unknown)
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			dst := original
+			dst.Tags = append([]string(nil), original.Tags...)
+			err := d.DecodeMasked(&dst, newRequest("PATCH", target, "application/json", body), tt.mask)
+			if tt.wantErr != nil {
+				if got := keyError(err, "extra"); !reflect.DeepEqual(got, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetAllocator(t *testing.T) {
+	type profile struct {
+		Bio string `query:"bio"`
+	}
+	type request struct {
+		Profile *profile `query:"profile"`
+		Age     *int     `query:"age"`
+		IDs     []*int   `query:"ids"`
+		Levels  []*level `query:"levels"`
+	}
+	tests := []struct {
+		name      string
+		target    string
+		zeroEmpty bool
+		want      map[reflect.Type]int
+	}{
+		{name: "nested struct", target: "/?profile.bio=a", want: map[reflect.Type]int{reflect.TypeOf(profile{}): 1}},
+		{name: "scalar pointer", target: "/?age=1", want: map[reflect.Type]int{reflect.TypeOf(0): 1}},
+		{name: "slice elements", target: "/?ids=1&ids=2", want: map[reflect.Type]int{reflect.TypeOf(0): 2}},
+		{name: "empty slice elements", target: "/?ids=1&ids=", zeroEmpty: true, want: map[reflect.Type]int{reflect.TypeOf(0): 2}},
+		{name: "text unmarshaler elements", target: "/?levels=low&levels=high", want: map[reflect.Type]int{reflect.TypeOf(level(0)): 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[reflect.Type]int{}
+			d := NewDecoder()
+			d.ZeroEmpty(tt.zeroEmpty)
+			d.SetAllocator(func(t reflect.Type) reflect.Value {
+				got[t]++
+				return reflect.New(t)
+			})
+			var dst request
+			if err := d.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got allocations %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("raw json and body", func(t *testing.T) {
+		type bodyRequest struct {
+			Raw  *json.RawMessage `json:"raw"`
+			Body *string          `name:"body" from:"body"`
+			Name string           `json:"name"`
+		}
+		got := map[reflect.Type]int{}
+		d := NewDecoder()
+		d.SetAllocator(func(t reflect.Type) reflect.Value {
+			got[t]++
+			return reflect.New(t)
+		})
+		var dst bodyRequest
+		body := `{"raw":[1],"name":"a"}`
+		if err := d.Decode(&dst, newRequest("POST", "/", "application/json", body)); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Raw == nil || string(*dst.Raw) != "[1]" || dst.Body == nil || *dst.Body != body || dst.Name != "a" {
+			t.Errorf("unexpected result %+v", dst)
+		}
+		if want := (map[reflect.Type]int{reflect.TypeOf(json.RawMessage{}): 1, reflect.TypeOf(""): 1}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got allocations %v, want %v", got, want)
+		}
+	})
+	t.Run("direct json", func(t *testing.T) {
+		type jsonProfile struct {
+			Bio string `json:"bio"`
+		}
+		type directRequest struct {
+			ID      string       `query:"id"`
+			Profile *jsonProfile `json:"profile"`
+		}
+		got := map[reflect.Type]int{}
+		d := NewDecoder()
+		d.DirectJSON(true)
+		d.SetAllocator(func(t reflect.Type) reflect.Value {
+			got[t]++
+			return reflect.New(t)
+		})
+		var dst directRequest
+		if err := d.Decode(&dst, newRequest("POST", "/?id=1", "application/json", `{"profile":{"bio":"a"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Profile == nil || dst.Profile.Bio != "a" {
+			t.Errorf("unexpected result %+v", dst)
+		}
+		if want := (map[reflect.Type]int{reflect.TypeOf(jsonProfile{}): 1}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got allocations %v, want %v", got, want)
+		}
+	})
+	t.Run("pooled values", func(t *testing.T) {
+		pooled := &profile{}
+		d := NewDecoder()
+		d.SetAllocator(func(t reflect.Type) reflect.Value {
+			if t == reflect.TypeOf(profile{}) {
+				return reflect.ValueOf(pooled)
+			}
+			return reflect.New(t)
+		})
+		var dst request
+		if err := d.Decode(&dst, newRequest("GET", "/?profile.bio=a", "", "")); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Profile != pooled || pooled.Bio != "a" {
+			t.Errorf("got %+v, want the pooled profile", dst.Profile)
+		}
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		d := NewDecoder()
+		d.SetAllocator(func(t reflect.Type) reflect.Value { return reflect.Value{} })
+		var dst request
+		if err := d.Decode(&dst, newRequest("GET", "/?profile.bio=a&ids=1", "", "")); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Profile == nil || dst.Profile.Bio != "a" || len(dst.IDs) != 1 || *dst.IDs[0] != 1 {
+			t.Errorf("unexpected result %+v", dst)
+		}
+	})
+	t.Run("wrong type", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r != "reqtruct: allocator returned a *string for int" {
+				t.Errorf("got panic %v", r)
+			}
+		}()
+		d := NewDecoder()
+		d.SetAllocator(func(t reflect.Type) reflect.Value { return reflect.New(reflect.TypeOf("")) })
+		var dst request
+		d.Decode(&dst, newRequest("GET", "/?age=1", "", ""))
+	})
+}
+
+func BenchmarkSetAllocator(b *testing.B) {
+	type address struct {
+		City   string `json:"city"`
+		Street string `json:"street"`
+	}
+	type request struct {
+		Address   *address   `json:"address"`
+		Addresses []*address `json:"addresses"`
+		Scores    []*int     `json:"scores"`
+		Page      int        `query:"page"`
+	}
+	const body = `{"address":{"city":"a","street":"b"},"addresses":[{"city":"c"},{"city":"d"}],"scores":[1,2,3]}`
+	pools := map[reflect.Type]*sync.Pool{}
+	for _, t := range []reflect.Type{reflect.TypeOf(address{}), reflect.TypeOf(0)} {
+		t := t
+		pools[t] = &sync.Pool{New: func() interface{} { return reflect.New(t).Interface() }}
+	}
+	// release puts the pointers of the request back in their pools.
+	release := func(r *request) {
+		put := func(p interface{}) {
+			v := reflect.ValueOf(p)
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+			pools[v.Elem().Type()].Put(p)
+		}
+		if r.Address != nil {
+			put(r.Address)
+		}
+		for _, a := range r.Addresses {
+			put(a)
+		}
+		for _, s := range r.Scores {
+			put(s)
+		}
+		*r = request{}
+	}
+	for _, pooled := range []bool{false, true} {
+		name := "default"
+		if pooled {
+			name = "pooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			d := NewDecoder()
+			if pooled {
+				d.SetAllocator(func(t reflect.Type) reflect.Value {
+					if p, ok := pools[t]; ok {
+						return reflect.ValueOf(p.Get())
+					}
+					return reflect.Value{}
+				})
+			}
+			var dst request
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := d.Decode(&dst, newRequest("POST", "/?page=1", "application/json", body)); err != nil {
+					b.Fatal(err)
+				}
+				if pooled {
+					release(&dst)
+				} else {
+					dst = request{}
+				}
+			}
+		})
+	}
+}
+
+func TestCaseSensitiveJSON(t *testing.T) {
+	type user struct {
+		UserName string `json:"username"`
+	}
+	type request struct {
+		UserName string `json:"username"`
+		User     user   `json:"user"`
+		Page     int    `query:"page"`
+	}
+	tests := []struct {
+		name      string
+		sensitive bool
+		direct    bool
+		target    string
+		body      string
+		want      request
+		wantErr   string
+	}{
+		{name: "exact", sensitive: true, body: `{"username":"a","user":{"username":"b"}}`, want: request{UserName: "a", User: user{UserName: "b"}}},
+		{name: "top level", sensitive: true, body: `{"UserName":"a"}`, wantErr: "UserName"},
+		{name: "nested", sensitive: true, body: `{"user":{"UserName":"b"}}`, wantErr: "user.UserName"},
+		{name: "nested parent", sensitive: true, body: `{"User":{"username":"b"}}`, wantErr: "User"},
+		{name: "direct json", sensitive: true, direct: true, body: `{"user":{"UserName":"b"}}`, wantErr: "user.UserName"},
+		{name: "other locations", sensitive: true, target: "/?PAGE=2", want: request{Page: 2}},
+		{name: "nested folded", body: `{"user":{"UserName":"b"}}`, want: request{User: user{UserName: "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.CaseSensitiveJSON(tt.sensitive)
+			d.DirectJSON(tt.direct)
+			d.IgnoreUnknownKeys(false)
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			var dst request
+			err := d.Decode(&dst, newRequest("POST", target, "application/json", body))
+			if tt.wantErr != "" {
+				if _, ok := keyError(err, tt.wantErr).(UnknownKeyError); !ok {
+					t.Errorf("got error %v, want an UnknownKeyError for %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"net/http"
+	"sync"
+)
+
+var (
+	defaultDecoder     *Decoder
+	defaultDecoderLock sync.RWMutex
+)
+
+// Default returns the decoder used by the package level Decode.
+// It is created on first use with the settings of NewDecoder,
+// unless another decoder was set with SetDefault.
+func Default() *Decoder {
+	defaultDecoderLock.RLock()
+	d := defaultDecoder
+	defaultDecoderLock.RUnlock()
+	if d != nil {
+		return d
+	}
+	defaultDecoderLock.Lock()
+	defer defaultDecoderLock.Unlock()
+	if defaultDecoder == nil {
+		defaultDecoder = NewDecoder()
+	}
+	return defaultDecoder
+}
+
+// SetDefault sets the decoder used by the package level Decode.
+// It is meant to be called at startup to customize the default decoder.
+// It panics if d is nil.
+func SetDefault(d *Decoder) {
+	if d == nil {
+		panic("reqtruct: SetDefault called with a nil decoder")
+	}
+	defaultDecoderLock.Lock()
+	defaultDecoder = d
+	defaultDecoderLock.Unlock()
+}
+
+// Decode decodes a *http.Request to a struct using the default decoder.
+func Decode(dst interface{}, r *http.Request) error {
+	return Default().Decode(dst, r)
+}
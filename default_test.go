@@ -0,0 +1,63 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	var wg sync.WaitGroup
+	decoders := make([]*Decoder, 10)
+	for i := range decoders {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			decoders[i] = Default()
+		}(i)
+	}
+	wg.Wait()
+	for _, d := range decoders {
+		if d == nil || d != decoders[0] {
+			t.Fatalf("got decoders %v, want the same decoder", decoders)
+		}
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	old := Default()
+	defer SetDefault(old)
+
+	type request struct {
+		Name string
+	}
+	d := NewDecoder()
+	d.DefaultLocation(LocationQuery)
+	SetDefault(d)
+	if Default() != d {
+		t.Fatal("Default does not return the decoder set by SetDefault")
+	}
+	var dst request
+	if err := Decode(&dst, newRequest("GET", "/?Name=a", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Name != "a" {
+		t.Errorf("got %q, want a", dst.Name)
+	}
+}
+
+func TestSetDefaultNil(t *testing.T) {
+	old := Default()
+	defer func() {
+		if recover() == nil {
+			t.Error("SetDefault(nil) did not panic")
+		}
+		if Default() != old {
+			t.Error("SetDefault(nil) replaced the default decoder")
+		}
+	}()
+	SetDefault(nil)
+}
@@ -6,8 +6,12 @@
 package reqtruct
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 type LocationError struct {
@@ -20,6 +24,25 @@ func (e LocationError) Error() string {
 	return fmt.Sprintf("%q param sent in %s instead of %s", e.Key, locationToName(e.Location), locationsToNames(e.AllowedLocations))
 }
 
+func (e LocationError) MarshalJSON() ([]byte, error) {
+	return marshalError("location", e)
+}
+
+// DisallowedLocationError is returned when a field can only be decoded
+// from locations disallowed by the decoder.
+type DisallowedLocationError struct {
+	Key       string // alias of the field.
+	Locations []int  // locations of the field.
+}
+
+func (e DisallowedLocationError) Error() string {
+	return fmt.Sprintf("%q param can only be sent in %s, which is disallowed", e.Key, locationsToNames(e.Locations))
+}
+
+func (e DisallowedLocationError) MarshalJSON() ([]byte, error) {
+	return marshalError("disallowed_location", e)
+}
+
 type ContentTypeError struct {
 	ContentType        string
 	RequestContentType string
@@ -29,6 +52,88 @@ func (e ContentTypeError) Error() string {
 	return fmt.Sprintf("Content-Type should be %q instead of %q", e.ContentType, e.RequestContentType)
 }
 
+func (e ContentTypeError) MarshalJSON() ([]byte, error) {
+	return marshalError("content_type", e)
+}
+
+// NotMultipartError is returned when the request body is expected
+// to be a multipart form, because the struct has file fields, but the
+// Content-Type of the request is not multipart/form-data.
+type NotMultipartError struct {
+	RequestContentType string
+}
+
+func (e NotMultipartError) Error() string {
+	return fmt.Sprintf("request is not a multipart form, Content-Type is %q", e.RequestContentType)
+}
+
+func (e NotMultipartError) MarshalJSON() ([]byte, error) {
+	return marshalError("not_multipart", e)
+}
+
+// MalformedMultipartError is returned when the multipart form
+// in the request body cannot be read.
+type MalformedMultipartError struct {
+	Err error
+}
+
+func (e MalformedMultipartError) Unwrap() error {
+	return e.Err
+}
+
+func (e MalformedMultipartError) Error() string {
+	return fmt.Sprintf("malformed multipart form. Details: %s", e.Err)
+}
+
+func (e MalformedMultipartError) MarshalJSON() ([]byte, error) {
+	return marshalError("malformed_multipart", e)
+}
+
+// JSONShapeError is returned when the JSON body is not of the expected kind,
+// like an array sent where an object is expected.
+// It is wrapped in a ConversionError when the value of a field is not,
+// like an array sent for a nested struct.
+type JSONShapeError struct {
+	Expected string
+	Got      string
+}
+
+func (e JSONShapeError) Error() string {
+	return fmt.Sprintf("expected JSON %s, got %s", e.Expected, e.Got)
+}
+
+func (e JSONShapeError) MarshalJSON() ([]byte, error) {
+	return marshalError("json_shape", e)
+}
+
+// BodyTooLargeError is returned when the request body is larger than
+// the max body size of the decoder.
+type BodyTooLargeError struct {
+	Limit int64 // max body size in bytes.
+}
+
+func (e BodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds the limit of %d bytes", e.Limit)
+}
+
+func (e BodyTooLargeError) MarshalJSON() ([]byte, error) {
+	return marshalError("body_too_large", e)
+}
+
+// TimeoutError is returned when reading the request body takes longer
+// than the read timeout of the decoder.
+type TimeoutError struct {
+	Timeout time.Duration // read timeout.
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("reading request body exceeded the timeout of %s", e.Timeout)
+}
+
+func (e TimeoutError) MarshalJSON() ([]byte, error) {
+	return marshalError("timeout", e)
+}
+
 type ParsingError struct {
 	WrappedErr error
 	Err        error
@@ -46,11 +151,16 @@ func (e ParsingError) Error() string {
 
 }
 
+func (e ParsingError) MarshalJSON() ([]byte, error) {
+	return marshalError("parsing", e)
+}
+
 // ConversionError stores information about a failed conversion.
 type ConversionError struct {
 	Key   string       // key from the source map.
 	Type  reflect.Type // expected type of elem
 	Index int          // index for multi-value fields; -1 for single-value fields.
+	Value string       // value that failed to convert; empty for secret fields.
 	Err   error        // low-level error (when it exists)
 }
 
@@ -60,12 +170,17 @@ func (e ConversionError) Unwrap() error {
 
 func (e ConversionError) Error() string {
 	var output string
+	var value string
+
+	if e.Value != "" {
+		value = fmt.Sprintf(" %q", truncate(e.Value, maxErrorValueLen))
+	}
 
 	if e.Index < 0 {
-		output = fmt.Sprintf("error converting value for %q", e.Key)
+		output = fmt.Sprintf("error converting value%s for %q", value, e.Key)
 	} else {
-		output = fmt.Sprintf("error converting value for index %d of %q",
-			e.Index, e.Key)
+		output = fmt.Sprintf("error converting value%s for index %d of %q",
+			value, e.Index, e.Key)
 	}
 
 	if e.Err != nil {
@@ -75,15 +190,272 @@ func (e ConversionError) Error() string {
 	return output
 }
 
+func (e ConversionError) MarshalJSON() ([]byte, error) {
+	return marshalError("conversion", e)
+}
+
+// maxErrorValueLen is the max number of runes of a value shown in errors.
+const maxErrorValueLen = 64
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "..."
+	}
+	return s
+}
+
+// OverflowError is the low-level error of a ConversionError
+// when the value is a number out of the range of the type.
+type OverflowError struct {
+	Type reflect.Type // type of the value.
+}
+
+func (e OverflowError) Error() string {
+	return fmt.Sprintf("value overflows %v", e.Type)
+}
+
+func (e OverflowError) MarshalJSON() ([]byte, error) {
+	return marshalError("overflow", e)
+}
+
+// ArrayLengthError stores information about values not fitting in an array.
+type ArrayLengthError struct {
+	Key    string       // key from the source map.
+	Type   reflect.Type // expected type of elem
+	Length int          // length of the array.
+	Count  int          // number of values received.
+}
+
+func (e ArrayLengthError) Error() string {
+	return fmt.Sprintf("too many values for %q: got %d, array length is %d", e.Key, e.Count, e.Length)
+}
+
+func (e ArrayLengthError) MarshalJSON() ([]byte, error) {
+	return marshalError("array_length", e)
+}
+
+// ParallelArraysLengthError stores information about parallel arrays
+// of different lengths.
+type ParallelArraysLengthError struct {
+	Key      string // key from the source map.
+	Length   int    // number of values received for the key.
+	Expected int    // number of values received for the other keys.
+}
+
+func (e ParallelArraysLengthError) Error() string {
+	return fmt.Sprintf("expected %d values for %q, got %d", e.Expected, e.Key, e.Length)
+}
+
+func (e ParallelArraysLengthError) MarshalJSON() ([]byte, error) {
+	return marshalError("parallel_arrays_length", e)
+}
+
+// LimitExceededError is returned when a request exceeds one of the limits
+// set on the decoder.
+type LimitExceededError struct {
+	Key   string // key from the source map; empty for limits on the whole request.
+	Name  string // name of the limit.
+	Limit int64  // value of the limit.
+}
+
+func (e LimitExceededError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("%q exceeds the %s limit of %d", e.Key, e.Name, e.Limit)
+	}
+	return fmt.Sprintf("request exceeds the %s limit of %d", e.Name, e.Limit)
+}
+
+func (e LimitExceededError) MarshalJSON() ([]byte, error) {
+	return marshalError("limit_exceeded", e)
+}
+
+// DuplicateAliasError is returned when more than one field of a struct
+// have the same alias in the same location.
+type DuplicateAliasError struct {
+	Type     reflect.Type // type of the struct.
+	Alias    string       // duplicated alias.
+	Location int          // location of the duplicated alias.
+	Fields   []string     // names of the fields having the alias.
+}
+
+func (e DuplicateAliasError) Error() string {
+	return fmt.Sprintf("fields %s of %v have the same alias %q in %s", strings.Join(e.Fields, ", "), e.Type, e.Alias, locationToName(e.Location))
+}
+
+func (e DuplicateAliasError) MarshalJSON() ([]byte, error) {
+	return marshalError("duplicate_alias", e)
+}
+
+// UnknownLocationError is returned by Check when the from tag
+// of a field has a location which does not exist.
+type UnknownLocationError struct {
+	Type     reflect.Type // type of the struct.
+	Field    string       // name of the field.
+	Location string       // unknown location.
+}
+
+func (e UnknownLocationError) Error() string {
+	return fmt.Sprintf("field %s of %v has unknown location %q in its from tag", e.Field, e.Type, e.Location)
+}
+
+func (e UnknownLocationError) MarshalJSON() ([]byte, error) {
+	return marshalError("unknown_location", e)
+}
+
+// UnknownEncodingError is returned by Check when the encoding tag
+// of a field has an encoding which does not exist.
+type UnknownEncodingError struct {
+	Type     reflect.Type // type of the struct.
+	Field    string       // name of the field.
+	Encoding string       // unknown encoding.
+}
+
+func (e UnknownEncodingError) Error() string {
+	return fmt.Sprintf("field %s of %v has unknown encoding %q in its encoding tag", e.Field, e.Type, e.Encoding)
+}
+
+func (e UnknownEncodingError) MarshalJSON() ([]byte, error) {
+	return marshalError("unknown_encoding", e)
+}
+
+// NoFieldsError is returned by Check when a struct has no fields
+// which can be decoded.
+type NoFieldsError struct {
+	Type    reflect.Type // type of the struct.
+	Skipped []string     // names of the fields skipped for having unsupported types.
+}
+
+func (e NoFieldsError) Error() string {
+	if len(e.Skipped) > 0 {
+		return fmt.Sprintf("%v has no fields to decode, skipped fields with unsupported types: %s", e.Type, strings.Join(e.Skipped, ", "))
+	}
+	return fmt.Sprintf("%v has no fields to decode", e.Type)
+}
+
+func (e NoFieldsError) MarshalJSON() ([]byte, error) {
+	return marshalError("no_fields", e)
+}
+
+// DecodeRequestError wraps an error returned by a RequestDecoder.
+type DecodeRequestError struct {
+	Key string // alias of the field; empty for the top level struct.
+	Err error
+}
+
+func (e DecodeRequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e DecodeRequestError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("error decoding request for %q. Details: %s", e.Key, e.Err)
+	}
+	return fmt.Sprintf("error decoding request. Details: %s", e.Err)
+}
+
+func (e DecodeRequestError) MarshalJSON() ([]byte, error) {
+	return marshalError("decode_request", e)
+}
+
+// EnumError is returned when the value of a param is not one of
+// the values allowed by the enum tag of its field.
+// Values are matched ignoring case, unless they are from JSON bodies
+// and CaseSensitiveJSON is set, and fields are set to the spelling of
+// the allowed value they match.
+type EnumError struct {
+	Key     string   // key from the source map.
+	Value   string   // value of the param; empty for secret fields.
+	Allowed []string // values allowed for the field.
+}
+
+func (e EnumError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("value for %q must be one of %s", e.Key, strings.Join(e.Allowed, ", "))
+	}
+	return fmt.Sprintf("value %q for %q must be one of %s", truncate(e.Value, maxErrorValueLen), e.Key, strings.Join(e.Allowed, ", "))
+}
+
+func (e EnumError) MarshalJSON() ([]byte, error) {
+	return marshalError("enum", e)
+}
+
+// MethodError is returned when a param is sent for a field
+// which is not accepted for the request method.
+type MethodError struct {
+	Key            string   // key from the source map.
+	Method         string   // method of the request.
+	AllowedMethods []string // methods the field is accepted for.
+}
+
+func (e MethodError) Error() string {
+	return fmt.Sprintf("%q param is not accepted for %s, only for %s", e.Key, e.Method, strings.Join(e.AllowedMethods, ", "))
+}
+
+func (e MethodError) MarshalJSON() ([]byte, error) {
+	return marshalError("method", e)
+}
+
+// MissingKeyError is returned when a required param is missing.
+type MissingKeyError struct {
+	Key       string // alias of the field.
+	Condition string // condition making the field required, like "type=business".
+}
+
+func (e MissingKeyError) Error() string {
+	return fmt.Sprintf("%q param is required when %s", e.Key, e.Condition)
+}
+
+func (e MissingKeyError) MarshalJSON() ([]byte, error) {
+	return marshalError("missing_key", e)
+}
+
+// ExclusiveGroupError is returned when more than one of the fields of
+// a group of mutually exclusive fields is sent, or none of them is sent
+// for a required group.
+type ExclusiveGroupError struct {
+	Group       string   // name of the group.
+	Keys        []string // aliases of the fields of the group.
+	PresentKeys []string // aliases of the fields of the group sent in the request.
+}
+
+func (e ExclusiveGroupError) Error() string {
+	if len(e.PresentKeys) == 0 {
+		return fmt.Sprintf("one of %s params is required", strings.Join(e.Keys, ", "))
+	}
+	return fmt.Sprintf("only one of %s params can be sent, got %s", strings.Join(e.Keys, ", "), strings.Join(e.PresentKeys, ", "))
+}
+
+func (e ExclusiveGroupError) MarshalJSON() ([]byte, error) {
+	return marshalError("exclusive_group", e)
+}
+
 // UnknownKeyError stores information about an unknown key in the source map.
 type UnknownKeyError struct {
-	Key string // key from the source map.
+	Key        string // key from the source map.
+	Suggestion string // closest valid key, when suggestions are enabled and one is found.
 }
 
 func (e UnknownKeyError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("invalid param %q, did you mean %q?", e.Key, e.Suggestion)
+	}
 	return fmt.Sprintf("invalid param %q", e.Key)
 }
 
+func (e UnknownKeyError) MarshalJSON() ([]byte, error) {
+	return marshalError("unknown_key", e)
+}
+
+// jsonError is the JSON shape of the errors.
+type jsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func marshalError(code string, err error) ([]byte, error) {
+	return json.Marshal(jsonError{Code: code, Message: err.Error()})
+}
+
 // MultiError stores multiple decoding errors.
 //
 // Borrowed from the App Engine SDK.
@@ -105,3 +477,41 @@ func (e MultiError) Error() string {
 	}
 	return fmt.Sprintf("%s (and %d other errors)", s, len(e)-1)
 }
+
+// MarshalJSON returns the errors in the shape
+// {"errors":{"key":{"code":"conversion","message":"..."}}}.
+// Errors of other packages get the "error" code.
+func (e MultiError) MarshalJSON() ([]byte, error) {
+	errs := make(map[string]json.RawMessage, len(e))
+	for k, err := range e {
+		var b []byte
+		var merr error
+		if m, ok := err.(json.Marshaler); ok {
+			b, merr = m.MarshalJSON()
+		} else {
+			b, merr = marshalError("error", err)
+		}
+		if merr != nil {
+			return nil, merr
+		}
+		errs[k] = b
+	}
+	return json.Marshal(struct {
+		Errors map[string]json.RawMessage `json:"errors"`
+	}{errs})
+}
+
+// FullError returns all the errors, one per line, sorted by key.
+func (e MultiError) FullError() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d errors:", len(e))
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%q: %s", k, e[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConversionErrorError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ConversionError
+		want string
+	}{
+		{
+			name: "single",
+			err:  ConversionError{Key: "age", Type: reflect.TypeOf(0), Index: -1, Value: "abc"},
+			want: `error converting value "abc" for "age"`,
+		},
+		{
+			name: "index",
+			err:  ConversionError{Key: "ids", Type: reflect.TypeOf(0), Index: 1, Value: "x"},
+			want: `error converting value "x" for index 1 of "ids"`,
+		},
+		{
+			name: "secret",
+			err:  ConversionError{Key: "pin", Type: reflect.TypeOf(0), Index: -1},
+			want: `error converting value for "pin"`,
+		},
+		{
+			name: "truncated",
+			err:  ConversionError{Key: "name", Type: reflect.TypeOf(0), Index: -1, Value: strings.Repeat("a", 100)},
+			want: `error converting value "` + strings.Repeat("a", maxErrorValueLen) + `..." for "name"`,
+		},
+		{
+			name: "details",
+			err:  ConversionError{Key: "age", Type: reflect.TypeOf(0), Index: -1, Value: "abc", Err: errors.New("bad")},
+			want: `error converting value "abc" for "age". Details: bad`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExclusiveGroupErrorError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ExclusiveGroupError
+		want string
+	}{
+		{
+			name: "none",
+			err:  ExclusiveGroupError{Group: "auth", Keys: []string{"token", "key"}},
+			want: "one of token, key params is required",
+		},
+		{
+			name: "many",
+			err:  ExclusiveGroupError{Group: "auth", Keys: []string{"token", "key", "session"}, PresentKeys: []string{"token", "session"}},
+			want: "only one of token, key, session params can be sent, got token, session",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiErrorFullError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  MultiError
+		want string
+	}{
+		{name: "empty", err: MultiError{}, want: "0 errors:"},
+		{name: "one", err: MultiError{"a": errors.New("bad a")}, want: "1 errors:\n\"a\": bad a"},
+		{name: "sorted", err: MultiError{"b": errors.New("bad b"), "a": errors.New("bad a"), "c": errors.New("bad c")}, want: "3 errors:\n\"a\": bad a\n\"b\": bad b\n\"c\": bad c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.FullError(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiErrorMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "conversion",
+			err:  MultiError{"age": ConversionError{Key: "age", Type: reflect.TypeOf(0), Index: -1, Value: "abc"}},
+			want: `{"errors":{"age":{"code":"conversion","message":"error converting value \"abc\" for \"age\""}}}`,
+		},
+		{
+			name: "mixed",
+			err: MultiError{
+				"name":  UnknownKeyError{Key: "name"},
+				"ids":   LocationError{Key: "ids", Location: LocationQuery, AllowedLocations: []int{LocationJSON}},
+				"other": errors.New("bad value"),
+			},
+			want: `{"errors":{` +
+				`"ids":{"code":"location","message":` + marshalMessage(LocationError{Key: "ids", Location: LocationQuery, AllowedLocations: []int{LocationJSON}}) + `},` +
+				`"name":{"code":"unknown_key","message":` + marshalMessage(UnknownKeyError{Key: "name"}) + `},` +
+				`"other":{"code":"error","message":"bad value"}}}`,
+		},
+		{
+			name: "content type",
+			err:  ContentTypeError{RequestContentType: "text/plain", ContentType: "application/json"},
+			want: `{"code":"content_type","message":` + marshalMessage(ContentTypeError{RequestContentType: "text/plain", ContentType: "application/json"}) + `}`,
+		},
+		{
+			name: "parsing",
+			err:  ParsingError{Err: errors.New("cannot parse form")},
+			want: `{"code":"parsing","message":` + marshalMessage(ParsingError{Err: errors.New("cannot parse form")}) + `}`,
+		},
+		{name: "empty", err: MultiError{}, want: `{"errors":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+// marshalMessage returns the message of err as a JSON string.
+func marshalMessage(err error) string {
+	b, _ := json.Marshal(err.Error())
+	return string(b)
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"reflect"
+)
+
+// FieldMeta describes how a struct field is decoded.
+type FieldMeta struct {
+	Name      string       // name of the field in the struct.
+	Alias     string       // alias of the param, prefixed by the aliases of its parents.
+	Locations []string     // names of the locations the param is accepted from.
+	Type      reflect.Type // type of the field.
+	IsSlice   bool         // whether the field is a slice or an array.
+	IsStruct  bool         // whether the field is a struct or a slice of structs decoded field by field.
+	IsFile    bool         // whether the field holds uploaded files.
+	IsSecret  bool         // whether the values of the field are left out of errors.
+	Fields    []FieldMeta  // fields of the struct, for struct fields.
+}
+
+// Fields returns the description of the fields of dst as seen by the decoder,
+// which is useful for generating documentation from request structs.
+//
+// The parameter must be a pointer to a struct, otherwise nil is returned.
+func (d *Decoder) Fields(dst interface{}) []FieldMeta {
+	t := reflect.TypeOf(dst)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return d.fields(t.Elem(), nil, true, map[reflect.Type]bool{})
+}
+
+// fields returns the description of the fields of the struct type t.
+// Overrides only apply to the top level fields.
+func (d *Decoder) fields(t reflect.Type, parent []string, top bool, visited map[reflect.Type]bool) []FieldMeta {
+	visited[t] = true
+	defer delete(visited, t)
+
+	var fields []FieldMeta
+	for _, f := range d.cache.get(t).fields {
+		if f.isAnonymous && f.alias == "" {
+			// Its fields are promoted to the struct.
+			continue
+		}
+		path := append(append([]string{}, parent...), f.alias)
+		locations := f.locations
+		if l, ok := d.override(f.alias); ok && top {
+			locations = []int{l}
+		}
+		ft := underlyingElem(f.typ)
+		k := indirectType(f.typ).Kind()
+		meta := FieldMeta{
+			Name:      f.name,
+			Alias:     d.separators.joinPath(path),
+			Locations: locationsToNames(locations),
+			Type:      f.typ,
+			IsSlice:   k == reflect.Slice || k == reflect.Array,
+			IsFile:    isFileType(ft),
+			IsSecret:  f.isSecret,
+		}
+		meta.IsStruct = d.cache.isNested(f)
+		if meta.IsStruct && !visited[ft] {
+			if f.isIndexed() {
+				path = append(path, "0")
+			}
+			meta.Fields = d.fields(ft, path, false, visited)
+		}
+		fields = append(fields, meta)
+	}
+	return fields
+}
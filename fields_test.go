@@ -0,0 +1,72 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"mime/multipart"
+	"reflect"
+	"testing"
+)
+
+type metaPaging struct {
+	Page int `query:"page"`
+}
+
+type metaItem struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty"`
+}
+
+type metaAddress struct {
+	City string `json:"city"`
+}
+
+type metaRequest struct {
+	metaPaging
+	Token   string                `header:"token" secret:"true"`
+	Address metaAddress           `json:"address"`
+	Items   []metaItem            `json:"items"`
+	Tags    []string              `query:"tags"`
+	Avatar  *multipart.FileHeader `file:"avatar"`
+}
+
+func TestFields(t *testing.T) {
+	want := []FieldMeta{
+		{Name: "Token", Alias: "token", Locations: []string{"header"}, Type: reflect.TypeOf(""), IsSecret: true},
+		{
+			Name:      "Address",
+			Alias:     "address",
+			Locations: []string{"json"},
+			Type:      reflect.TypeOf(metaAddress{}),
+			IsStruct:  true,
+			Fields: []FieldMeta{
+				{Name: "City", Alias: "address.city", Locations: []string{"json"}, Type: reflect.TypeOf("")},
+			},
+		},
+		{
+			Name:      "Items",
+			Alias:     "items",
+			Locations: []string{"json"},
+			Type:      reflect.TypeOf([]metaItem{}),
+			IsSlice:   true,
+			IsStruct:  true,
+			Fields: []FieldMeta{
+				{Name: "SKU", Alias: "items.0.sku", Locations: []string{"json"}, Type: reflect.TypeOf("")},
+				{Name: "Qty", Alias: "items.0.qty", Locations: []string{"json"}, Type: reflect.TypeOf(0)},
+			},
+		},
+		{Name: "Tags", Alias: "tags", Locations: []string{"query"}, Type: reflect.TypeOf([]string{}), IsSlice: true},
+		{Name: "Avatar", Alias: "avatar", Locations: []string{"file"}, Type: reflect.TypeOf(&multipart.FileHeader{}), IsFile: true},
+		// Fields of untagged embedded structs are promoted.
+		{Name: "Page", Alias: "page", Locations: []string{"query"}, Type: reflect.TypeOf(0)},
+	}
+	got := NewDecoder().Fields(&metaRequest{})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := NewDecoder().Fields(metaRequest{}); got != nil {
+		t.Errorf("got %+v for a struct value, want nil", got)
+	}
+}
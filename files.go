@@ -16,6 +16,14 @@ func isFileHeadersPtrs(t reflect.Type) bool {
 		t.Elem().Elem().Name() == "FileHeader"
 }
 
+// isFileHeadersMap reports whether t is a map of file headers
+// by form field name, like map[string][]*multipart.FileHeader.
+func isFileHeadersMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map &&
+		t.Key().Kind() == reflect.String &&
+		isFileHeadersPtrs(t.Elem())
+}
+
 func isFileHeaderPtr(t reflect.Type) bool {
 	return t.Kind() == reflect.Ptr &&
 		t.Elem().Kind() == reflect.Struct &&
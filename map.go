@@ -6,18 +6,28 @@
 package reqtruct
 
 import (
+	"bytes"
 	"encoding"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/facette/natsort"
 )
 
-func (d *Decoder) decodeMaps(t reflect.Type, v reflect.Value, srcM map[string][]string, srcF map[string][]*multipart.FileHeader, ps map[string][]pathPart, lens map[reflect.Value]map[int]int, errors MultiError) {
+func (d *Decoder) decodeMaps(t reflect.Type, v reflect.Value, srcM map[string][]string, srcF map[string][]*multipart.FileHeader, ps map[string][]pathPart, from map[string]keySource, lens map[reflect.Value]map[int]int, walked map[walkKey]reflect.Value, errors MultiError) {
 	keys := make([]string, len(srcM)+len(srcF))
 	i := 0
 	for k := range srcM {
@@ -36,14 +46,15 @@ func (d *Decoder) decodeMaps(t reflect.Type, v reflect.Value, srcM map[string][]
 			continue
 		}
 		if m, ok := srcM[path]; ok {
-			if err = d.decode(v, path, parts, m, nil, lens); err != nil {
+			src := from[d.sourceKey(path)]
+			if err = d.decode(v, path, src.location, src.list, parts, m, nil, lens, walked); err != nil {
 				errors[path] = err
 				if !d.collectErrors {
 					return
 				}
 			}
 		} else if fs, ok := srcF[path]; ok {
-			if err = d.decode(v, path, parts, nil, fs, lens); err != nil {
+			if err = d.decode(v, path, LocationFile, false, parts, nil, fs, lens, walked); err != nil {
 				errors[path] = err
 				if !d.collectErrors {
 					return
@@ -53,13 +64,13 @@ func (d *Decoder) decodeMaps(t reflect.Type, v reflect.Value, srcM map[string][]
 	}
 }
 
-func (d *Decoder) extractMap(info *structInfo, t reflect.Type, r *http.Request, ps map[string][]pathPart, errors MultiError) (map[string][]string, error) {
+func (d *Decoder) extractMap(info *structInfo, t reflect.Type, v reflect.Value, r *http.Request, pathParams map[string]string, ps map[string][]pathPart, from map[string]keySource, rest map[string]interface{}, errors MultiError) (map[string][]string, error) {
 	m := map[string][]string{}
 	var err error
-	if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
+	if (r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH") && !d.skipBody(r) {
 		if info.containsForm && (isURLEncodedForm(r) || isMultipartForm(r)) {
 			if info.containsFile {
-				d.merge(m, r.MultipartForm.Value, t, LocationForm, ps, errors)
+				d.merge(m, r.MultipartForm.Value, t, LocationForm, ps, from, rest, errors)
 				if !d.collectErrors && len(errors) > 0 {
 					return nil, nil
 				}
@@ -71,58 +82,132 @@ func (d *Decoder) extractMap(info *structInfo, t reflect.Type, r *http.Request,
 				if err != nil {
 					return nil, ParsingError{Err: fmt.Errorf("cannot parse form"), WrappedErr: err}
 				}
-				d.merge(m, r.PostForm, t, LocationForm, ps, errors)
+				d.merge(m, r.PostForm, t, LocationForm, ps, from, rest, errors)
 				if !d.collectErrors && len(errors) > 0 {
 					return nil, nil
 				}
 			}
 		} else if info.containsJSON && !isURLEncodedForm(r) && !isMultipartForm(r) {
-			mm := map[string]interface{}{}
-			dec := json.NewDecoder(r.Body)
-			err = dec.Decode(&mm)
-			if err != nil {
-				return nil, ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
+			codec, custom := d.bodyCodec(r)
+			if d.requireContentType && !custom && !isJSON(r) {
+				return nil, ContentTypeError{RequestContentType: r.Header.Get("Content-Type"), ContentType: "application/json"}
 			}
-		loop:
-			for k := range mm {
-				for _, alias := range info.fieldsJSON {
-					if k == alias {
-						continue loop
-					}
-				}
-				if !d.ignoreUnknownKeys {
-					errors[k] = UnknownKeyError{Key: k}
-					if !d.collectErrors {
-						return nil, nil
-					}
+			// The fields are decoded directly only when nothing about them
+			// needs more than encoding/json does, like Decode would.
+			if d.directJSON && !fieldsNeedDecoding(info) && !d.handlesFields() && !custom {
+				d.logf("reqtruct: decoding json body directly")
+				err = d.decodeJSON(info, v, r, rest, errors)
+			} else if info.containsRawJSON && !custom {
+				d.logf("reqtruct: decoding json body with raw json fields")
+				err = d.extractRawJSON(m, info, t, v, r, ps, from, rest, errors)
+			} else {
+				if custom {
+					d.logf("reqtruct: decoding body with the codec for %q", r.Header.Get("Content-Type"))
 				}
-				delete(mm, k)
+				err = d.extractJSON(m, info, t, r.Body, codec, ps, from, rest, errors)
+			}
+			if err != nil {
+				return nil, err
 			}
-			d.merge(m, flatten(mm), t, LocationJSON, ps, errors)
 			if !d.collectErrors && len(errors) > 0 {
 				return nil, nil
 			}
 		}
 	}
 	if info.containsHeader {
-		d.merge(m, r.Header, t, LocationHeader, ps, errors)
+		h := headerValues(r.Header, info)
+		if d.headerListFields {
+			h = d.splitHeaders(h, t)
+		}
+		d.merge(m, h, t, LocationHeader, ps, from, rest, errors)
+		if !d.collectErrors && len(errors) > 0 {
+			return nil, nil
+		}
+	}
+	if info.containsCookie {
+		d.merge(m, cookieValues(r), t, LocationCookie, ps, from, rest, errors)
 		if !d.collectErrors && len(errors) > 0 {
 			return nil, nil
 		}
 	}
 	if info.containsQuery {
-		d.merge(m, r.URL.Query(), t, LocationQuery, ps, errors)
+		query := r.URL.Query()
+		if d.querySeparator != 0 {
+			var bad map[string]ConversionError
+			query, bad = parseQuery(r.URL.RawQuery, d.querySeparator)
+			for k, e := range bad {
+				if parts, err := d.parsePath(k, t, LocationQuery); err == nil {
+					field := parts[len(parts)-1].field
+					e.Type, e.Value = field.typ, field.errorValue(e.Value)
+				}
+				errors[k] = e
+			}
+			if !d.collectErrors && len(errors) > 0 {
+				return nil, nil
+			}
+		}
+		d.merge(m, query, t, LocationQuery, ps, from, rest, errors)
 		if !d.collectErrors && len(errors) > 0 {
 			return nil, nil
 		}
 	}
-	if info.containsPath && d.pathExtractor != nil {
-		pathParams := d.pathExtractor(r)
+	if info.containsRequest {
+		// The locations are merged in a fixed order, for the precedence
+		// and the errors to be the same each time.
+		for _, l := range []struct {
+			location int
+			value    string
+		}{
+			{LocationRemoteAddr, r.RemoteAddr},
+			{LocationMethod, r.Method},
+			{LocationHost, r.Host},
+			{LocationURLPath, r.URL.Path},
+		} {
+			if d.disallowed[l.location] {
+				continue
+			}
+			d.merge(m, d.requestValues(info, l.location, l.value), t, l.location, ps, from, rest, errors)
+			if !d.collectErrors && len(errors) > 0 {
+				return nil, nil
+			}
+		}
+	}
+	if info.containsPath && pathParams == nil && d.pathExtractor != nil {
+		pathParams = d.pathExtractor(r)
+	}
+	if info.containsPath && pathParams != nil {
 		mm := map[string][]string{}
+	params:
 		for k, v := range pathParams {
-			mm[k] = []string{v}
+			values := []string{v}
+			parts, perr := d.parsePath(k, t, LocationPath)
+			if perr == nil {
+				if last := parts[len(parts)-1]; last.index < 0 && isScalarList(last.field.typ) && !last.field.hasDelim {
+					// Catch-all params, like "a/b/c", fill slices by segment.
+					values = pathSegments(v)
+				}
+			}
+			if d.pathParamsEncoded {
+				for i, value := range values {
+					unescaped, err := url.PathUnescape(value)
+					if err != nil {
+						e := ConversionError{Key: k, Index: -1, Value: value, Err: err}
+						if perr == nil {
+							field := parts[len(parts)-1].field
+							e.Type, e.Value = field.typ, field.errorValue(value)
+						}
+						errors[k] = e
+						if !d.collectErrors {
+							return nil, nil
+						}
+						continue params
+					}
+					values[i] = unescaped
+				}
+			}
+			mm[k] = values
 		}
-		d.merge(m, mm, t, LocationPath, ps, errors)
+		d.merge(m, mm, t, LocationPath, ps, from, rest, errors)
 		if !d.collectErrors && len(errors) > 0 {
 			return nil, nil
 		}
@@ -131,19 +216,425 @@ func (d *Decoder) extractMap(info *structInfo, t reflect.Type, r *http.Request,
 	return m, nil
 }
 
+// parseQuery parses a raw query like url.ParseQuery, splitting the pairs
+// on sep as well as on '&'. The pairs which cannot be unescaped are
+// skipped and their errors are returned by key.
+func parseQuery(query string, sep rune) (url.Values, map[string]ConversionError) {
+	m := url.Values{}
+	bad := map[string]ConversionError{}
+	pairs := strings.FieldsFunc(query, func(r rune) bool {
+		return r == '&' || r == sep
+	})
+	for _, pair := range pairs {
+		key, value := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		k, err := url.QueryUnescape(key)
+		if err != nil {
+			bad[key] = ConversionError{Key: key, Index: -1, Value: value, Err: err}
+			continue
+		}
+		v, err := url.QueryUnescape(value)
+		if err != nil {
+			bad[k] = ConversionError{Key: k, Index: -1, Value: value, Err: err}
+			continue
+		}
+		m[k] = append(m[k], v)
+	}
+	return m, bad
+}
+
+// pathSegments splits a path param into its segments,
+// dropping the empty ones.
+func pathSegments(v string) []string {
+	var segments []string
+	for _, s := range strings.Split(v, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// extractRawJSON sets the json.RawMessage fields of v to the raw values
+// of their keys in the JSON body, and extracts the rest of the body
+// like extractJSON.
+func (d *Decoder) extractRawJSON(m map[string][]string, info *structInfo, t reflect.Type, v reflect.Value, r *http.Request, ps map[string][]pathPart, from map[string]keySource, rest map[string]interface{}, errors MultiError) error {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return ParsingError{Err: fmt.Errorf("cannot read body"), WrappedErr: err}
+	}
+	return d.extractJSON(m, info, t, bytes.NewReader(b), func(body io.Reader) (map[string]interface{}, error) {
+		mm, err := jsonCodec(body)
+		if err != nil {
+			return nil, err
+		}
+		raw := map[string]json.RawMessage{}
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
+		}
+		for k, msg := range raw {
+			f := info.getWithLocation(k, LocationJSON)
+			if _, ok := d.override(k); ok || f == nil || !isRawJSON(f.typ) {
+				continue
+			}
+			if d.masked(k, false) {
+				delete(mm, k)
+				continue
+			}
+			if !d.methodAllowed(k, f.methods, r.Method, errors) {
+				delete(mm, k)
+				continue
+			}
+			fv, ok := settableField(v, f.name, true)
+			if !ok {
+				// The field cannot be set, like when promoted through
+				// an unexported embedded pointer.
+				delete(mm, k)
+				continue
+			}
+			if fv.Kind() == reflect.Ptr {
+				fv.Set(d.alloc(fv.Type().Elem()))
+				fv = fv.Elem()
+			}
+			fv.Set(reflect.ValueOf(msg).Convert(fv.Type()))
+			delete(mm, k)
+		}
+		return mm, nil
+	}, ps, from, rest, errors)
+}
+
+// extractJSON decodes the body using the codec, flattens it and merges it to m.
+func (d *Decoder) extractJSON(m map[string][]string, info *structInfo, t reflect.Type, body io.Reader, codec BodyCodec, ps map[string][]pathPart, from map[string]keySource, rest map[string]interface{}, errors MultiError) error {
+	mm, err := codec(body)
+	if err != nil {
+		return err
+	}
+	// Keys are sorted so the first unknown key reported is always the same.
+	keys := make([]string, 0, len(mm))
+	for k := range mm {
+		keys = append(keys, k)
+	}
+	natsort.Sort(keys)
+loop:
+	for _, k := range keys {
+		if d.masked(k, true) {
+			delete(mm, k)
+			continue
+		}
+		for _, alias := range info.fieldsJSON {
+			if k == alias || (info.normalize != nil && info.normalize(k) == info.normalize(alias)) {
+				if f := info.getWithLocation(alias, LocationJSON); f != nil {
+					if err := d.jsonShapeError(f, mm[k]); err != nil {
+						b, _ := json.Marshal(mm[k])
+						errors[k] = ConversionError{Key: k, Type: f.typ, Index: -1, Value: f.errorValue(string(b)), Err: err}
+						delete(mm, k)
+						if !d.collectErrors {
+							return nil
+						}
+					}
+				}
+				continue loop
+			}
+		}
+		if d.addRemainder(rest, info, LocationJSON, k, mm[k]) {
+			delete(mm, k)
+			continue
+		}
+		if !d.ignoreUnknownKeys {
+			errors[k] = d.unknownKeyError(k, t, LocationJSON)
+			if !d.collectErrors {
+				return nil
+			}
+		} else {
+			d.logf("reqtruct: skipping unknown key %q in json", k)
+		}
+		delete(mm, k)
+	}
+	lists := map[string]bool{}
+	fm, err := d.separators.flatten(mm, lists)
+	if err != nil {
+		return err
+	}
+	d.merge(m, fm, t, LocationJSON, ps, from, rest, errors)
+	for k := range lists {
+		if s, ok := from[d.sourceKey(k)]; ok && s.location == LocationJSON {
+			s.list = true
+			from[d.sourceKey(k)] = s
+		}
+	}
+	return nil
+}
+
+// jsonShapeError returns a JSONShapeError if the JSON value v cannot be
+// flattened to the field, like an array for a nested struct, or an object
+// for a slice unless its keys are indices.
+func (d *Decoder) jsonShapeError(f *fieldInfo, v interface{}) error {
+	t := indirectType(f.typ)
+	switch v := v.(type) {
+	case []interface{}:
+		if t.Kind() == reflect.Struct && d.cache.isNested(f) {
+			return JSONShapeError{Expected: "object", Got: "array"}
+		}
+	case map[string]interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		if d.cache.converter(t) != nil || f.isBinaryUnmarshaler || (f.unmarshalerInfo.IsValid && !f.unmarshalerInfo.IsSliceElement) {
+			return nil
+		}
+		for k := range v {
+			if i, err := strconv.Atoi(k); err != nil || i < 0 {
+				return JSONShapeError{Expected: "array", Got: "object"}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonTypeError returns a JSONShapeError for an object or array sent for
+// a field of type t expecting the other one, or e otherwise.
+func jsonTypeError(e *json.UnmarshalTypeError, t reflect.Type) error {
+	expected := ""
+	switch indirectType(t).Kind() {
+	case reflect.Struct, reflect.Map:
+		expected = "object"
+	case reflect.Slice, reflect.Array:
+		expected = "array"
+	}
+	if expected != "" && (e.Value == "object" || e.Value == "array") {
+		return JSONShapeError{Expected: expected, Got: e.Value}
+	}
+	return e
+}
+
+// decodeJSON decodes the JSON body directly to the JSON fields of v
+// using encoding/json, which keeps the types of the JSON values intact.
+func (d *Decoder) decodeJSON(info *structInfo, v reflect.Value, r *http.Request, rest map[string]interface{}, errors MultiError) error {
+	body, err := jsonObject(r.Body)
+	if err != nil {
+		return err
+	}
+	mm := map[string]json.RawMessage{}
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(&mm); err != nil {
+		return ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
+	}
+	keys := make([]string, 0, len(mm))
+	for k := range mm {
+		keys = append(keys, k)
+	}
+	natsort.Sort(keys)
+	for _, k := range keys {
+		if d.masked(k, false) {
+			continue
+		}
+		field := info.getWithLocation(k, LocationJSON)
+		if l, ok := d.override(k); ok {
+			field = nil
+			if l == LocationJSON {
+				field = info.getWithLocation(k, locationNone)
+			}
+		}
+		if field == nil {
+			var value interface{}
+			if json.Unmarshal(mm[k], &value) == nil && d.addRemainder(rest, info, LocationJSON, k, value) {
+				continue
+			}
+			if !d.ignoreUnknownKeys {
+				errors[k] = d.unknownKeyError(k, v.Type(), LocationJSON)
+				if !d.collectErrors {
+					return nil
+				}
+			} else {
+				d.logf("reqtruct: skipping unknown key %q in json", k)
+			}
+			continue
+		}
+		if !d.methodAllowed(k, field.methods, r.Method, errors) {
+			if !d.collectErrors && len(errors) > 0 {
+				return nil
+			}
+			continue
+		}
+		fv, ok := settableField(v, field.name, true)
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(mm[k], fv.Addr().Interface()); err != nil {
+			if e, ok := err.(*json.UnmarshalTypeError); ok && e.Field == "" {
+				err = jsonTypeError(e, fv.Type())
+			}
+			errors[k] = ConversionError{
+				Key:   k,
+				Type:  fv.Type(),
+				Index: -1,
+				Value: field.errorValue(string(mm[k])),
+				Err:   err,
+			}
+			if !d.collectErrors {
+				return nil
+			}
+			continue
+		}
+		err := d.checkNestedEnums([]string{k}, fv, d.caseSensitiveJSON)
+		if len(field.enum) > 0 {
+			err = d.checkEnum(k, fv, field, d.caseSensitiveJSON)
+		}
+		if e, ok := err.(EnumError); ok {
+			errors[e.Key] = e
+			if !d.collectErrors {
+				return nil
+			}
+			continue
+		}
+		if d.onField != nil {
+			d.onField(k, LocationJSON, fv)
+		}
+	}
+	return nil
+}
+
+// requestValues returns the value of request metadata for the top level
+// fields sourced from the location.
+func (d *Decoder) requestValues(info *structInfo, location int, value string) map[string][]string {
+	mm := map[string][]string{}
+	for _, f := range info.fields {
+		if f.alias == "" || f.canonicalAlias != f.alias {
+			continue
+		}
+		locations := f.locations
+		if l, ok := d.override(f.alias); ok {
+			locations = []int{l}
+		}
+		if containsInt(locations, location) {
+			mm[f.alias] = []string{value}
+		}
+	}
+	return mm
+}
+
+// splitHeaders splits header values as comma separated lists.
+// Slice and array fields get all the elements of the list
+// while other fields get only the first one.
+func (d *Decoder) splitHeaders(h map[string][]string, t reflect.Type) map[string][]string {
+	mm := make(map[string][]string, len(h))
+	for k, v := range h {
+		parts, err := d.parsePath(k, t, LocationHeader)
+		if err != nil {
+			mm[k] = v
+			continue
+		}
+		values := splitHeaderList(v)
+		if ft := indirectType(parts[len(parts)-1].field.typ); ft.Kind() != reflect.Slice && ft.Kind() != reflect.Array && ft.Kind() != reflect.Map && len(values) > 0 {
+			values = values[:1]
+		}
+		mm[k] = values
+	}
+	return mm
+}
+
+// headerValues returns the headers keyed by the aliases of the fields
+// they are sent for. Other headers are kept under their keys.
+func headerValues(h http.Header, info *structInfo) map[string][]string {
+	mm := make(map[string][]string, len(h))
+	for k, v := range h {
+		if alias, ok := info.headerAliases[k]; ok {
+			k = alias
+		}
+		mm[k] = append(mm[k], v...)
+	}
+	return mm
+}
+
+// cookieValues returns the values of the request cookies by name.
+// Cookies sent more than once with the same name have all their values.
+func cookieValues(r *http.Request) map[string][]string {
+	mm := map[string][]string{}
+	for _, c := range r.Cookies() {
+		mm[c.Name] = append(mm[c.Name], c.Value)
+	}
+	return mm
+}
+
+// splitHeaderList splits header values according to the list rules of RFC 7230.
+// Elements are trimmed and empty elements are dropped.
+// Commas in quoted strings do not split elements, so `"a, b", c` has
+// two elements, and elements made of a single quoted string are unquoted.
+func splitHeaderList(values []string) (list []string) {
+	for _, value := range values {
+		start, quoted, escaped := 0, false, false
+		for i := 0; i <= len(value); i++ {
+			if i < len(value) {
+				c := value[i]
+				switch {
+				case escaped:
+					escaped = false
+					continue
+				case quoted && c == '\\':
+					escaped = true
+					continue
+				case c == '"':
+					quoted = !quoted
+					continue
+				case quoted || c != ',':
+					continue
+				}
+			}
+			if s := unquoteHeaderElement(strings.TrimSpace(value[start:i])); s != "" {
+				list = append(list, s)
+			}
+			start = i + 1
+		}
+	}
+	return
+}
+
+// unquoteHeaderElement returns the content of the element if it is a single
+// quoted string, with its escapes removed, and the element as is otherwise.
+func unquoteHeaderElement(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s)-1:
+			i++
+			b.WriteByte(s[i])
+		case c == '"' || c == '\\':
+			// The quotes do not enclose the whole element.
+			return s
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
 func (d *Decoder) checkFiles(m map[string][]*multipart.FileHeader, t reflect.Type, ps map[string][]pathPart, errors MultiError) {
 	var parts []pathPart
 	var err error
+	filesMap := d.cache.get(t).filesMap
 	for k := range m {
-		parts, err = d.cache.parsePath(k, t, LocationFile)
+		if d.masked(k, false) {
+			continue
+		}
+		parts, err = d.parsePath(k, t, LocationFile)
 		if err == nil {
 			ps[k] = parts
+			d.logf("reqtruct: matched %d files of %q to field %s", len(m[k]), k, parts[len(parts)-1].field.name)
+		} else if err == invalidPath && filesMap != nil {
+			d.logf("reqtruct: matched %d files of %q to field %s", len(m[k]), k, filesMap.name)
 		} else if err == invalidPath {
 			if !d.ignoreUnknownKeys {
-				errors[k] = UnknownKeyError{Key: k}
+				errors[k] = d.unknownKeyError(k, t, LocationFile)
 				if !d.collectErrors {
 					return
 				}
+			} else {
+				d.logf("reqtruct: skipping unknown key %q in file", k)
 			}
 		} else {
 			errors[k] = err
@@ -154,114 +645,496 @@ func (d *Decoder) checkFiles(m map[string][]*multipart.FileHeader, t reflect.Typ
 	}
 }
 
-func (d *Decoder) merge(m map[string][]string, mm map[string][]string, t reflect.Type, location int, ps map[string][]pathPart, errors MultiError) {
+// addRemainder adds the value of an unknown key from the location to rest,
+// reporting whether the struct has a remainder field for the location.
+func (d *Decoder) addRemainder(rest map[string]interface{}, info *structInfo, location int, key string, value interface{}) bool {
+	if rest == nil || info.remainder == nil || !containsInt(info.remainder.locations, location) {
+		return false
+	}
+	rest[key] = value
+	return true
+}
+
+// remainderValue returns the values of an unknown key as a string,
+// or a []string if there are more than one.
+func remainderValue(values []string) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// keySource is the key and location a param was merged from.
+type keySource struct {
+	key      string
+	location int
+	// list indicates whether the values are the elements of a JSON array
+	// rather than strings.
+	list bool
+}
+
+// merge adds the values in mm from the given location to m.
+// from keeps track of the source of each param in m, so when a param is
+// present in more than one location the values from the location with
+// the highest precedence are kept. Keys are compared case insensitively
+// the same as aliases.
+func (d *Decoder) merge(m map[string][]string, mm map[string][]string, t reflect.Type, location int, ps map[string][]pathPart, from map[string]keySource, rest map[string]interface{}, errors MultiError) {
 	var parts []pathPart
-	var ok bool
 	var err error
 	var pk string
+	if d.parallelArrays {
+		mm = d.expandParallelArrays(mm, t, errors)
+		if !d.collectErrors && len(errors) > 0 {
+			return
+		}
+	}
 	for k, v := range mm {
 		pk = k
-		if rune(k[len(k)-2]) == d.cache.sepLeft && rune(k[len(k)-1]) == d.cache.sepRight {
+		if len(k) > 2 && rune(k[len(k)-2]) == d.separators.left && rune(k[len(k)-1]) == d.separators.right {
 			k = k[:len(k)-2]
 		}
-		if _, ok = m[k]; ok {
-			m[k] = append(m[k], mm[pk]...)
+		if d.masked(k, false) {
 			continue
 		}
-		_, ok = ps[k]
-		if !ok {
-			parts, err = d.cache.parsePath(k, t, location)
-			if err == nil {
-				if !ok {
-					ps[k] = parts
-				}
-				m[k] = v
-			} else if err == invalidPath {
-				if !d.ignoreUnknownKeys {
-					errors[k] = UnknownKeyError{Key: k}
-					if !d.collectErrors {
-						return
-					}
+		lk := d.sourceKey(k)
+		if s, ok := from[lk]; ok {
+			if s.location == location {
+				m[s.key] = append(m[s.key], mm[pk]...)
+				continue
+			}
+			if isListPath(ps[s.key]) {
+				// Values of slices are concatenated in the order
+				// of the precedence of their locations.
+				if d.precedes(location, s.location) {
+					m[s.key] = append(append([]string{}, mm[pk]...), m[s.key]...)
+					from[lk] = keySource{key: s.key, location: location}
+				} else {
+					m[s.key] = append(m[s.key], mm[pk]...)
 				}
-			} else {
-				errors[k] = err
+				continue
+			}
+			if !d.precedes(location, s.location) {
+				continue
+			}
+			delete(m, s.key)
+		} else if _, ok := ps[k]; ok {
+			continue
+		}
+		parts, err = d.parsePath(k, t, location)
+		if err == nil {
+			ps[k] = parts
+			m[k] = v
+			from[lk] = keySource{key: k, location: location}
+		} else if err == invalidPath {
+			if d.addRemainder(rest, d.cache.get(t), location, k, remainderValue(v)) {
+				continue
+			}
+			if !d.ignoreUnknownKeys {
+				errors[k] = d.unknownKeyError(k, t, location)
 				if !d.collectErrors {
 					return
 				}
+			} else {
+				d.logf("reqtruct: skipping unknown key %q in %s", k, locationToName(location))
+			}
+		} else {
+			errors[k] = err
+			if !d.collectErrors {
+				return
 			}
 		}
 	}
 }
 
-func flatten(m map[string]interface{}) map[string][]string {
-	mm := make(map[string][]string)
-	for k, v := range m {
-		switch reflect.TypeOf(v).Kind() {
-		case reflect.Map:
-			mv := flatten(v.(map[string]interface{}))
-			for kk, vv := range mv {
-				mm[k+"."+kk] = vv
-			}
-		case reflect.Array, reflect.Slice:
-			for kk, vv := range v.([]interface{}) {
-				if reflect.TypeOf(vv).Kind() == reflect.Map {
-					mv := flatten(vv.(map[string]interface{}))
-					for kkk, vvv := range mv {
-						mm[k+"."+fmt.Sprint(kk)+"."+kkk] = vvv
-					}
-				} else {
-					mm[k] = append(mm[k], fmt.Sprint(vv))
-				}
-			}
-		default:
-			mm[k] = []string{fmt.Sprint(v)}
-		}
+// sourceKey returns the key identifying a param across locations.
+func (d *Decoder) sourceKey(k string) string {
+	if d.cache.keyNormalizer != nil {
+		k = d.cache.keyNormalizer(k)
 	}
-	return mm
+	return strings.ToLower(k)
 }
 
-func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values []string, fs []*multipart.FileHeader, lens map[reflect.Value]map[int]int) error {
-	for _, name := range parts[0].path {
-		if v.Type().Kind() == reflect.Ptr {
-			if v.IsNil() {
-				v.Set(reflect.New(v.Type().Elem()))
-			}
-			v = v.Elem()
+// precedes reports whether location a has a higher precedence than location b.
+func (d *Decoder) precedes(a, b int) bool {
+	precedence := d.precedence
+	if precedence == nil {
+		precedence = locationPrecedence
+	}
+	for _, l := range precedence {
+		if l == a {
+			return true
+		}
+		if l == b {
+			return false
 		}
-		v = v.FieldByName(name)
 	}
+	return false
+}
 
-	if !v.CanSet() {
-		return nil
+// isListPath reports whether the path leads to a field taking all the values
+// of its param, like slices, arrays and maps, rather than an element of it.
+func isListPath(parts []pathPart) bool {
+	if len(parts) == 0 {
+		return false
 	}
+	last := parts[len(parts)-1]
+	if last.index >= 0 || last.mapKey != "" {
+		return false
+	}
+	k := indirectType(last.field.typ).Kind()
+	return k == reflect.Slice || k == reflect.Array || k == reflect.Map
+}
 
-	t := v.Type()
-	if t.Kind() == reflect.Ptr && len(fs) == 0 {
-		t = t.Elem()
-		if v.IsNil() {
-			v.Set(reflect.New(t))
-		}
-		v = v.Elem()
+// unknownKeyError returns the error for an unknown key,
+// suggesting the closest valid key if suggestions are enabled.
+func (d *Decoder) unknownKeyError(key string, t reflect.Type, location int) UnknownKeyError {
+	e := UnknownKeyError{Key: key}
+	if d.suggestKeys {
+		e.Suggestion = d.suggestKey(key, t, location)
 	}
+	return e
+}
 
-	if len(parts) > 1 {
-		var ok bool
-		var idx int
-		if _, ok = lens[v]; !ok {
-			lens[v] = map[int]int{}
+// suggestKey returns the valid key closest to the unknown key, found by
+// replacing its first unknown part with the closest alias, or an empty
+// string if there is no close enough alias.
+func (d *Decoder) suggestKey(key string, t reflect.Type, location int) string {
+	keys, err := d.separators.splitPath(key)
+	if err != nil {
+		return ""
+	}
+	st := t
+	for i := 0; i < len(keys); i++ {
+		if st.Kind() != reflect.Struct {
+			return ""
 		}
-		if idx, ok = lens[v][parts[0].index]; !ok {
-			idx = v.Len()
-			lens[v][parts[0].index] = idx
-			value := reflect.MakeSlice(t, v.Len()+1, v.Len()+1)
-			reflect.Copy(value, v)
-			v.Set(value)
+		info := d.cache.get(st)
+		field := info.get(keys[i])
+		if field != nil {
+			if field.isIndexed() {
+				i++
+				st = underlyingElem(field.typ)
+			} else {
+				st = indirectType(field.typ)
+			}
+			continue
 		}
-		return d.decode(v.Index(idx), path, parts[1:], values, fs, lens)
+		best, bestDist := "", -1
+		for _, f := range info.fields {
+			if f.alias == "" || (i == 0 && !containsInt(f.locations, location)) {
+				continue
+			}
+			dist := levenshtein(strings.ToLower(keys[i]), strings.ToLower(f.alias))
+			if dist <= maxSuggestionDistance(keys[i]) && (bestDist < 0 || dist < bestDist) {
+				best, bestDist = f.alias, dist
+			}
+		}
+		if best == "" {
+			return ""
+		}
+		keys[i] = best
+		suggestion := d.separators.joinPath(keys)
+		if _, err := d.parsePath(suggestion, t, location); err != nil {
+			return ""
+		}
+		return suggestion
 	}
+	return ""
+}
 
-	if len(fs) > 0 {
-		if isFileHeadersPtrs(t) {
+// maxSuggestionDistance returns the max edit distance of a suggestion for s.
+func maxSuggestionDistance(s string) int {
+	if n := len([]rune(s)) / 3; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// expandParallelArrays rewrites the keys of parallel arrays, like
+// users.name=a&users.name=b, to indexed keys, like users.0.name=a&users.1.name=b.
+// All parallel arrays of the same slice must have the same length.
+func (d *Decoder) expandParallelArrays(mm map[string][]string, t reflect.Type, errors MultiError) map[string][]string {
+	keys := make([]string, 0, len(mm))
+	for k := range mm {
+		keys = append(keys, k)
+	}
+	natsort.Sort(keys)
+	out := make(map[string][]string, len(mm))
+	lens := map[string]int{}
+	for _, k := range keys {
+		v := mm[k]
+		parts, err := d.separators.splitPath(k)
+		if err != nil {
+			out[k] = append(out[k], v...)
+			continue
+		}
+		i := d.cache.parallelIndex(parts, t)
+		if i < 0 {
+			out[k] = append(out[k], v...)
+			continue
+		}
+		prefix := d.separators.joinPath(parts[:i])
+		if l, ok := lens[prefix]; ok && l != len(v) {
+			errors[k] = ParallelArraysLengthError{Key: k, Length: len(v), Expected: l}
+			if !d.collectErrors {
+				return nil
+			}
+			continue
+		}
+		lens[prefix] = len(v)
+		for j := range v {
+			indexed := make([]string, 0, len(parts)+1)
+			indexed = append(indexed, parts[:i]...)
+			indexed = append(indexed, strconv.Itoa(j))
+			indexed = append(indexed, parts[i:]...)
+			ik := d.separators.joinPath(indexed)
+			out[ik] = append(out[ik], v[j])
+		}
+	}
+	return out
+}
+
+// flatten flattens a decoded JSON object to a map of paths to values.
+// Paths are joined using the separators.
+// Arrays of objects are flattened to indexed paths, and arrays of other
+// values to multiple values of the same path. Arrays mixing objects and
+// other values cannot be represented so they return a ParsingError.
+// Null values are skipped.
+func (s separators) flatten(m map[string]interface{}, lists map[string]bool) (map[string][]string, error) {
+	mm := make(map[string][]string)
+	if err := s.flattenInto(mm, lists, nil, m); err != nil {
+		return nil, err
+	}
+	return mm, nil
+}
+
+func (s separators) flattenInto(mm map[string][]string, lists map[string]bool, prefix []string, m map[string]interface{}) error {
+	for k, v := range m {
+		path := append(prefix[:len(prefix):len(prefix)], k)
+		switch v := v.(type) {
+		case nil:
+		case map[string]interface{}:
+			if err := s.flattenInto(mm, lists, path, v); err != nil {
+				return err
+			}
+		case []interface{}:
+			key := s.joinPath(path)
+			if isMixedArray(v) {
+				return ParsingError{Err: fmt.Errorf("JSON array %q mixes objects and other values", key)}
+			}
+			for i, vv := range v {
+				if o, ok := vv.(map[string]interface{}); ok {
+					if err := s.flattenInto(mm, lists, append(path[:len(path):len(path)], strconv.Itoa(i)), o); err != nil {
+						return err
+					}
+				} else if vv != nil {
+					mm[key] = append(mm[key], jsonString(vv))
+					if lists != nil {
+						lists[key] = true
+					}
+				}
+			}
+		default:
+			mm[s.joinPath(path)] = []string{jsonString(v)}
+		}
+	}
+	return nil
+}
+
+// jsonString returns the string of a JSON scalar, as accepted by the
+// converters of its kind.
+// Numbers are kept as they were sent, so they keep their precision,
+// except whole numbers which are formatted without an exponent or
+// a fraction, so 1e6 is "1000000" and can be decoded to integer fields.
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			return string(v)
+		}
+		if n, ok := wholeJSONNumber(string(v)); ok {
+			return n
+		}
+		return string(v)
+	case float64:
+		return formatJSONFloat(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// maxWholeJSONDigits is the number of digits of the largest float64,
+// which bounds the whole numbers written without an exponent.
+const maxWholeJSONDigits = 309
+
+// wholeJSONNumber returns the JSON number n, which has a fraction or an
+// exponent, without them if it is a whole number, like "12000" for 1.2e4.
+// The digits are moved rather than parsed to a float64, so they are kept
+// exactly.
+func wholeJSONNumber(n string) (string, bool) {
+	sign := ""
+	if strings.HasPrefix(n, "-") {
+		sign, n = "-", n[1:]
+	}
+	exp := 0
+	if i := strings.IndexAny(n, "eE"); i >= 0 {
+		e, err := strconv.Atoi(n[i+1:])
+		if err != nil || e > maxWholeJSONDigits || e < -maxWholeJSONDigits {
+			return "", false
+		}
+		n, exp = n[:i], e
+	}
+	// point is the number of digits before the decimal point.
+	point := len(n)
+	if i := strings.IndexByte(n, '.'); i >= 0 {
+		n, point = n[:i]+n[i+1:], i
+	}
+	digits := strings.TrimLeft(n, "0")
+	point -= len(n) - len(digits)
+	digits = strings.TrimRight(digits, "0")
+	if digits == "" {
+		return sign + "0", true
+	}
+	point += exp
+	if point < len(digits) || point > maxWholeJSONDigits {
+		return "", false
+	}
+	return sign + digits + strings.Repeat("0", point-len(digits)), true
+}
+
+// formatJSONFloat formats a JSON number decoded to a float64.
+func formatJSONFloat(f float64) string {
+	if f == math.Trunc(f) && !math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// isMixedArray reports whether the array has both objects and other values.
+func isMixedArray(a []interface{}) bool {
+	var objects, others bool
+	for _, v := range a {
+		if _, ok := v.(map[string]interface{}); ok {
+			objects = true
+		} else if v != nil {
+			others = true
+		}
+	}
+	return objects && others
+}
+
+func (d *Decoder) decode(v reflect.Value, path string, location int, list bool, parts []pathPart, values []string, fs []*multipart.FileHeader, lens map[reflect.Value]map[int]int, walked map[walkKey]reflect.Value) error {
+	names := parts[0].path
+	key := walkKey{base: v, parent: parts[0].parent}
+	start := 0
+	if key.parent != "" {
+		if pv, ok := walked[key]; ok {
+			// A sibling field already walked to the parent.
+			v, start = pv, len(names)-1
+		}
+	}
+	for i := start; i < len(names); i++ {
+		if v.Type().Kind() == reflect.Ptr {
+			if v.IsNil() && d.lazyNil {
+				return d.decodeLazy(v, path, location, list, parts, i, values, fs, lens, walked)
+			}
+			if v.IsNil() {
+				v.Set(d.alloc(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.FieldByName(names[i])
+		if i == len(names)-2 && key.parent != "" {
+			walked[key] = v
+		}
+	}
+	if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct {
+		// Setting the pointer could replace the struct
+		// sibling fields walked through.
+		forgetWalked(walked, key.base, names)
+	}
+
+	if !v.CanSet() {
+		return nil
+	}
+
+	// pointers are allocated whenever the key is present, and for
+	// empty values only if they are treated as present.
+	t := v.Type()
+	allocated := false
+	if t.Kind() == reflect.Ptr && len(fs) == 0 {
+		t = t.Elem()
+		if v.IsNil() && len(parts) == 1 && d.skipEmptyPointer(t, location, values) {
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(d.alloc(t))
+			allocated = true
+		}
+		v = v.Elem()
+	}
+
+	if len(parts) > 1 {
+		var ok bool
+		var idx int
+		if _, ok = lens[v]; !ok {
+			lens[v] = map[int]int{}
+		}
+		if idx, ok = lens[v][parts[0].index]; !ok {
+			idx = v.Len()
+			if t.Kind() == reflect.Slice {
+				if err := d.sliceLenError(path, idx+1); err != nil {
+					return err
+				}
+			}
+			lens[v][parts[0].index] = idx
+			value := reflect.MakeSlice(t, v.Len()+1, v.Len()+1)
+			reflect.Copy(value, v)
+			v.Set(value)
+		}
+		return d.decode(v.Index(idx), path, location, list, parts[1:], values, fs, lens, walked)
+	}
+
+	// set reports whether the field was assigned a value.
+	set := false
+	if len(fs) > 0 {
+		set = !d.dryRun
+		if isFileHeadersPtrs(t) {
 			v.Set(reflect.ValueOf(fs))
 		} else if isFileHeaderPtr(t) {
 			v.Set(reflect.ValueOf(fs[0]))
@@ -273,6 +1146,8 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values
 			v.Set(reflect.ValueOf(files))
 		} else if isFileHeader(t) {
 			v.Set(reflect.ValueOf(*fs[0]))
+		} else if (isFiles(t) || isFile(t)) && d.dryRun {
+			// Files are not opened as nothing is set.
 		} else if isFiles(t) {
 			var files []multipart.File
 			for _, ff := range fs {
@@ -302,96 +1177,121 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values
 			}
 		}
 	} else if len(values) > 0 {
+		field := parts[0].field
+		if location == LocationHeader && field.stripScheme != "" {
+			if values = stripScheme(values, field.stripScheme); len(values) == 0 {
+				return nil
+			}
+		}
+		set = true
 		conv := d.cache.converter(t)
 		m := isTextUnmarshaler(v)
-		if conv == nil && t.Kind() == reflect.Slice && m.IsSliceElement {
-			var items []reflect.Value
-			elemT := t.Elem()
-			isPtrElem := elemT.Kind() == reflect.Ptr
-			if isPtrElem {
-				elemT = elemT.Elem()
+		if key := parts[0].mapKey; key != "" && t.Kind() == reflect.Map {
+			if err := d.decodeMapKey(v, path, t, field, key, values); err != nil {
+				return err
 			}
-
-			conv := d.cache.converter(elemT)
-			if conv == nil {
-				conv = builtinConverters[elemT.Kind()]
-				if conv == nil {
-					return fmt.Errorf("converter not found for %v", elemT)
-				}
+		} else if idx := parts[0].index; idx >= 0 && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			if err := d.decodeIndex(v, path, t, field, idx, values); err != nil {
+				return err
 			}
-
-			for key, value := range values {
-				if value == "" {
-					if d.zeroEmpty {
-						items = append(items, reflect.Zero(elemT))
-					}
-				} else if m.IsValid {
-					u := reflect.New(elemT)
-					if m.IsSliceElementPtr {
-						u = reflect.New(reflect.PtrTo(elemT).Elem())
-					}
-					if err := u.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
-						return ConversionError{
-							Key:   path,
-							Type:  t,
-							Index: key,
-							Err:   err,
-						}
-					}
-					if m.IsSliceElementPtr {
-						items = append(items, u.Elem().Addr())
-					} else if u.Kind() == reflect.Ptr {
-						items = append(items, u.Elem())
-					} else {
-						items = append(items, u)
-					}
-				} else if item := conv(value); item.IsValid() {
-					if isPtrElem {
-						ptr := reflect.New(elemT)
-						ptr.Elem().Set(item)
-						item = ptr
+		} else if field.isBinaryUnmarshaler && conv == nil {
+			val := values[len(values)-1]
+			if val == "" {
+				if d.zeroEmpty {
+					v.Set(reflect.Zero(t))
+				} else {
+					set = false
+				}
+			} else {
+				b, err := decodeBytes(val, field.encoding)
+				if err == nil {
+					u := reflect.New(t)
+					if err = u.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b); err == nil {
+						v.Set(u.Elem())
 					}
-					if item.Type() != elemT && !isPtrElem {
-						item = item.Convert(elemT)
+				}
+				if err != nil {
+					return ConversionError{
+						Key:   path,
+						Type:  t,
+						Index: -1,
+						Value: field.errorValue(val),
+						Err:   err,
 					}
-					items = append(items, item)
-				} else {
-					if strings.Contains(value, ",") {
-						values := strings.Split(value, ",")
-						for _, value := range values {
-							if value == "" {
-								if d.zeroEmpty {
-									items = append(items, reflect.Zero(elemT))
-								}
-							} else if item := conv(value); item.IsValid() {
-								if isPtrElem {
-									ptr := reflect.New(elemT)
-									ptr.Elem().Set(item)
-									item = ptr
-								}
-								if item.Type() != elemT && !isPtrElem {
-									item = item.Convert(elemT)
-								}
-								items = append(items, item)
-							} else {
-								return ConversionError{
-									Key:   path,
-									Type:  elemT,
-									Index: key,
-								}
-							}
-						}
-					} else {
-						return ConversionError{
-							Key:   path,
-							Type:  elemT,
-							Index: key,
-						}
+				}
+			}
+		} else if conv == nil && !m.IsValid && isBytes(t) && !list {
+			var b []byte
+			for _, val := range values {
+				vb, err := decodeBytes(val, field.encoding)
+				if err != nil {
+					return ConversionError{
+						Key:   path,
+						Type:  t,
+						Index: -1,
+						Value: field.errorValue(val),
+						Err:   err,
 					}
 				}
+				b = append(b, vb...)
+			}
+			v.Set(reflect.ValueOf(b).Convert(t))
+		} else if conv == nil && !m.IsValid && t.Kind() == reflect.Slice && d.emptySliceAsPresent && len(values) == 1 && values[0] == "" {
+			v.Set(reflect.MakeSlice(t, 0, 0))
+		} else if conv == nil && !m.IsValid && t.Kind() == reflect.Slice && !list && location != LocationJSON && len(values) == 1 && values[0] == "" {
+			// The slice is left as if the param was missing.
+			if d.zeroEmpty {
+				v.Set(reflect.Zero(t))
+			} else {
+				set = false
+			}
+		} else if conv == nil && t.Kind() == reflect.Map {
+			if location != LocationHeader {
+				// Entries are only sent as key=value pairs in headers.
+				return ConversionError{
+					Key:   path,
+					Type:  t,
+					Index: -1,
+					Value: field.errorValue(values[len(values)-1]),
+					Err:   fmt.Errorf("map entries must be sent as prefixed keys in %s", locationToName(location)),
+				}
+			}
+			value, err := d.convertPairs(path, t, field, values)
+			if err != nil {
+				return err
+			}
+			v.Set(value)
+		} else if field.explode && conv == nil && t.Kind() == reflect.Slice {
+			items, err := d.convertItems(path, t, field, values, elemUnmarshaler(t))
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.Append(reflect.MakeSlice(t, 0, 0), items...))
+		} else if conv == nil && t.Kind() == reflect.Slice && m.IsSliceElement {
+			items, err := d.convertItems(path, t, field, values, m)
+			if err != nil {
+				return err
 			}
 			value := reflect.Append(reflect.MakeSlice(t, 0, 0), items...)
 			v.Set(value)
+		} else if conv == nil && t.Kind() == reflect.Array {
+			items, err := d.convertItems(path, t, field, values, elemUnmarshaler(t))
+			if err != nil {
+				return err
+			}
+			if len(items) > t.Len() {
+				return ArrayLengthError{
+					Key:    path,
+					Type:   t,
+					Length: t.Len(),
+					Count:  len(items),
+				}
+			}
+			value := reflect.New(t).Elem()
+			for i := range items {
+				value.Index(i).Set(items[i])
+			}
+			v.Set(value)
 		} else {
 			val := ""
 
@@ -399,14 +1299,34 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values
 				val = values[len(values)-1]
 			}
 
-			if conv != nil {
-				if value := conv(val); value.IsValid() {
+			if format := field.timeFormats[location]; format != "" && t == timeType {
+				if val == "" {
+					if d.zeroEmpty {
+						v.Set(reflect.Zero(t))
+					} else {
+						set = false
+					}
+				} else if tm, err := parseTime(val, format); err == nil {
+					v.Set(reflect.ValueOf(tm))
+				} else {
+					return ConversionError{
+						Key:   path,
+						Type:  t,
+						Index: -1,
+						Value: field.errorValue(val),
+						Err:   err,
+					}
+				}
+			} else if conv != nil {
+				if value, err := conv(val, field.structField); err == nil && value.IsValid() {
 					v.Set(value.Convert(t))
 				} else {
 					return ConversionError{
 						Key:   path,
 						Type:  t,
 						Index: -1,
+						Value: field.errorValue(val),
+						Err:   err,
 					}
 				}
 			} else if m.IsValid {
@@ -417,6 +1337,7 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values
 							Key:   path,
 							Type:  t,
 							Index: -1,
+							Value: field.errorValue(val),
 							Err:   err,
 						}
 					}
@@ -427,15 +1348,42 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values
 							Key:   path,
 							Type:  t,
 							Index: -1,
+							Value: field.errorValue(val),
 							Err:   err,
 						}
 					}
 				}
+			} else if val == "" && d.boolPresenceTrue && t.Kind() == reflect.Bool {
+				v.Set(reflect.ValueOf(true).Convert(t))
 			} else if val == "" {
 				if d.zeroEmpty {
 					v.Set(reflect.Zero(t))
+				} else {
+					set = false
+				}
+			} else if field.isFromStringer {
+				u := reflect.New(t)
+				if err := u.Interface().(FromStringer).FromString(val); err != nil {
+					return ConversionError{
+						Key:   path,
+						Type:  t,
+						Index: -1,
+						Value: field.errorValue(val),
+						Err:   err,
+					}
+				}
+				v.Set(u.Elem())
+			} else if set := field.setterFor(location); set != nil && (t.Kind() != reflect.Bool || d.boolConverter == nil) {
+				if err := set(v, val); err != nil {
+					return ConversionError{
+						Key:   path,
+						Type:  t,
+						Index: -1,
+						Value: field.errorValue(val),
+						Err:   err,
+					}
 				}
-			} else if conv := builtinConverters[t.Kind()]; conv != nil {
+			} else if conv := d.kindConverter(t.Kind()); conv != nil {
 				if value := conv(val); value.IsValid() {
 					v.Set(value.Convert(t))
 				} else {
@@ -443,12 +1391,511 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart, values
 						Key:   path,
 						Type:  t,
 						Index: -1,
+						Value: field.errorValue(val),
 					}
 				}
 			} else {
 				return fmt.Errorf("converter not found for %v", t)
 			}
 		}
+		if len(field.enum) > 0 && set {
+			if err := d.checkEnum(path, v, field, d.caseSensitiveJSON && location == LocationJSON); err != nil {
+				return err
+			}
+		}
+	}
+	if d.onField != nil && (set || allocated) {
+		d.onField(path, location, v)
+	}
+	return nil
+}
+
+// skipEmptyPointer reports whether a nil pointer to t is left nil
+// for the values, as they are empty and not treated as present.
+func (d *Decoder) skipEmptyPointer(t reflect.Type, location int, values []string) bool {
+	if d.emptyPointerAsPresent || location == LocationJSON {
+		return false
+	}
+	if (d.boolPresenceTrue && t.Kind() == reflect.Bool) || (d.emptySliceAsPresent && t.Kind() == reflect.Slice) {
+		return false
+	}
+	for _, val := range values {
+		if val != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetWalked drops the parents walked to from base through
+// the field at path, like after the field is replaced.
+func forgetWalked(walked map[walkKey]reflect.Value, base reflect.Value, path []string) {
+	p := strings.Join(path, ".")
+	for k := range walked {
+		if k.base == base && (k.parent == p || strings.HasPrefix(k.parent, p+".")) {
+			delete(walked, k)
+		}
+	}
+}
+
+// walkKey identifies the parent struct of a field by the value
+// the path to it starts from and the path.
+type walkKey struct {
+	base   reflect.Value
+	parent string
+}
+
+// decodeLazy decodes to a new struct for the nil pointer v, whose fields
+// are at parts[0].path[i:], and only sets v to it if a non-zero value
+// was decoded.
+func (d *Decoder) decodeLazy(v reflect.Value, path string, location int, list bool, parts []pathPart, i int, values []string, fs []*multipart.FileHeader, lens map[reflect.Value]map[int]int, walked map[walkKey]reflect.Value) error {
+	p := d.alloc(v.Type().Elem())
+	rest := append([]pathPart{}, parts...)
+	rest[0].path = parts[0].path[i:]
+	rest[0].parent = parentPath(rest[0].path)
+	c := d
+	var field reflect.Value
+	if d.onField != nil {
+		// The field is reported only once the struct is kept.
+		cd := *d
+		cd.onField = func(key string, location int, value reflect.Value) { field = value }
+		c = &cd
+	}
+	if err := c.decode(p.Elem(), path, location, list, rest, values, fs, lens, walked); err != nil {
+		return err
+	}
+	if !p.Elem().IsZero() {
+		v.Set(p)
+		if field.IsValid() {
+			d.onField(path, location, field)
+		}
+	}
+	return nil
+}
+
+// elemItem converts a converted value to the element type elemT,
+// allocating a pointer to it for slices of pointers.
+func (d *Decoder) elemItem(item reflect.Value, elemT reflect.Type, isPtrElem bool) reflect.Value {
+	if item.Type() != elemT {
+		item = item.Convert(elemT)
+	}
+	if isPtrElem {
+		ptr := d.alloc(elemT)
+		ptr.Elem().Set(item)
+		return ptr
+	}
+	return item
+}
+
+// checkEnum checks that the string values of v, or of its elements
+// for slices and arrays, are allowed by the enum of the field.
+// Values are matched ignoring case unless exact, and set to the
+// spelling of the allowed value they match.
+func (d *Decoder) checkEnum(path string, v reflect.Value, field *fieldInfo, exact bool) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return d.checkEnum(path, v.Elem(), field, exact)
+		}
+	case reflect.String:
+		allowed, ok := enumValue(field.enum, v.String(), exact)
+		if !ok {
+			return EnumError{Key: path, Value: field.errorValue(v.String()), Allowed: field.enum}
+		}
+		if allowed != v.String() {
+			v.SetString(allowed)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.checkEnum(path, v.Index(i), field, exact); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkNestedEnums checks the enums of the fields of the structs in v,
+// which encoding/json decodes without checking them.
+func (d *Decoder) checkNestedEnums(parts []string, v reflect.Value, exact bool) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return d.checkNestedEnums(parts, v.Elem(), exact)
+		}
+	case reflect.Slice, reflect.Array:
+		if underlyingElem(v.Type()).Kind() != reflect.Struct {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := d.checkNestedEnums(append(parts[:len(parts):len(parts)], strconv.Itoa(i)), v.Index(i), exact); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if isFileType(v.Type()) {
+			return nil
+		}
+		info := d.cache.get(v.Type())
+		if !info.containsEnum {
+			return nil
+		}
+		for _, f := range info.fields {
+			fv, ok := settableField(v, f.name, false)
+			if !ok {
+				continue
+			}
+			path := append(parts[:len(parts):len(parts)], f.alias)
+			if len(f.enum) > 0 {
+				if err := d.checkEnum(d.separators.joinPath(path), fv, f, exact); err != nil {
+					return err
+				}
+			} else if !f.isAnonymous {
+				if err := d.checkNestedEnums(path, fv, exact); err != nil {
+					return err
+				}
+			}
+		}
 	}
 	return nil
 }
+
+// enumValue returns the value of enum matching s, ignoring case unless exact.
+func enumValue(enum []string, s string, exact bool) (string, bool) {
+	for _, e := range enum {
+		if e == s {
+			return e, true
+		}
+	}
+	if !exact {
+		for _, e := range enum {
+			if strings.EqualFold(e, s) {
+				return e, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isBytes reports whether t is a []byte decoded from base64 strings,
+// which excludes json.RawMessage. The values of all the strings are
+// concatenated, while JSON arrays are decoded as the bytes they hold.
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && t != rawJSONType
+}
+
+// decodeBytes decodes a hex value if the encoding is hex, otherwise
+// a base64 value with or without padding, using the URL-safe alphabet
+// if the encoding is base64url.
+func decodeBytes(value string, encoding string) ([]byte, error) {
+	switch encoding {
+	case hexEncoding:
+		return hex.DecodeString(value)
+	case base64URLEncoding:
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	case "", base64Encoding:
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// splitRecord splits value by sep as a CSV record, so quoted elements
+// can contain sep, like "Smith, John",Doe. Values which are not valid
+// records, and values split by more than a rune, are split by every sep.
+func splitRecord(value string, sep string) []string {
+	comma, size := utf8.DecodeRuneInString(sep)
+	if size != len(sep) || !strings.Contains(value, `"`) {
+		return strings.Split(value, sep)
+	}
+	r := csv.NewReader(strings.NewReader(value))
+	r.Comma = comma
+	record, err := r.Read()
+	if err != nil {
+		return strings.Split(value, sep)
+	}
+	if _, err = r.Read(); err != io.EOF {
+		// The value spans more than one record.
+		return strings.Split(value, sep)
+	}
+	return record
+}
+
+// stripScheme strips the auth scheme from the values, like "Bearer abc"
+// to "abc". Values without the scheme are dropped.
+func stripScheme(values []string, scheme string) []string {
+	stripped := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) > len(scheme) && strings.EqualFold(value[:len(scheme)], scheme) && (value[len(scheme)] == ' ' || value[len(scheme)] == '\t') {
+			stripped = append(stripped, strings.TrimSpace(value[len(scheme):]))
+		}
+	}
+	return stripped
+}
+
+// decodeIndex sets the element at index idx of the slice or array v
+// to the last of values. Slices are grown as needed, filling the gaps
+// with zero values.
+func (d *Decoder) decodeIndex(v reflect.Value, path string, t reflect.Type, field *fieldInfo, idx int, values []string) error {
+	if t.Kind() == reflect.Array && idx >= t.Len() {
+		return ArrayLengthError{
+			Key:    path,
+			Type:   t,
+			Length: t.Len(),
+			Count:  idx + 1,
+		}
+	}
+	val := values[len(values)-1]
+	if val == "" && !d.zeroEmpty {
+		return nil
+	}
+	items, err := d.convertItems(path, t, field, []string{val}, elemUnmarshaler(t))
+	if err != nil {
+		if e, ok := err.(ConversionError); ok {
+			e.Index = idx
+			return e
+		}
+		return err
+	}
+	if len(items) != 1 {
+		return ConversionError{
+			Key:   path,
+			Type:  t.Elem(),
+			Index: idx,
+			Value: field.errorValue(val),
+		}
+	}
+	if t.Kind() == reflect.Slice && idx >= v.Len() {
+		if err := d.sliceLenError(path, idx+1); err != nil {
+			return err
+		}
+		value := reflect.MakeSlice(t, idx+1, idx+1)
+		reflect.Copy(value, v)
+		v.Set(value)
+	}
+	v.Index(idx).Set(items[0])
+	return nil
+}
+
+// elemConverter returns the converter for the elements of type elemT
+// of the field.
+func (d *Decoder) elemConverter(elemT reflect.Type, field *fieldInfo) (ConverterErr, error) {
+	if fc := d.cache.converter(elemT); fc != nil {
+		return func(value string) (reflect.Value, error) {
+			return fc(value, field.structField)
+		}, nil
+	}
+	if field.elemConverter != nil {
+		return field.elemConverter, nil
+	}
+	if conv := d.kindConverter(elemT.Kind()); conv != nil {
+		return withoutErr(conv), nil
+	}
+	return nil, fmt.Errorf("converter not found for %v", elemT)
+}
+
+// decodeMapKey sets the entry with the given key of the map v
+// to the last of values, allocating the map if needed.
+func (d *Decoder) decodeMapKey(v reflect.Value, path string, t reflect.Type, field *fieldInfo, key string, values []string) error {
+	elemT := t.Elem()
+	conv, err := d.elemConverter(elemT, field)
+	if err != nil {
+		return err
+	}
+	val := values[len(values)-1]
+	item := reflect.Zero(elemT)
+	if val != "" {
+		if item, err = conv(val); err != nil || !item.IsValid() {
+			return ConversionError{
+				Key:   path,
+				Type:  elemT,
+				Index: -1,
+				Value: field.errorValue(val),
+				Err:   err,
+			}
+		}
+	} else if !d.zeroEmpty {
+		return nil
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	v.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), item.Convert(elemT))
+	return nil
+}
+
+// convertPairs converts the key=value pairs in values to a map of type t.
+// Pairs are separated by the delimiter of the field, and a key without
+// a value gets the zero value.
+func (d *Decoder) convertPairs(path string, t reflect.Type, field *fieldInfo, values []string) (reflect.Value, error) {
+	elemT := t.Elem()
+	conv, err := d.elemConverter(elemT, field)
+	if err != nil {
+		return invalidValue, err
+	}
+
+	m := reflect.MakeMap(t)
+	for _, value := range values {
+		for _, pair := range strings.Split(value, field.pairsDelim()) {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, val := pair, ""
+			if i := strings.Index(pair, "="); i >= 0 {
+				key, val = strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+			}
+			if len(val) > 1 && val[0] == '"' && val[len(val)-1] == '"' {
+				val = val[1 : len(val)-1]
+			}
+			item := reflect.Zero(elemT)
+			if val != "" {
+				if item, err = conv(val); err != nil || !item.IsValid() {
+					return invalidValue, ConversionError{
+						Key:   path,
+						Type:  t,
+						Index: -1,
+						Value: field.errorValue(pair),
+						Err:   err,
+					}
+				}
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), item.Convert(elemT))
+		}
+	}
+	return m, nil
+}
+
+// convertItems converts values to the elements of the slice or array type t.
+// Values are split by the delimiter of the field if it has one.
+// Otherwise values that cannot be converted as a whole are split by commas,
+// unless the field is exploded. Quoted elements keep their delimiters,
+// like in CSV records.
+func (d *Decoder) convertItems(path string, t reflect.Type, field *fieldInfo, values []string, m unmarshaler) ([]reflect.Value, error) {
+	var items []reflect.Value
+	elemT := t.Elem()
+	isPtrElem := elemT.Kind() == reflect.Ptr
+	if isPtrElem {
+		elemT = elemT.Elem()
+	}
+
+	conv, err := d.elemConverter(elemT, field)
+	if err != nil && !m.IsValid {
+		return nil, err
+	}
+
+	// zero returns the element for empty values,
+	// allocating it for slices of pointers.
+	zero := func() reflect.Value {
+		if isPtrElem {
+			return d.alloc(elemT)
+		}
+		return reflect.Zero(elemT)
+	}
+
+	isSlice := t.Kind() == reflect.Slice
+	if isSlice {
+		if err := d.sliceLenError(path, len(values)); err != nil {
+			return nil, err
+		}
+	}
+
+	// split splits the value by sep, after n elements, failing before
+	// splitting if the slice would be longer than MaxSliceLen allows.
+	// Quoted elements can hold separators, so values with quotes are
+	// only checked once split.
+	split := func(value string, sep string, n int) ([]string, error) {
+		if !isSlice {
+			return splitRecord(value, sep), nil
+		}
+		if !strings.Contains(value, `"`) {
+			if err := d.sliceLenError(path, n+strings.Count(value, sep)+1); err != nil {
+				return nil, err
+			}
+		}
+		values := splitRecord(value, sep)
+		if err := d.sliceLenError(path, n+len(values)); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	if field.hasDelim && field.delim != "" {
+		var all []string
+		for i, value := range values {
+			// The values yet to be split count as an element each.
+			elems, err := split(value, field.delim, len(all)+len(values)-i-1)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, elems...)
+		}
+		values = all
+	}
+
+	for key, value := range values {
+		if value == "" {
+			if d.zeroEmpty {
+				items = append(items, zero())
+			}
+		} else if m.IsValid {
+			u := reflect.New(elemT)
+			if isPtrElem {
+				u = d.alloc(elemT)
+			}
+			if err := u.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+				return nil, ConversionError{
+					Key:   path,
+					Type:  t,
+					Index: key,
+					Value: field.errorValue(value),
+					Err:   err,
+				}
+			}
+			if isPtrElem {
+				items = append(items, u)
+			} else {
+				items = append(items, u.Elem())
+			}
+		} else if item, err := conv(value); err == nil && item.IsValid() {
+			items = append(items, d.elemItem(item, elemT, isPtrElem))
+		} else {
+			if !field.explode && !field.hasDelim && strings.Contains(value, ",") {
+				elems, err := split(value, ",", len(items)+len(values)-key-1)
+				if err != nil {
+					return nil, err
+				}
+				for _, value := range elems {
+					if value == "" {
+						if d.zeroEmpty {
+							items = append(items, zero())
+						}
+					} else if item, err := conv(value); err == nil && item.IsValid() {
+						items = append(items, d.elemItem(item, elemT, isPtrElem))
+					} else {
+						return nil, ConversionError{
+							Key:   path,
+							Type:  elemT,
+							Index: key,
+							Value: field.errorValue(value),
+							Err:   err,
+						}
+					}
+				}
+			} else {
+				return nil, ConversionError{
+					Key:   path,
+					Type:  elemT,
+					Index: key,
+					Value: field.errorValue(value),
+					Err:   err,
+				}
+			}
+		}
+	}
+	if isSlice {
+		if err := d.sliceLenError(path, len(items)); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
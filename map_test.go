@@ -0,0 +1,1974 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeArray(t *testing.T) {
+	type request struct {
+		Color [3]float64 `query:"color"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   [3]float64
+		count  int
+	}{
+		{name: "comma separated", target: "/?color=1,0.5,0", want: [3]float64{1, 0.5, 0}},
+		{name: "repeated keys", target: "/?color=1&color=0.5&color=0", want: [3]float64{1, 0.5, 0}},
+		{name: "fewer values", target: "/?color=1", want: [3]float64{1, 0, 0}},
+		{name: "too many comma separated", target: "/?color=1,2,3,4", count: 4},
+		{name: "too many repeated keys", target: "/?color=1&color=2&color=3&color=4", count: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.count > 0 {
+				e, ok := keyError(err, "color").(ArrayLengthError)
+				if !ok {
+					t.Fatalf("got error %v, want an ArrayLengthError", err)
+				}
+				if e.Length != 3 || e.Count != tt.count {
+					t.Errorf("got length %d and count %d, want 3 and %d", e.Length, e.Count, tt.count)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dst.Color != tt.want {
+				t.Errorf("got %v, want %v", dst.Color, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitHeaderList(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{name: "single", values: []string{"a"}, want: []string{"a"}},
+		{name: "trimmed", values: []string{" a ,b,  c"}, want: []string{"a", "b", "c"}},
+		{name: "empty elements", values: []string{"a,, ,b,"}, want: []string{"a", "b"}},
+		{name: "many values", values: []string{"a, b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "quoted comma", values: []string{`"a, b", c`}, want: []string{"a, b", "c"}},
+		{name: "escaped quote", values: []string{`"a \"b\", c", d`}, want: []string{`a "b", c`, "d"}},
+		{name: "quoted parameter", values: []string{`text/html;q="0.5, x", b`}, want: []string{`text/html;q="0.5, x"`, "b"}},
+		{name: "unterminated quote", values: []string{`"a, b`}, want: []string{`"a, b`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitHeaderList(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRecord(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		sep   string
+		want  []string
+	}{
+		{name: "plain", value: "a,b,c", sep: ",", want: []string{"a", "b", "c"}},
+		{name: "quoted delimiter", value: `"Smith, John",Doe`, sep: ",", want: []string{"Smith, John", "Doe"}},
+		{name: "escaped quote", value: `"say ""hi""",b`, sep: ",", want: []string{`say "hi"`, "b"}},
+		{name: "other delimiter", value: `"a|b"|c`, sep: "|", want: []string{"a|b", "c"}},
+		{name: "empty elements", value: `a,,"b"`, sep: ",", want: []string{"a", "", "b"}},
+		{name: "invalid record", value: `a"b,c`, sep: ",", want: []string{`a"b`, "c"}},
+		{name: "unterminated quote", value: `"a,b`, sep: ",", want: []string{`"a`, "b"}},
+		{name: "many records", value: "\"a\"\nb,c", sep: ",", want: []string{"\"a\"\nb", "c"}},
+		{name: "multi-rune delimiter", value: `"a::b"::c`, sep: "::", want: []string{`"a`, `b"`, "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRecord(tt.value, tt.sep); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeQuotedDelimiters(t *testing.T) {
+	type request struct {
+		Names []string `query:"names" delim:","`
+		Tags  []string `query:"tags" delim:"|"`
+		IDs   []int    `query:"ids"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   request
+	}{
+		{name: "quoted comma", target: "/?names=%22Smith,+John%22,Doe", want: request{Names: []string{"Smith, John", "Doe"}}},
+		{name: "quoted delimiter", target: "/?tags=%22a|b%22|c", want: request{Tags: []string{"a|b", "c"}}},
+		{name: "unquoted", target: "/?names=a,b&ids=1,2", want: request{Names: []string{"a", "b"}, IDs: []int{1, 2}}},
+		{name: "quoted numbers", target: "/?ids=%221%22,2", want: request{IDs: []int{1, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			if err := NewDecoder().Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeaderListFields(t *testing.T) {
+	type request struct {
+		Accept   []string `header:"Accept"`
+		Language string   `header:"Accept-Language"`
+	}
+	tests := []struct {
+		name     string
+		list     bool
+		accept   string
+		language string
+		want     request
+	}{
+		{name: "list", list: true, accept: "a, b, c", language: "en, fr", want: request{Accept: []string{"a", "b", "c"}, Language: "en"}},
+		{name: "quoted list", list: true, accept: `"a, b", c`, want: request{Accept: []string{"a, b", "c"}}},
+		{name: "not list", accept: "a, b, c", language: "en, fr", want: request{Accept: []string{"a, b, c"}, Language: "en, fr"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.HeaderListFields(tt.list)
+			r := newRequest("GET", "/", "", "")
+			r.Header.Set("Accept", tt.accept)
+			if tt.language != "" {
+				r.Header.Set("Accept-Language", tt.language)
+			}
+			var dst request
+			if err := d.Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %#v, want %#v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeaderCasings(t *testing.T) {
+	type request struct {
+		RequestID string   `header:"x-request-id"`
+		Tags      []string `header:"X-TAGS"`
+	}
+	tests := []struct {
+		name   string
+		header map[string][]string
+		want   request
+	}{
+		{name: "canonical", header: map[string][]string{"X-Request-Id": {"a"}}, want: request{RequestID: "a"}},
+		{name: "lower case", header: map[string][]string{"x-request-id": {"a"}}, want: request{RequestID: "a"}},
+		{name: "upper case", header: map[string][]string{"X-REQUEST-ID": {"a"}}, want: request{RequestID: "a"}},
+		{name: "upper case alias", header: map[string][]string{"X-Tags": {"a", "b"}}, want: request{Tags: []string{"a", "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest("GET", "/", "", "")
+			r.Header = tt.header
+			var dst request
+			if err := NewDecoder().Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %#v, want %#v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestConversionErrorValue(t *testing.T) {
+	type request struct {
+		Age    int    `query:"age"`
+		IDs    []int  `query:"ids"`
+		Pin    int    `query:"pin" secret:"true"`
+		Count  int    `json:"count"`
+		Secret int    `json:"secret" secret:"true"`
+		Page   string `query:"page"`
+	}
+	tests := []struct {
+		name   string
+		direct bool
+		target string
+		body   string
+		key    string
+		index  int
+		value  string
+	}{
+		{name: "single", target: "/?age=abc", key: "age", index: -1, value: "abc"},
+		{name: "slice element", target: "/?ids=1&ids=x", key: "ids", index: 1, value: "x"},
+		{name: "secret", target: "/?pin=abc", key: "pin", index: -1, value: ""},
+		{name: "json", target: "/", body: `{"count":"abc"}`, key: "count", index: -1, value: "abc"},
+		{name: "direct json", direct: true, target: "/", body: `{"count":"abc"}`, key: "count", index: -1, value: `"abc"`},
+		{name: "direct json object", direct: true, target: "/", body: `{"count":{"a":1}}`, key: "count", index: -1, value: `{"a":1}`},
+		{name: "direct json secret", direct: true, target: "/", body: `{"secret":"abc"}`, key: "secret", index: -1, value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.DirectJSON(tt.direct)
+			body := tt.body
+			if body == "" {
+				body = "{}"
+			}
+			var dst request
+			err := d.Decode(&dst, newRequest("POST", tt.target, "application/json", body))
+			e, ok := keyError(err, tt.key).(ConversionError)
+			if !ok {
+				t.Fatalf("got error %v, want a ConversionError for %q", err, tt.key)
+			}
+			if e.Index != tt.index || e.Value != tt.value {
+				t.Errorf("got index %d and value %q, want %d and %q", e.Index, e.Value, tt.index, tt.value)
+			}
+		})
+	}
+}
+
+func TestDirectJSON(t *testing.T) {
+	type inner struct {
+		A int `json:"a"`
+	}
+	type request struct {
+		ID     string            `query:"id"`
+		Big    int64             `json:"big"`
+		Inner  inner             `json:"inner"`
+		Values []float64         `json:"values"`
+		Labels map[string]string `json:"labels"`
+	}
+	tests := []struct {
+		name   string
+		direct bool
+		body   string
+		want   request
+	}{
+		{
+			name:   "direct",
+			direct: true,
+			body:   `{"big":9007199254740993,"inner":{"a":1},"values":[1.5,2],"labels":{"k":"v"}}`,
+			want:   request{ID: "1", Big: 9007199254740993, Inner: inner{A: 1}, Values: []float64{1.5, 2}, Labels: map[string]string{"k": "v"}},
+		},
+		{
+			name: "flattened",
+			body: `{"big":9007199254740993,"inner":{"a":1},"values":[1.5,2]}`,
+			want: request{ID: "1", Big: 9007199254740993, Inner: inner{A: 1}, Values: []float64{1.5, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.DirectJSON(tt.direct)
+			var dst request
+			if err := d.Decode(&dst, newRequest("POST", "/?id=1", "application/json", tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectJSONUnknownKey(t *testing.T) {
+	type request struct {
+		ID   string `query:"id"`
+		Name string `json:"name"`
+	}
+	d := NewDecoder()
+	d.DirectJSON(true)
+	d.IgnoreUnknownKeys(false)
+	var dst request
+	err := d.Decode(&dst, newRequest("POST", "/?id=1", "application/json", `{"name":"a","other":1}`))
+	if _, ok := keyError(err, "other").(UnknownKeyError); !ok {
+		t.Errorf("got error %v, want an UnknownKeyError", err)
+	}
+}
+
+type DirectBase struct {
+	Base string `json:"base"`
+}
+
+func TestDirectJSONEmbeddedPointer(t *testing.T) {
+	type request struct {
+		ID string `query:"id"`
+		*DirectBase
+	}
+	d := NewDecoder()
+	d.DirectJSON(true)
+	var dst request
+	if err := d.Decode(&dst, newRequest("POST", "/?id=1", "application/json", `{"base":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if want := (request{ID: "1", DirectBase: &DirectBase{Base: "a"}}); !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string][]string
+		invalid bool
+	}{
+		{name: "scalars", body: `{"x":[1,true,"a"]}`, want: map[string][]string{"x": {"1", "true", "a"}}},
+		{name: "objects", body: `{"x":[{"a":1},{"a":2}]}`, want: map[string][]string{"x.0.a": {"1"}, "x.1.a": {"2"}}},
+		{name: "nested", body: `{"x":{"y":{"z":1}}}`, want: map[string][]string{"x.y.z": {"1"}}},
+		{name: "nulls", body: `{"x":null,"y":[null,1]}`, want: map[string][]string{"y": {"1"}}},
+		{name: "mixed", body: `{"x":[1,{"a":2}]}`, invalid: true},
+		{name: "nested mixed", body: `{"x":{"y":[{"a":2},"b"]}}`, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m map[string]interface{}
+			dec := json.NewDecoder(strings.NewReader(tt.body))
+			dec.UseNumber()
+			if err := dec.Decode(&m); err != nil {
+				t.Fatal(err)
+			}
+			got, err := separators{sep: '.'}.flatten(m, nil)
+			if tt.invalid {
+				if _, ok := err.(ParsingError); !ok {
+					t.Errorf("got error %v, want a ParsingError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONString(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{name: "integer", v: json.Number("12"), want: "12"},
+		{name: "big integer", v: json.Number("12345678901234567890"), want: "12345678901234567890"},
+		{name: "fraction", v: json.Number("1.5"), want: "1.5"},
+		{name: "small fraction", v: json.Number("1.5e-7"), want: "1.5e-7"},
+		{name: "zero fraction", v: json.Number("2.0"), want: "2"},
+		{name: "big zero fraction", v: json.Number("12345678901234567890.0"), want: "12345678901234567890"},
+		{name: "fraction close to whole", v: json.Number("1.0000000000000001"), want: "1.0000000000000001"},
+		{name: "exponent", v: json.Number("1e6"), want: "1000000"},
+		{name: "exponent with sign", v: json.Number("1E+6"), want: "1000000"},
+		{name: "fraction and exponent", v: json.Number("1.25e2"), want: "125"},
+		{name: "negative", v: json.Number("-1.2e1"), want: "-12"},
+		{name: "negative exponent", v: json.Number("1200e-2"), want: "12"},
+		{name: "leading zeros", v: json.Number("0.0012e4"), want: "12"},
+		{name: "zero", v: json.Number("0.0"), want: "0"},
+		{name: "zero exponent", v: json.Number("0e10"), want: "0"},
+		{name: "big exponent", v: json.Number("1e400"), want: "1e400"},
+		{name: "float", v: 1e21, want: "1000000000000000000000"},
+		{name: "float fraction", v: 0.5, want: "0.5"},
+		{name: "true", v: true, want: "true"},
+		{name: "false", v: false, want: "false"},
+		{name: "string", v: "1e6", want: "1e6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jsonString(tt.v); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONArrays(t *testing.T) {
+	type request struct {
+		Flags  []bool    `json:"flags"`
+		IDs    []int64   `json:"ids"`
+		Scores []float64 `json:"scores"`
+		Page   int       `query:"page"`
+	}
+	tests := []struct {
+		name string
+		body string
+		want request
+		key  string
+	}{
+		{name: "bools", body: `{"flags":[true,false,true]}`, want: request{Flags: []bool{true, false, true}}},
+		{name: "int64s", body: `{"ids":[1,-2,9223372036854775807]}`, want: request{IDs: []int64{1, -2, 9223372036854775807}}},
+		{name: "whole int64s", body: `{"ids":[1e3,2.0,1.5e1]}`, want: request{IDs: []int64{1000, 2, 15}}},
+		{name: "float64s", body: `{"scores":[1.5,2,1e-3,1e21]}`, want: request{Scores: []float64{1.5, 2, 0.001, 1e21}}},
+		{name: "int64 fraction", body: `{"ids":[1.5]}`, key: "ids"},
+		{name: "bool number", body: `{"flags":[1]}`, want: request{Flags: []bool{true}}},
+		{name: "bool string", body: `{"flags":["maybe"]}`, key: "flags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("POST", "/?page=1", "application/json", tt.body))
+			if tt.key != "" {
+				if _, ok := keyError(err, tt.key).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Page = 1
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMixedJSONArray(t *testing.T) {
+	type request struct {
+		X []int `json:"x"`
+	}
+	var dst request
+	err := NewDecoder().Decode(&dst, newRequest("POST", "/", "application/json", `{"x":[1,{"a":2}]}`))
+	if _, ok := err.(ParsingError); !ok {
+		t.Errorf("got error %v, want a ParsingError", err)
+	}
+}
+
+func TestEmptyPointerAsPresent(t *testing.T) {
+	type request struct {
+		Name    *string   `query:"name"`
+		Verbose *bool     `query:"verbose"`
+		Tags    *[]string `query:"tags"`
+		Note    *string   `json:"note"`
+	}
+	empty, a := "", "a"
+	yes := true
+	tests := []struct {
+		name      string
+		present   bool
+		zeroEmpty bool
+		boolTrue  bool
+		target    string
+		body      string
+		want      request
+	}{
+		{name: "missing", target: "/"},
+		{name: "empty", target: "/?name="},
+		{name: "empty zeroed", zeroEmpty: true, target: "/?name="},
+		{name: "empty present", present: true, target: "/?name=", want: request{Name: &empty}},
+		{name: "value", target: "/?name=a", want: request{Name: &a}},
+		{name: "bool presence", boolTrue: true, target: "/?verbose", want: request{Verbose: &yes}},
+		{name: "empty slice", target: "/?tags=&tags="},
+		{name: "json empty string", target: "/", body: `{"note":""}`, want: request{Note: &empty}},
+		{name: "json null", target: "/", body: `{"note":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.EmptyPointerAsPresent(tt.present)
+			d.ZeroEmpty(tt.zeroEmpty)
+			d.BoolPresenceTrue(tt.boolTrue)
+			body := tt.body
+			if body == "" {
+				body = "{}"
+			}
+			var dst request
+			if err := d.Decode(&dst, newRequest("POST", tt.target, "application/json", body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestLocations(t *testing.T) {
+	type request struct {
+		RemoteAddr string `name:"ip" from:"remoteaddr"`
+		Method     string `name:"method" from:"method"`
+		Host       string `name:"host" from:"host"`
+		Path       string `name:"path" from:"urlpath"`
+		ID         string `query:"id"`
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   request
+	}{
+		{
+			name:   "get",
+			method: "GET",
+			target: "http://example.com/users?id=1",
+			want:   request{RemoteAddr: "192.0.2.1:1234", Method: "GET", Host: "example.com", Path: "/users", ID: "1"},
+		},
+		{
+			name:   "delete",
+			method: "DELETE",
+			target: "http://api.example.com/a/b",
+			want:   request{RemoteAddr: "192.0.2.1:1234", Method: "DELETE", Host: "api.example.com", Path: "/a/b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			if err := NewDecoder().Decode(&dst, newRequest(tt.method, tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if dst != tt.want {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestLocationsOrder(t *testing.T) {
+	type request struct {
+		Values []string `name:"values" from:"urlpath,host,method,remoteaddr"`
+	}
+	want := []string{"192.0.2.1:1234", "GET", "example.com", "/users"}
+	for i := 0; i < 20; i++ {
+		var dst request
+		if err := NewDecoder().Decode(&dst, newRequest("GET", "http://example.com/users", "", "")); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dst.Values, want) {
+			t.Fatalf("got %q, want %q", dst.Values, want)
+		}
+	}
+}
+
+func TestRequestLocationsNotFromParams(t *testing.T) {
+	type request struct {
+		Method string `name:"method" from:"method"`
+	}
+	var dst request
+	if err := NewDecoder().Decode(&dst, newRequest("GET", "/?method=POST", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Method != "GET" {
+		t.Errorf("got method %q, want GET", dst.Method)
+	}
+}
+
+func TestNestedJSONSeparators(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+	}
+	type item struct {
+		ID int `json:"id"`
+	}
+	// The query field keeps the body from being decoded directly,
+	// so the JSON keys are flattened.
+	type request struct {
+		ID      string  `query:"id"`
+		Address address `json:"address"`
+		Items   []item  `json:"items"`
+	}
+	const body = `{"address":{"city":"a"},"items":[{"id":1},{"id":2}]}`
+	want := request{ID: "1", Address: address{City: "a"}, Items: []item{{ID: 1}, {ID: 2}}}
+	tests := []struct {
+		name             string
+		left, right, sep rune
+	}{
+		{name: "default", sep: '.'},
+		{name: "brackets", left: '[', right: ']', sep: '.'},
+		{name: "slash", sep: '/'},
+		{name: "brackets without separator", left: '[', right: ']'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.Separator(tt.left, tt.right, tt.sep)
+			var dst request
+			if err := d.Decode(&dst, newRequest("POST", "/?id=1", "application/json", body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, want) {
+				t.Errorf("got %+v, want %+v", dst, want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeaderPairs(t *testing.T) {
+	type request struct {
+		Prefer map[string]string `header:"Prefer"`
+		Limits map[string]int    `header:"X-Limits" delim:";"`
+		Labels map[string]string `query:"labels"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+		want   request
+		key    string
+	}{
+		{
+			name:   "prefer",
+			target: "/",
+			header: map[string]string{"Prefer": "return=minimal, wait=10, respond-async"},
+			want:   request{Prefer: map[string]string{"return": "minimal", "wait": "10", "respond-async": ""}},
+		},
+		{
+			name:   "quoted value",
+			target: "/",
+			header: map[string]string{"Prefer": `handling="lenient"`},
+			want:   request{Prefer: map[string]string{"handling": "lenient"}},
+		},
+		{
+			name:   "delimiter",
+			target: "/",
+			header: map[string]string{"X-Limits": "a=1; b=2"},
+			want:   request{Limits: map[string]int{"a": 1, "b": 2}},
+		},
+		{
+			name:   "invalid value",
+			target: "/",
+			header: map[string]string{"X-Limits": "a=x"},
+			key:    "X-Limits",
+		},
+		{
+			name:   "query prefixed keys",
+			target: "/?labels.a=1&labels.b=2",
+			want:   request{Labels: map[string]string{"a": "1", "b": "2"}},
+		},
+		{
+			name:   "query pairs",
+			target: "/?labels=a=1,b=2",
+			key:    "labels",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest("GET", tt.target, "", "")
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			var dst request
+			err := NewDecoder().Decode(&dst, r)
+			if tt.key != "" {
+				if _, ok := keyError(err, tt.key).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError for %q", err, tt.key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSparseIndices(t *testing.T) {
+	type request struct {
+		Items []string `query:"items"`
+		IDs   []int    `query:"ids"`
+		Ptrs  []*int   `query:"ptrs"`
+	}
+	one, three := 1, 3
+	tests := []struct {
+		name      string
+		separator bool
+		target    string
+		want      request
+	}{
+		{name: "sparse", target: "/?items.0=a&items.2=c", want: request{Items: []string{"a", "", "c"}}},
+		{name: "out of order", target: "/?ids.2=3&ids.0=1&ids.1=2", want: request{IDs: []int{1, 2, 3}}},
+		{name: "brackets", separator: true, target: "/?items[1]=b&items[0]=a", want: request{Items: []string{"a", "b"}}},
+		{name: "pointers", target: "/?ptrs.2=3&ptrs.0=1", want: request{Ptrs: []*int{&one, nil, &three}}},
+		{name: "repeated index", target: "/?items.1=a&items.1=b", want: request{Items: []string{"", "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			if tt.separator {
+				d.Separator('[', ']', 0)
+			}
+			var dst request
+			if err := d.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSparseIndicesLimit(t *testing.T) {
+	type request struct {
+		Items []string `query:"items"`
+	}
+	d := NewDecoder()
+	d.MaxSliceLen(3)
+	var dst request
+	err := d.Decode(&dst, newRequest("GET", "/?items.5=a", "", ""))
+	if _, ok := keyError(err, "items.5").(LimitExceededError); !ok {
+		t.Errorf("got error %v, want a LimitExceededError", err)
+	}
+}
+
+type RawEmbedded struct {
+	Extra json.RawMessage `json:"extra"`
+}
+
+func TestDecodeRawJSON(t *testing.T) {
+	type request struct {
+		*RawEmbedded
+		ID      string           `query:"id"`
+		Name    string           `json:"name"`
+		Payload json.RawMessage  `json:"payload"`
+		Ptr     *json.RawMessage `json:"ptr"`
+	}
+	tests := []struct {
+		name string
+		body string
+		want request
+	}{
+		{
+			name: "nested object",
+			body: `{"name":"a","payload":{"b":[1,2],"c":{"d":null}}}`,
+			want: request{ID: "1", Name: "a", Payload: json.RawMessage(`{"b":[1,2],"c":{"d":null}}`)},
+		},
+		{
+			name: "scalar and pointer",
+			body: `{"payload":"x","ptr":[1]}`,
+			want: request{ID: "1", Payload: json.RawMessage(`"x"`), Ptr: func() *json.RawMessage { m := json.RawMessage(`[1]`); return &m }()},
+		},
+		{
+			name: "embedded pointer",
+			body: `{"extra":{"a":1}}`,
+			want: request{ID: "1", RawEmbedded: &RawEmbedded{Extra: json.RawMessage(`{"a":1}`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			if err := NewDecoder().Decode(&dst, newRequest("POST", "/?id=1", "application/json", tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripScheme(t *testing.T) {
+	type request struct {
+		Token  string   `header:"Authorization,strip=Bearer"`
+		Tokens []string `header:"X-Tokens,strip=Token"`
+		Query  string   `query:"auth,strip=Bearer"`
+	}
+	tests := []struct {
+		name   string
+		header map[string][]string
+		target string
+		want   request
+	}{
+		{name: "bearer", header: map[string][]string{"Authorization": {"Bearer abc"}}, want: request{Token: "abc"}},
+		{name: "case insensitive", header: map[string][]string{"Authorization": {"bearer abc"}}, want: request{Token: "abc"}},
+		{name: "extra whitespace", header: map[string][]string{"Authorization": {"  Bearer \t abc  "}}, want: request{Token: "abc"}},
+		{name: "other scheme", header: map[string][]string{"Authorization": {"Basic YWJj"}}},
+		{name: "missing scheme", header: map[string][]string{"Authorization": {"abc"}}},
+		{name: "scheme only", header: map[string][]string{"Authorization": {"Bearer"}}},
+		{name: "prefix of a word", header: map[string][]string{"Authorization": {"Bearerabc"}}},
+		{name: "many values", header: map[string][]string{"X-Tokens": {"Token a", "Basic b", "Token c"}}, want: request{Tokens: []string{"a", "c"}}},
+		{name: "not header", target: "/?auth=Bearer+abc", want: request{Query: "Bearer abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := newRequest("GET", target, "", "")
+			for k, vs := range tt.header {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+			var dst request
+			if err := NewDecoder().Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestLazyNil(t *testing.T) {
+	type address struct {
+		City string `query:"city"`
+		Zip  string `query:"zip"`
+	}
+	type request struct {
+		ID      string   `query:"id"`
+		Address *address `query:"address"`
+	}
+	tests := []struct {
+		name      string
+		lazy      bool
+		zeroEmpty bool
+		target    string
+		want      *address
+	}{
+		{name: "no fields", lazy: true, target: "/?id=1"},
+		{name: "empty values", lazy: true, target: "/?address.city=&address.zip="},
+		{name: "empty zeroed values", lazy: true, zeroEmpty: true, target: "/?address.city="},
+		{name: "one value", lazy: true, target: "/?address.city=a&address.zip=", want: &address{City: "a"}},
+		{name: "all values", lazy: true, target: "/?address.city=a&address.zip=1", want: &address{City: "a", Zip: "1"}},
+		{name: "not lazy no fields", target: "/?id=1"},
+		{name: "not lazy empty values", target: "/?address.city=", want: &address{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.LazyNil(tt.lazy)
+			d.ZeroEmpty(tt.zeroEmpty)
+			var dst request
+			if err := d.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst.Address, tt.want) {
+				t.Errorf("got %+v, want %+v", dst.Address, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelimTag(t *testing.T) {
+	type request struct {
+		Pipe   []string `query:"pipe" delim:"|"`
+		Semi   []int    `query:"semi" delim:";"`
+		None   []string `query:"none" delim:""`
+		Quoted []string `query:"quoted" delim:"|"`
+		Comma  []string `query:"comma"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   request
+	}{
+		{name: "pipe", target: "/?pipe=a|b|c", want: request{Pipe: []string{"a", "b", "c"}}},
+		{name: "pipe keeps commas", target: "/?pipe=a,b|c", want: request{Pipe: []string{"a,b", "c"}}},
+		{name: "semicolon", target: "/?semi=1%3B2%3B3", want: request{Semi: []int{1, 2, 3}}},
+		{name: "repeated keys", target: "/?pipe=a|b&pipe=c", want: request{Pipe: []string{"a", "b", "c"}}},
+		{name: "empty delimiter", target: "/?none=a,b|c", want: request{None: []string{"a,b|c"}}},
+		{name: "quoted", target: `/?quoted="a|b"|c`, want: request{Quoted: []string{"a|b", "c"}}},
+		{name: "default", target: "/?comma=a,b", want: request{Comma: []string{"a,b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			if err := NewDecoder().Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %q, want %q", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnum(t *testing.T) {
+	type mode struct {
+		Mode string `json:"mode" enum:"fast,slow"`
+	}
+	type jsonOnly struct {
+		Color  string `json:"color" enum:"red,green"`
+		Nested mode   `json:"nested"`
+		Modes  []mode `json:"modes"`
+	}
+	type mixed struct {
+		Size   string   `query:"size" enum:"S,M,L"`
+		Sizes  []string `query:"sizes" enum:"S,M,L"`
+		Color  string   `json:"color" enum:"red,green"`
+		Nested mode     `json:"nested"`
+	}
+	direct := func(d *Decoder) { d.DirectJSON(true) }
+	caseSensitive := func(d *Decoder) { d.CaseSensitiveJSON(true) }
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		opts   func(*Decoder)
+		dst    interface{}
+		want   interface{}
+		errKey string
+	}{
+		{name: "json", body: `{"color":"red","nested":{"mode":"fast"}}`, dst: &jsonOnly{}, want: &jsonOnly{Color: "red", Nested: mode{Mode: "fast"}}},
+		{name: "json invalid", body: `{"color":"blue"}`, dst: &jsonOnly{}, errKey: "color"},
+		{name: "json nested invalid", body: `{"nested":{"mode":"medium"}}`, dst: &jsonOnly{}, errKey: "nested.mode"},
+		{name: "json nested slice invalid", body: `{"modes":[{"mode":"fast"},{"mode":"medium"}]}`, dst: &jsonOnly{}, errKey: "modes.1.mode"},
+		{name: "json case", body: `{"color":"RED","modes":[{"mode":"Slow"}]}`, dst: &jsonOnly{}, want: &jsonOnly{Color: "red", Modes: []mode{{Mode: "slow"}}}},
+		{name: "json case sensitive", body: `{"color":"RED"}`, opts: caseSensitive, dst: &jsonOnly{}, errKey: "color"},
+		{name: "query", target: "/?size=M&sizes=S&sizes=L", dst: &mixed{}, want: &mixed{Size: "M", Sizes: []string{"S", "L"}}},
+		{name: "query invalid", target: "/?size=XL", dst: &mixed{}, errKey: "size"},
+		{name: "query slice invalid", target: "/?sizes=S&sizes=XL", dst: &mixed{}, errKey: "sizes"},
+		{name: "query case", target: "/?size=m", dst: &mixed{}, want: &mixed{Size: "M"}},
+		{name: "query case with case sensitive json", target: "/?size=m", opts: caseSensitive, dst: &mixed{}, want: &mixed{Size: "M"}},
+		{name: "mixed json", target: "/?size=S", body: `{"color":"Green","nested":{"mode":"FAST"}}`, dst: &mixed{}, want: &mixed{Size: "S", Color: "green", Nested: mode{Mode: "fast"}}},
+		{name: "direct json", target: "/?size=S", body: `{"color":"Green","nested":{"mode":"FAST"}}`, opts: direct, dst: &mixed{}, want: &mixed{Size: "S", Color: "green", Nested: mode{Mode: "fast"}}},
+		{name: "direct json invalid", target: "/?size=S", body: `{"color":"blue"}`, opts: direct, dst: &mixed{}, errKey: "color"},
+		{name: "direct json nested invalid", target: "/?size=S", body: `{"nested":{"mode":"medium"}}`, opts: direct, dst: &mixed{}, errKey: "nested.mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			if tt.opts != nil {
+				tt.opts(d)
+			}
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			err := d.Decode(tt.dst, newRequest("POST", target, "application/json", body))
+			if tt.errKey != "" {
+				if e, ok := keyError(err, tt.errKey).(EnumError); !ok || e.Key != tt.errKey {
+					t.Fatalf("got %v, want an EnumError for %q", err, tt.errKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeLocations(t *testing.T) {
+	type request struct {
+		Name string   `name:"name" from:"query,form"`
+		Tags []string `name:"tags" from:"query,form"`
+		IDs  []int    `name:"ids" from:"header,query"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		header map[string]string
+		want   request
+	}{
+		{name: "query", target: "/?name=q&tags=a&tags=b", want: request{Name: "q", Tags: []string{"a", "b"}}},
+		{name: "form", body: "name=f&tags=c", want: request{Name: "f", Tags: []string{"c"}}},
+		{name: "scalar precedence", target: "/?name=q", body: "name=f", want: request{Name: "q"}},
+		{name: "slices concatenated", target: "/?tags=a&tags=b", body: "tags=c&tags=d", want: request{Tags: []string{"a", "b", "c", "d"}}},
+		{name: "both", target: "/?name=q&tags=a", body: "name=f&tags=b", want: request{Name: "q", Tags: []string{"a", "b"}}},
+		{name: "header first", target: "/?ids=2", header: map[string]string{"Ids": "1"}, want: request{IDs: []int{1, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := newRequest("POST", target, "application/x-www-form-urlencoded", tt.body)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			var dst request
+			if err := NewDecoder().Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownJSONKeysOrder(t *testing.T) {
+	type request struct {
+		Page int    `query:"page"`
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "sorted", body: `{"zeta":1,"alpha":2,"name":"a"}`, want: "alpha"},
+		{name: "natural order", body: `{"key10":1,"key9":2,"key2":3}`, want: "key2"},
+		{name: "nested", body: `{"b":{"c":1},"a":{"d":2}}`, want: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				d := NewDecoder()
+				d.IgnoreUnknownKeys(false)
+				var dst request
+				err := d.Decode(&dst, newRequest("POST", "/?page=1", "application/json", tt.body))
+				e, ok := keyError(err, tt.want).(UnknownKeyError)
+				if !ok {
+					t.Fatalf("got %v, want an UnknownKeyError", err)
+				}
+				if e.Key != tt.want {
+					t.Fatalf("got unknown key %q, want %q", e.Key, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBytes(t *testing.T) {
+	type request struct {
+		Page int    `query:"page"`
+		Std  []byte `name:"std" from:"query,json"`
+		URL  []byte `name:"url" from:"query,json" encoding:"base64url"`
+		Hex  []byte `name:"hex" from:"query,json" encoding:"hex"`
+	}
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		want    request
+		wantErr string
+	}{
+		{name: "std", target: "/?std=aGVsbG8=", want: request{Std: []byte("hello")}},
+		{name: "std without padding", target: "/?std=aGVsbG8", want: request{Std: []byte("hello")}},
+		{name: "url", target: "/?url=-_8", want: request{URL: []byte{0xfb, 0xff}}},
+		{name: "hex", target: "/?hex=68690a", want: request{Hex: []byte("hi\n")}},
+		{name: "all values", target: "/?std=aGVs&std=bG8=", want: request{Std: []byte("hello")}},
+		{name: "invalid", target: "/?std=a$b", wantErr: "std"},
+		{name: "json string", target: "/?page=1", body: `{"std":"aGVsbG8="}`, want: request{Page: 1, Std: []byte("hello")}},
+		{name: "json array", target: "/?page=1", body: `{"std":[104,105]}`, want: request{Page: 1, Std: []byte("hi")}},
+		{name: "json array of one", target: "/?page=1", body: `{"hex":[104]}`, want: request{Page: 1, Hex: []byte("h")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.body
+			if body == "" {
+				body = "{}"
+			}
+			var dst request
+			err := NewDecoder().Decode(&dst, newRequest("POST", tt.target, "application/json", body))
+			if tt.wantErr != "" {
+				if _, ok := keyError(err, tt.wantErr).(ConversionError); !ok {
+					t.Fatalf("got %v, want a ConversionError for %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+type namedMap map[string]string
+
+type namedCounts map[string]int
+
+func TestDecodeNamedMaps(t *testing.T) {
+	type request struct {
+		Meta   namedMap    `query:"meta"`
+		Counts namedCounts `name:"counts" from:"query,json"`
+		Prefer namedMap    `header:"Prefer"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		header string
+		want   request
+	}{
+		{name: "prefixed keys", target: "/?meta.a=1&meta.b=2", want: request{Meta: namedMap{"a": "1", "b": "2"}}},
+		{name: "converted values", target: "/?counts.x=3&counts.y=4", want: request{Counts: namedCounts{"x": 3, "y": 4}}},
+		{name: "json object", target: "/?meta.a=1", body: `{"counts":{"z":5}}`, want: request{Meta: namedMap{"a": "1"}, Counts: namedCounts{"z": 5}}},
+		{name: "header pairs", header: "return=minimal, wait=10", want: request{Prefer: namedMap{"return": "minimal", "wait": "10"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			r := newRequest("POST", target, "application/json", body)
+			if tt.header != "" {
+				r.Header.Set("Prefer", tt.header)
+			}
+			var dst request
+			if err := NewDecoder().Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptySliceAsPresent(t *testing.T) {
+	type request struct {
+		Tags []string  `query:"tags"`
+		IDs  []int     `query:"ids"`
+		Ptr  *[]string `query:"ptr"`
+	}
+	empty := []string{}
+	tests := []struct {
+		name    string
+		target  string
+		present bool
+		want    request
+	}{
+		{name: "missing", target: "/", present: true, want: request{}},
+		{name: "empty", target: "/?tags=&ids=", present: true, want: request{Tags: []string{}, IDs: []int{}}},
+		{name: "empty pointer", target: "/?ptr=", present: true, want: request{Ptr: &empty}},
+		{name: "values", target: "/?tags=a&ids=1", present: true, want: request{Tags: []string{"a"}, IDs: []int{1}}},
+		{name: "disabled", target: "/?tags=&ids=", present: false, want: request{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.EmptySliceAsPresent(tt.present)
+			var dst request
+			if err := d.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %#v, want %#v", dst, tt.want)
+			}
+			if (dst.Tags == nil) != (tt.want.Tags == nil) {
+				t.Errorf("got nil tags %v, want %v", dst.Tags == nil, tt.want.Tags == nil)
+			}
+		})
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	type event struct {
+		At time.Time `json:"at" time_format_json:"2006-01-02"`
+	}
+	type jsonOnly struct {
+		Event event `json:"event"`
+	}
+	type mixed struct {
+		At    time.Time  `name:"at" from:"query,json" time_format_query:"unix" time_format_json:"2006-01-02 15:04"`
+		Milli *time.Time `query:"milli" time_format:"unixmilli"`
+	}
+	day := time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)
+	minute := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		direct bool
+		dst    interface{}
+		check  func(interface{}) bool
+	}{
+		{name: "query unix", target: "/?at=1589711400", dst: &mixed{}, check: func(v interface{}) bool { return v.(*mixed).At.Equal(minute) }},
+		{name: "query unix milli", target: "/?milli=1589711400000", dst: &mixed{}, check: func(v interface{}) bool { return v.(*mixed).Milli.Equal(minute) }},
+		{name: "json", body: `{"at":"2020-05-17 10:30"}`, dst: &mixed{}, check: func(v interface{}) bool { return v.(*mixed).At.Equal(minute) }},
+		{name: "direct json", target: "/?milli=1000", body: `{"at":"2020-05-17 10:30"}`, direct: true, dst: &mixed{}, check: func(v interface{}) bool { return v.(*mixed).At.Equal(minute) }},
+		{name: "nested json", body: `{"event":{"at":"2020-05-17"}}`, dst: &jsonOnly{}, check: func(v interface{}) bool { return v.(*jsonOnly).Event.At.Equal(day) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.DirectJSON(tt.direct)
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			if err := d.Decode(tt.dst, newRequest("POST", target, "application/json", body)); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(tt.dst) {
+				t.Errorf("got %+v", tt.dst)
+			}
+		})
+	}
+}
+
+type level int
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return errUnknownLevel
+	}
+	return nil
+}
+
+var errUnknownLevel = errors.New("unknown level")
+
+func TestDecodeTextUnmarshalerSlices(t *testing.T) {
+	type request struct {
+		Levels   []level  `query:"levels"`
+		Pointers []*level `query:"pointers"`
+		Numbers  []*int   `query:"numbers"`
+	}
+	low, high, zero := level(1), level(2), level(0)
+	one, none := 1, 0
+	tests := []struct {
+		name      string
+		zeroEmpty bool
+		target    string
+		want      request
+		wantErr   error
+	}{
+		{name: "values", target: "/?levels=low&levels=high", want: request{Levels: []level{1, 2}}},
+		{name: "pointers", target: "/?pointers=low&pointers=high", want: request{Pointers: []*level{&low, &high}}},
+		{name: "empty skipped", target: "/?pointers=low&pointers=", want: request{Pointers: []*level{&low}}},
+		{name: "empty zeroed", zeroEmpty: true, target: "/?pointers=&pointers=high", want: request{Pointers: []*level{&zero, &high}}},
+		{name: "int pointers zeroed", zeroEmpty: true, target: "/?numbers=1&numbers=", want: request{Numbers: []*int{&one, &none}}},
+		{name: "invalid", target: "/?pointers=low&pointers=medium", wantErr: errUnknownLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.ZeroEmpty(tt.zeroEmpty)
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantErr != nil {
+				if err := keyError(err, "pointers"); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+type Region string
+
+type Priority int
+
+func TestDecodeEmbeddedNonStructs(t *testing.T) {
+	type request struct {
+		Region   `from:"query"`
+		Priority `query:"p"`
+		Name     string `query:"name"`
+	}
+	type body struct {
+		Region
+		Name string `query:"name"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   request
+	}{
+		{name: "type name", target: "/?Region=eu", want: request{Region: "eu"}},
+		{name: "case insensitive", target: "/?region=eu&name=a", want: request{Region: "eu", Name: "a"}},
+		{name: "tagged", target: "/?p=3", want: request{Priority: 3}},
+		{name: "tag replaces type name", target: "/?Priority=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst request
+			if err := NewDecoder().Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+	t.Run("json", func(t *testing.T) {
+		var dst body
+		if err := NewDecoder().Decode(&dst, newRequest("POST", "/?name=a", "application/json", `{"Region":"us"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if want := (body{Region: "us", Name: "a"}); dst != want {
+			t.Errorf("got %+v, want %+v", dst, want)
+		}
+	})
+}
+
+func TestDecodePathParams(t *testing.T) {
+	type request struct {
+		UserID int      `path:"userId"`
+		PostID int      `path:"postId"`
+		Files  []string `path:"files"`
+	}
+	tests := []struct {
+		name    string
+		params  map[string]string
+		encoded bool
+		unknown bool
+		want    request
+		wantErr error
+	}{
+		{name: "distinct params", params: map[string]string{"userId": "1", "postId": "2"}, want: request{UserID: 1, PostID: 2}},
+		{name: "catch-all", params: map[string]string{"files": "a/b/c"}, want: request{Files: []string{"a", "b", "c"}}},
+		{name: "empty segments", params: map[string]string{"files": "/a//b/"}, want: request{Files: []string{"a", "b"}}},
+		{name: "encoded segments", encoded: true, params: map[string]string{"files": "a%2Fb/c%20d"}, want: request{Files: []string{"a/b", "c d"}}},
+		{name: "unknown ignored", params: map[string]string{"userId": "1", "slug": "x"}, want: request{UserID: 1}},
+		{name: "unknown", unknown: true, params: map[string]string{"userId": "1", "slug": "x"}, wantErr: UnknownKeyError{Key: "slug"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.PathExtractor(func(r *http.Request) map[string]string {
+				return tt.params
+			})
+			d.PathParamsEncoded(tt.encoded)
+			d.IgnoreUnknownKeys(!tt.unknown)
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", "/", "", ""))
+			if tt.wantErr != nil {
+				if err := keyError(err, "slug"); !reflect.DeepEqual(err, tt.wantErr) {
+					t.Fatalf("got %#v, want %#v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeWithPathParams(t *testing.T) {
+	type request struct {
+		ID   int    `path:"id"`
+		Page int    `query:"page"`
+		Name string `json:"name"`
+		Slug string `name:"slug" from:"path,query"`
+	}
+	extracted := map[string]string{"id": "9", "slug": "extracted"}
+	tests := []struct {
+		name       string
+		params     map[string]string
+		extractor  bool
+		precedence []int
+		target     string
+		want       request
+	}{
+		{
+			name:   "merged with other locations",
+			params: map[string]string{"id": "1"},
+			target: "/?page=2",
+			want:   request{ID: 1, Page: 2, Name: "a"},
+		},
+		{
+			name:   "path over query",
+			params: map[string]string{"slug": "p"},
+			target: "/?slug=q",
+			want:   request{Name: "a", Slug: "p"},
+		},
+		{
+			name:       "query over path",
+			params:     map[string]string{"slug": "p"},
+			precedence: []int{LocationQuery},
+			target:     "/?slug=q",
+			want:       request{Name: "a", Slug: "q"},
+		},
+		{
+			name:      "instead of the extractor",
+			params:    map[string]string{"id": "1"},
+			extractor: true,
+			target:    "/",
+			want:      request{ID: 1, Name: "a"},
+		},
+		{
+			name:      "nil params use the extractor",
+			extractor: true,
+			target:    "/?slug=q",
+			want:      request{ID: 9, Name: "a", Slug: "extracted"},
+		},
+		{
+			name:   "nil params without extractor",
+			target: "/?slug=q",
+			want:   request{Name: "a", Slug: "q"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			if tt.extractor {
+				d.PathExtractor(func(r *http.Request) map[string]string {
+					return extracted
+				})
+			}
+			if tt.precedence != nil {
+				d.LocationPrecedence(tt.precedence)
+			}
+			var dst request
+			err := d.DecodeWithPathParams(&dst, newRequest("POST", tt.target, "application/json", `{"name":"a"}`), tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONShape(t *testing.T) {
+	type item struct {
+		SKU string `json:"sku"`
+	}
+	type body struct {
+		IDs  []int `json:"ids"`
+		Item item  `json:"item"`
+	}
+	type request struct {
+		Page  int    `query:"page"`
+		IDs   []int  `json:"ids"`
+		Items []item `json:"items"`
+		Item  item   `json:"item"`
+	}
+	tests := []struct {
+		name    string
+		dst     interface{}
+		direct  bool
+		body    string
+		key     string
+		want    JSONShapeError
+		wantDst interface{}
+	}{
+		{name: "array body", dst: &body{}, body: `[{"ids":[1]}]`, want: JSONShapeError{Expected: "object", Got: "array"}},
+		{name: "array body flattened", dst: &request{}, body: `[{"ids":[1]}]`, want: JSONShapeError{Expected: "object", Got: "array"}},
+		{name: "scalar body", dst: &request{}, body: `"ids"`, want: JSONShapeError{Expected: "object", Got: "string"}},
+		{name: "object for slice", dst: &request{}, body: `{"ids":{"a":1}}`, key: "ids", want: JSONShapeError{Expected: "array", Got: "object"}},
+		{name: "object for slice of structs", dst: &request{}, body: `{"items":{"sku":"a"}}`, key: "items", want: JSONShapeError{Expected: "array", Got: "object"}},
+		{name: "array for struct", dst: &request{}, body: `{"item":[{"sku":"a"}]}`, key: "item", want: JSONShapeError{Expected: "object", Got: "array"}},
+		{name: "object for slice directly", dst: &request{}, direct: true, body: `{"ids":{"a":1}}`, key: "ids", want: JSONShapeError{Expected: "array", Got: "object"}},
+		{name: "array for struct directly", dst: &request{}, direct: true, body: `{"item":[1]}`, key: "item", want: JSONShapeError{Expected: "object", Got: "array"}},
+		{name: "indexed object for slice", dst: &request{}, body: `{"ids":{"0":1,"1":2},"items":{"0":{"sku":"a"}}}`, wantDst: &request{IDs: []int{1, 2}, Items: []item{{SKU: "a"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.DirectJSON(tt.direct)
+			err := d.Decode(tt.dst, newRequest("POST", "/", "application/json", tt.body))
+			if tt.wantDst != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(tt.dst, tt.wantDst) {
+					t.Errorf("got %+v, want %+v", tt.dst, tt.wantDst)
+				}
+				return
+			}
+			if tt.key != "" {
+				e, ok := keyError(err, tt.key).(ConversionError)
+				if !ok {
+					t.Fatalf("got error %v, want a ConversionError", err)
+				}
+				err = e.Err
+			}
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("got error %#v, want %#v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRemainder(t *testing.T) {
+	type jsonRequest struct {
+		Name  string                 `json:"name"`
+		Extra map[string]interface{} `json:",remainder"`
+	}
+	type queryRequest struct {
+		Page  int                    `query:"page"`
+		Extra map[string]interface{} `query:",remainder"`
+	}
+	tests := []struct {
+		name    string
+		dst     interface{}
+		target  string
+		body    string
+		unknown bool
+		want    interface{}
+	}{
+		{name: "json", dst: &jsonRequest{}, body: `{"name":"a","age":3,"tags":["x"],"meta":{"k":"v"}}`, want: &jsonRequest{Name: "a", Extra: map[string]interface{}{"age": json.Number("3"), "tags": []interface{}{"x"}, "meta": map[string]interface{}{"k": "v"}}}},
+		{name: "json without unknown keys", dst: &jsonRequest{}, body: `{"name":"a"}`, want: &jsonRequest{Name: "a"}},
+		{name: "json not reported", dst: &jsonRequest{}, unknown: true, body: `{"age":3}`, want: &jsonRequest{Extra: map[string]interface{}{"age": json.Number("3")}}},
+		{name: "query", dst: &queryRequest{}, target: "/?page=2&sort=name&tag=a&tag=b", want: &queryRequest{Page: 2, Extra: map[string]interface{}{"sort": "name", "tag": []string{"a", "b"}}}},
+		{name: "query only", dst: &queryRequest{}, target: "/?page=2", body: `{"other":1}`, want: &queryRequest{Page: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.IgnoreUnknownKeys(!tt.unknown)
+			target, body := tt.target, tt.body
+			if target == "" {
+				target = "/"
+			}
+			if body == "" {
+				body = "{}"
+			}
+			if err := d.Decode(tt.dst, newRequest("POST", target, "application/json", body)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+type EmbeddedRemainder struct {
+	Extra map[string]interface{} `query:",remainder"`
+}
+
+func TestDecodeRemainderEmbeddedPointer(t *testing.T) {
+	type request struct {
+		Page int `query:"page"`
+		*EmbeddedRemainder
+	}
+	var dst request
+	if err := NewDecoder().Decode(&dst, newRequest("GET", "/?page=2&sort=name", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	want := request{Page: 2, EmbeddedRemainder: &EmbeddedRemainder{Extra: map[string]interface{}{"sort": "name"}}}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+}
+
+type checksum [4]byte
+
+var errChecksumLen = errors.New("checksum must be 4 bytes")
+
+func (c *checksum) UnmarshalBinary(data []byte) error {
+	if len(data) != len(c) {
+		return errChecksumLen
+	}
+	copy(c[:], data)
+	return nil
+}
+
+func TestDecodeBinaryUnmarshaler(t *testing.T) {
+	type request struct {
+		Sum checksum  `query:"sum"`
+		Hex checksum  `query:"hex" encoding:"hex"`
+		Ptr *checksum `query:"ptr" encoding:"base64url"`
+	}
+	tests := []struct {
+		name      string
+		zeroEmpty bool
+		target    string
+		want      request
+		wantKey   string
+		wantErr   error
+	}{
+		{name: "base64", target: "/?sum=AQIDBA==", want: request{Sum: checksum{1, 2, 3, 4}}},
+		{name: "hex", target: "/?hex=0a0b0c0d", want: request{Hex: checksum{10, 11, 12, 13}}},
+		{name: "pointer", target: "/?ptr=-_-_-w", want: request{Ptr: &checksum{0xfb, 0xff, 0xbf, 0xfb}}},
+		{name: "empty", target: "/?sum=", want: request{}},
+		{name: "empty zeroed", zeroEmpty: true, target: "/?sum=", want: request{}},
+		{name: "invalid encoding", target: "/?hex=zz", wantKey: "hex"},
+		{name: "rejected", target: "/?sum=AQI=", wantKey: "sum", wantErr: errChecksumLen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.ZeroEmpty(tt.zeroEmpty)
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.wantKey != "" {
+				e, ok := keyError(err, tt.wantKey).(ConversionError)
+				if !ok || (tt.wantErr != nil && !errors.Is(e, tt.wantErr)) {
+					t.Errorf("got %v, want a ConversionError for %q", err, tt.wantKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCookies(t *testing.T) {
+	type request struct {
+		Session string   `cookie:"session"`
+		Prefs   []string `cookie:"pref"`
+		Theme   string   `name:"theme" from:"cookie,query"`
+		Count   int      `cookie:"count"`
+	}
+	tests := []struct {
+		name    string
+		target  string
+		cookies []*http.Cookie
+		want    request
+		wantErr string
+	}{
+		{name: "single", cookies: []*http.Cookie{{Name: "session", Value: "abc"}}, want: request{Session: "abc"}},
+		{name: "repeated", cookies: []*http.Cookie{{Name: "pref", Value: "a"}, {Name: "pref", Value: "b"}}, want: request{Prefs: []string{"a", "b"}}},
+		{name: "cookie over query", target: "/?theme=light", cookies: []*http.Cookie{{Name: "theme", Value: "dark"}}, want: request{Theme: "dark"}},
+		{name: "query only", target: "/?theme=light", want: request{Theme: "light"}},
+		{name: "invalid", cookies: []*http.Cookie{{Name: "count", Value: "x"}}, wantErr: "count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := newRequest("GET", target, "", "")
+			for _, c := range tt.cookies {
+				r.AddCookie(c)
+			}
+			var dst request
+			err := NewDecoder().Decode(&dst, r)
+			if tt.wantErr != "" {
+				if _, ok := keyError(err, tt.wantErr).(ConversionError); !ok {
+					t.Fatalf("got %v, want a ConversionError for %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFlatKeys(t *testing.T) {
+	type user struct {
+		Name string `query:"name"`
+	}
+	type request struct {
+		UserName string `query:"user.name"`
+		Page     string `query:"page.size,flat"`
+		User     user   `query:"user"`
+	}
+	tests := []struct {
+		name   string
+		flat   bool
+		target string
+		want   request
+	}{
+		{name: "flat option", target: "/?page.size=10", want: request{Page: "10"}},
+		{name: "nested by default", target: "/?user.name=a", want: request{User: user{Name: "a"}}},
+		{name: "flat keys", flat: true, target: "/?user.name=a", want: request{UserName: "a"}},
+		{name: "flat keys and nested", flat: true, target: "/?user.name=a&page.size=10", want: request{UserName: "a", Page: "10"}},
+		{name: "case insensitive", flat: true, target: "/?User.Name=a&PAGE.SIZE=10", want: request{UserName: "a", Page: "10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.FlatKeys(tt.flat)
+			var dst request
+			if err := d.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+	t.Run("brackets", func(t *testing.T) {
+		d := NewDecoder()
+		d.Separator('[', ']', 0)
+		var dst request
+		if err := d.Decode(&dst, newRequest("GET", "/?page.size=10&user[name]=a", "", "")); err != nil {
+			t.Fatal(err)
+		}
+		if want := (request{Page: "10", User: user{Name: "a"}}); !reflect.DeepEqual(dst, want) {
+			t.Errorf("got %+v, want %+v", dst, want)
+		}
+	})
+}
+
+type siblingAddress struct {
+	Street string `query:"street"`
+	City   string `query:"city"`
+}
+
+type siblingUser struct {
+	Name    string          `query:"name"`
+	Age     int             `query:"age"`
+	Address *siblingAddress `query:"address"`
+}
+
+type siblingsRequest struct {
+	User   *siblingUser  `query:"user"`
+	Admins []siblingUser `query:"admins"`
+}
+
+func TestDecodeSiblings(t *testing.T) {
+	tests := []struct {
+		name    string
+		lazyNil bool
+		target  string
+		want    siblingsRequest
+	}{
+		{
+			name:   "pointer parent",
+			target: "/?user.name=a&user.age=2",
+			want:   siblingsRequest{User: &siblingUser{Name: "a", Age: 2}},
+		},
+		{
+			name:   "nested pointer parents",
+			target: "/?user.address.street=s&user.name=a&user.address.city=c",
+			want:   siblingsRequest{User: &siblingUser{Name: "a", Address: &siblingAddress{Street: "s", City: "c"}}},
+		},
+		{
+			name:    "lazy nil",
+			lazyNil: true,
+			target:  "/?user.address.street=s&user.address.city=c&user.age=2",
+			want:    siblingsRequest{User: &siblingUser{Age: 2, Address: &siblingAddress{Street: "s", City: "c"}}},
+		},
+		{
+			name:   "slice elements",
+			target: "/?admins.0.name=a&admins.1.name=b&admins.0.address.city=c&admins.1.age=2",
+			want: siblingsRequest{Admins: []siblingUser{
+				{Name: "a", Address: &siblingAddress{City: "c"}},
+				{Name: "b", Age: 2},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.LazyNil(tt.lazyNil)
+			var dst siblingsRequest
+			if err := d.Decode(&dst, newRequest("GET", tt.target, "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeSiblings(b *testing.B) {
+	type leaf struct {
+		A string `query:"a"`
+		B string `query:"b"`
+		C string `query:"c"`
+		D int    `query:"d"`
+		E int    `query:"e"`
+		F int    `query:"f"`
+		G bool   `query:"g"`
+		H bool   `query:"h"`
+	}
+	type middle struct {
+		Leaf *leaf `query:"leaf"`
+	}
+	type request struct {
+		Middle *middle `query:"middle"`
+	}
+	q := make([]string, 0, 8)
+	for _, kv := range []string{"a=x", "b=y", "c=z", "d=1", "e=2", "f=3", "g=true", "h=false"} {
+		q = append(q, "middle.leaf."+kv)
+	}
+	target := "/?" + strings.Join(q, "&")
+	d := NewDecoder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst request
+		if err := d.Decode(&dst, newRequest("GET", target, "", "")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeNestedSlicesOfStructs(t *testing.T) {
+	type option struct {
+		Name string `json:"name"`
+	}
+	type item struct {
+		SKU     string    `json:"sku"`
+		Options []*option `json:"options"`
+	}
+	type order struct {
+		ID    int    `json:"id"`
+		Items []item `json:"items"`
+	}
+	type request struct {
+		Orders []order `json:"orders"`
+		Page   int     `query:"page"`
+	}
+	tests := []struct {
+		name     string
+		location string
+		lazyNil  bool
+		params   string
+		want     []order
+	}{
+		{
+			name:     "two levels",
+			location: "query",
+			params:   "orders.0.id=1&orders.0.items.0.sku=a&orders.0.items.1.sku=b&orders.1.items.0.sku=c",
+			want: []order{
+				{ID: 1, Items: []item{{SKU: "a"}, {SKU: "b"}}},
+				{Items: []item{{SKU: "c"}}},
+			},
+		},
+		{
+			name:     "three levels",
+			location: "query",
+			params:   "orders.0.items.1.options.1.name=x&orders.0.items.1.options.0.name=y",
+			want: []order{
+				{Items: []item{{Options: []*option{{Name: "y"}, {Name: "x"}}}}},
+			},
+		},
+		{
+			// Indices identify the elements, which are kept in their order.
+			name:     "out of order and missing indices",
+			location: "query",
+			params:   "orders.1.items.2.sku=c&orders.1.items.0.sku=a&orders.0.id=1",
+			want: []order{
+				{ID: 1},
+				{Items: []item{{SKU: "a"}, {SKU: "c"}}},
+			},
+		},
+		{
+			name:     "lazy nil",
+			location: "query",
+			lazyNil:  true,
+			params:   "orders.0.items.0.options.0.name=x&orders.0.items.0.sku=a",
+			want: []order{
+				{Items: []item{{SKU: "a", Options: []*option{{Name: "x"}}}}},
+			},
+		},
+		{
+			name:     "json",
+			location: "json",
+			params:   `{"orders":[{"id":1,"items":[{"sku":"a","options":[{"name":"x"}]},{"sku":"b"}]},{"items":[{"sku":"c"}]}]}`,
+			want: []order{
+				{ID: 1, Items: []item{{SKU: "a", Options: []*option{{Name: "x"}}}, {SKU: "b"}}},
+				{Items: []item{{SKU: "c"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.LazyNil(tt.lazyNil)
+			r := newRequest("POST", "/?page=1", "application/json", tt.params)
+			if tt.location == "query" {
+				d.OverrideLocation("orders", LocationQuery)
+				r = newRequest("GET", "/?page=1&"+tt.params, "", "")
+			}
+			var dst request
+			if err := d.Decode(&dst, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst.Orders, tt.want) {
+				t.Errorf("got %+v, want %+v", dst.Orders, tt.want)
+			}
+		})
+	}
+}
+
+type csvIDs []int
+
+func TestDecodeExplode(t *testing.T) {
+	type request struct {
+		Tags     []string `query:"tags"`
+		Exploded []string `query:"exploded,explode"`
+		IDs      []int    `query:"ids"`
+		Each     []int    `query:"each,explode"`
+		Custom   csvIDs   `query:"custom,explode"`
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   request
+		key    string
+	}{
+		{name: "one value", target: "/?tags=a", want: request{Tags: []string{"a"}}},
+		{name: "one value exploded", target: "/?exploded=a", want: request{Exploded: []string{"a"}}},
+		{name: "repeated values", target: "/?tags=a&tags=b", want: request{Tags: []string{"a", "b"}}},
+		{name: "repeated values exploded", target: "/?exploded=a&exploded=b", want: request{Exploded: []string{"a", "b"}}},
+		{name: "commas", target: "/?ids=1,2", want: request{IDs: []int{1, 2}}},
+		{name: "one number exploded", target: "/?each=1", want: request{Each: []int{1}}},
+		{name: "repeated numbers exploded", target: "/?each=1&each=2", want: request{Each: []int{1, 2}}},
+		{name: "commas exploded", target: "/?each=1,2", key: "each"},
+		{name: "slice converter", target: "/?custom=1,2,3", want: request{Custom: csvIDs{1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.RegisterConverter(csvIDs(nil), func(value string) reflect.Value {
+				var ids csvIDs
+				for _, s := range strings.Split(value, ",") {
+					id, err := strconv.Atoi(s)
+					if err != nil {
+						return reflect.Value{}
+					}
+					ids = append(ids, id)
+				}
+				return reflect.ValueOf(ids)
+			})
+			var dst request
+			err := d.Decode(&dst, newRequest("GET", tt.target, "", ""))
+			if tt.key != "" {
+				if _, ok := keyError(err, tt.key).(ConversionError); !ok {
+					t.Errorf("got error %v, want a ConversionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("got %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
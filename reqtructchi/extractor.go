@@ -0,0 +1,26 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reqtructchi provides a reqtruct path extractor for go-chi/chi.
+package reqtructchi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PathExtractor extracts path params from a request routed by go-chi/chi.
+// It can be passed to reqtruct.Decoder.PathExtractor.
+func PathExtractor(r *http.Request) map[string]string {
+	m := map[string]string{}
+	ctx := chi.RouteContext(r.Context())
+	if ctx == nil {
+		return m
+	}
+	for i, k := range ctx.URLParams.Keys {
+		m[k] = ctx.URLParams.Values[i]
+	}
+	return m
+}
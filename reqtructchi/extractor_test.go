@@ -0,0 +1,45 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtructchi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPathExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		target  string
+		want    map[string]string
+	}{
+		{name: "params", pattern: "/users/{userId}/posts/{postId}", target: "/users/1/posts/2", want: map[string]string{"userId": "1", "postId": "2"}},
+		{name: "no params", pattern: "/users", target: "/users", want: map[string]string{}},
+		{name: "catch-all", pattern: "/files/*", target: "/files/a/b", want: map[string]string{"*": "a/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			r := chi.NewRouter()
+			r.Get(tt.pattern, func(w http.ResponseWriter, r *http.Request) {
+				got = PathExtractor(r)
+			})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("not routed", func(t *testing.T) {
+		if got := PathExtractor(httptest.NewRequest("GET", "/users/1", nil)); len(got) != 0 {
+			t.Errorf("got %v, want no params", got)
+		}
+	})
+}
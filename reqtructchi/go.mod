@@ -0,0 +1,5 @@
+module github.com/wlMalk/reqtruct/reqtructchi
+
+go 1.13
+
+require github.com/go-chi/chi/v5 v5.0.8
@@ -0,0 +1,18 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reqtructmux provides a reqtruct path extractor for gorilla/mux.
+package reqtructmux
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// PathExtractor extracts path params from a request routed by gorilla/mux.
+// It can be passed to reqtruct.Decoder.PathExtractor.
+func PathExtractor(r *http.Request) map[string]string {
+	return mux.Vars(r)
+}
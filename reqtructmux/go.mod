@@ -0,0 +1,5 @@
+module github.com/wlMalk/reqtruct/reqtructmux
+
+go 1.13
+
+require github.com/gorilla/mux v1.8.0
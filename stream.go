@@ -0,0 +1,161 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+)
+
+// DecodeStream decodes a JSON body made of an array of objects one element
+// at a time, without reading the whole array first, which is useful for
+// bulk endpoints receiving large arrays.
+//
+// The second parameter is a struct, or a pointer to a struct, of the type
+// of the elements. Each element is decoded to a new struct of the type
+// like a JSON body by Decode, so the tags, converters and options of the
+// decoder apply, and fn is called with a pointer to it.
+// Decoding stops at the first error decoding an element, which is returned
+// like by Decode, or at the first error returned by fn, which is returned
+// as is.
+//
+// The limit set by MaxBodySize, the timeout set by ReadTimeout, the
+// decompression of bodies, the Content-Type requirement and AllowEmptyBody
+// apply to the body, while the params in other locations are not decoded.
+// The timeout includes the time spent in fn.
+func (d *Decoder) DecodeStream(r *http.Request, elem interface{}, fn func(interface{}) error) (err error) {
+	t := reflect.TypeOf(elem)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return errors.New("element must be a struct or a pointer to struct")
+	}
+	if d.requireContentType && !isJSON(r) {
+		return ContentTypeError{RequestContentType: r.Header.Get("Content-Type"), ContentType: "application/json"}
+	}
+	if len(d.disallowed) > 0 {
+		if err = d.cache.disallowedError(t, func() error {
+			return d.checkDisallowed(t, nil, nil, map[reflect.Type]bool{})
+		}); err != nil {
+			return err
+		}
+	}
+	// The body is replaced to limit, time and decompress it,
+	// which must not change the request of the caller.
+	body := r.Body
+	defer func() { r.Body = body }()
+	if r.Body == nil || r.Body == http.NoBody {
+		r.Body = http.NoBody
+	}
+	if d.readTimeout > 0 && r.Body != http.NoBody {
+		b := d.timeBody(r)
+		defer func() {
+			if b.stop() {
+				body = expiredBody{timeout: d.readTimeout}
+			}
+			if b.expired {
+				err = TimeoutError{Timeout: d.readTimeout}
+			}
+		}()
+	}
+	if d.decompressBodies {
+		if err = decompressBody(r); err != nil {
+			return err
+		}
+		defer r.Body.Close()
+	}
+	if d.maxBodySize > 0 {
+		if r.ContentLength > d.maxBodySize {
+			return BodyTooLargeError{Limit: d.maxBodySize}
+		}
+		b := d.limitBody(r)
+		defer func() {
+			if b.remaining < 0 {
+				err = BodyTooLargeError{Limit: d.maxBodySize}
+			}
+		}()
+	}
+
+	// Elements are decoded with encoding/json when Decode would do so,
+	// or otherwise each like the body of a request of its own.
+	direct := d.decodesJSONDirectly(d.withOverrides(d.cache.get(t)))
+	c := *d
+	c.readTimeout, c.maxBodySize, c.decompressBodies = 0, 0, false
+	dec := json.NewDecoder(r.Body)
+	if direct && !d.ignoreUnknownKeys {
+		dec.DisallowUnknownFields()
+	}
+	tok, err := dec.Token()
+	if err == io.EOF && d.allowEmptyBody {
+		return nil
+	} else if err != nil {
+		return ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return JSONShapeError{Expected: "array", Got: tokenKind(tok)}
+	}
+	for i := 0; dec.More(); i++ {
+		v := reflect.New(t)
+		if direct {
+			if err = dec.Decode(v.Interface()); err != nil {
+				return ParsingError{Err: fmt.Errorf("cannot unmarshal JSON array element %d", i), WrappedErr: err}
+			}
+		} else {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err != nil {
+				return ParsingError{Err: fmt.Errorf("cannot unmarshal JSON array element %d", i), WrappedErr: err}
+			}
+			if err = c.decodeRequest(v.Interface(), elementRequest(r, raw), nil); err != nil {
+				return err
+			}
+		}
+		if err = fn(v.Interface()); err != nil {
+			return err
+		}
+	}
+	if _, err = dec.Token(); err != nil {
+		return ParsingError{Err: fmt.Errorf("cannot unmarshal JSON"), WrappedErr: err}
+	}
+	return nil
+}
+
+// elementRequest returns a request with the JSON element as its body,
+// and the context of r.
+func elementRequest(r *http.Request, elem json.RawMessage) *http.Request {
+	er := &http.Request{
+		Method:        http.MethodPost,
+		URL:           &url.URL{},
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(elem)),
+		ContentLength: int64(len(elem)),
+	}
+	return er.WithContext(r.Context())
+}
+
+// tokenKind returns the kind of the JSON value starting with the token.
+func tokenKind(tok json.Token) string {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Waleed AlMalki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reqtruct
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type streamItem struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty"`
+}
+
+type streamReading struct {
+	Sensor string   `json:"sensor" enum:"a,b"`
+	Temp   *celsius `json:"temp"`
+}
+
+var errStop = errors.New("stop")
+
+func TestDecodeStream(t *testing.T) {
+	tests := []struct {
+		name string
+		elem interface{}
+		body string
+		stop int
+		want []interface{}
+	}{
+		{
+			name: "elements",
+			elem: streamItem{},
+			body: `[{"sku":"a","qty":1},{"sku":"b","qty":2},{"sku":"c"}]`,
+			want: []interface{}{&streamItem{SKU: "a", Qty: 1}, &streamItem{SKU: "b", Qty: 2}, &streamItem{SKU: "c"}},
+		},
+		{
+			name: "pointer element",
+			elem: &streamItem{},
+			body: `[{"sku":"a"}]`,
+			want: []interface{}{&streamItem{SKU: "a"}},
+		},
+		{name: "empty array", elem: streamItem{}, body: `[]`},
+		{
+			name: "early termination",
+			elem: streamItem{},
+			body: `[{"sku":"a"},{"sku":"b"},{"sku":"c"}]`,
+			stop: 2,
+			want: []interface{}{&streamItem{SKU: "a"}, &streamItem{SKU: "b"}},
+		},
+		{
+			name: "tags and converters",
+			elem: streamReading{},
+			body: `[{"sensor":"A","temp":"21.5"},{"sensor":"b"}]`,
+			want: []interface{}{&streamReading{Sensor: "a", Temp: celsiusPtr(21.5)}, &streamReading{Sensor: "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []interface{}
+			err := NewDecoder().DecodeStream(newRequest("POST", "/", "application/json", tt.body), tt.elem, func(v interface{}) error {
+				got = append(got, v)
+				if len(got) == tt.stop {
+					return errStop
+				}
+				return nil
+			})
+			if tt.stop > 0 {
+				if err != errStop {
+					t.Errorf("got error %v, want %v", err, errStop)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func celsiusPtr(c celsius) *celsius {
+	return &c
+}
+
+func TestDecodeStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		elem    interface{}
+		body    string
+		unknown bool
+		calls   int
+		check   func(err error) bool
+	}{
+		{
+			name:  "not an array",
+			elem:  streamItem{},
+			body:  `{"sku":"a"}`,
+			check: func(err error) bool { return reflect.DeepEqual(err, JSONShapeError{Expected: "array", Got: "object"}) },
+		},
+		{
+			name:  "not a struct",
+			elem:  "",
+			body:  `[]`,
+			check: func(err error) bool { return err != nil },
+		},
+		{
+			name:  "truncated",
+			elem:  streamItem{},
+			body:  `[{"sku":"a"},{"sku"`,
+			calls: 1,
+			check: func(err error) bool {
+				_, ok := err.(ParsingError)
+				return ok
+			},
+		},
+		{
+			name: "conversion",
+			elem: streamReading{},
+			body: `[{"temp":"-300"}]`,
+			check: func(err error) bool {
+				return errors.Is(keyError(err, "temp"), errBelowAbsoluteZero)
+			},
+		},
+		{
+			name: "enum",
+			elem: streamReading{},
+			body: `[{"sensor":"c"}]`,
+			check: func(err error) bool {
+				_, ok := keyError(err, "sensor").(EnumError)
+				return ok
+			},
+		},
+		{
+			name:    "unknown key",
+			elem:    streamReading{},
+			body:    `[{"sensor":"a","other":1}]`,
+			unknown: true,
+			check: func(err error) bool {
+				_, ok := keyError(err, "other").(UnknownKeyError)
+				return ok
+			},
+		},
+		{
+			name:    "unknown key decoded directly",
+			elem:    streamItem{},
+			body:    `[{"sku":"a","other":1}]`,
+			unknown: true,
+			check: func(err error) bool {
+				_, ok := err.(ParsingError)
+				return ok
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder()
+			d.IgnoreUnknownKeys(!tt.unknown)
+			calls := 0
+			err := d.DecodeStream(newRequest("POST", "/", "application/json", tt.body), tt.elem, func(v interface{}) error {
+				calls++
+				return nil
+			})
+			if !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+			if calls != tt.calls {
+				t.Errorf("got %d calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+	t.Run("unknown keys ignored", func(t *testing.T) {
+		var got []interface{}
+		err := NewDecoder().DecodeStream(newRequest("POST", "/", "application/json", `[{"sku":"a","other":1}]`), streamItem{}, func(v interface{}) error {
+			got = append(got, v)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []interface{}{&streamItem{SKU: "a"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+}